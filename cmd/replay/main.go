@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/db"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// replay re-submits messages of the dead-letter file that could not be saved by cmd/save
+func main() {
+	file := flag.String("file", "", "dead-letter file, mongo.dead_letter_path of the config by default")
+	flag.Parse()
+	logger := config.InitConsoleLogger(slog.LevelDebug)
+
+	conf, err := config.Load("etc/config.yml")
+	if err != nil {
+		logger.Error("can't load config", "err", err)
+		os.Exit(1)
+	}
+	path := *file
+	if path == "" {
+		path = conf.Mongo.DeadLetterPath
+	}
+	if path == "" {
+		logger.Error("no dead-letter file, set -file or mongo.dead_letter_path")
+		os.Exit(1)
+	}
+
+	mongoDB := db.NewMongoDB(logger, conf.Mongo)
+	err = mongoDB.Init()
+	if err != nil {
+		logger.Error("db connection failed", "err", err)
+		os.Exit(1)
+	}
+	defer mongoDB.Close()
+
+	repo := repositories.NewMessageRepository(logger, mongoDB)
+	result, err := repo.ReplayDeadLetters(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("nothing to replay", "path", path)
+		return
+	}
+	logger.Info("dead letters replayed", "path", path, "inserted", result.Inserted, "modified", result.Modified,
+		"unchanged", result.Unchanged, "failed", result.Failed)
+	if err != nil {
+		logger.Error("replay failed", "err", err)
+		mongoDB.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/storage"
+	"github.com/meesooqa/tgtag/internal/tags"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// retag re-applies tag normalization rules of the config to saved raw tags without re-parsing exports
+func main() {
+	logger := config.InitConsoleLogger(slog.LevelDebug)
+
+	conf, err := config.Load("etc/config.yml")
+	if err != nil {
+		logger.Error("can't load config", "err", err)
+		os.Exit(1)
+	}
+
+	repo, closeRepo, err := storage.Open(logger, conf)
+	if err != nil {
+		logger.Error("db connection failed", "err", err)
+		os.Exit(1)
+	}
+	defer closeRepo()
+
+	ctx := context.Background()
+	normalizer := tags.NewNormalizer(conf.System.Tags)
+
+	total, updated := 0, 0
+	for msg, err := range repo.FindMessages(ctx, repositories.MessageQuery{IncludeDeleted: true}) {
+		if err != nil {
+			logger.Error("can't load messages", "err", err)
+			closeRepo()
+			os.Exit(1)
+		}
+		total++
+		// messages saved before normalization have no raw tags, their tags are raw
+		rawTags := msg.RawTags
+		if rawTags == nil {
+			rawTags = msg.Tags
+		}
+		normalized := normalizer.Normalize(rawTags)
+		if msg.RawTags != nil && slices.Equal(normalized, msg.Tags) {
+			continue
+		}
+		if err := repo.UpdateTags(ctx, msg.UUID, rawTags, normalized); err != nil {
+			logger.Error("can't update tags", "uuid", msg.UUID, "err", err)
+			continue
+		}
+		updated++
+	}
+	logger.Info("tags re-applied", "messages", total, "updated", updated)
+}
@@ -1,44 +1,96 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log/slog"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/meesooqa/tgtag/internal/config"
-	"github.com/meesooqa/tgtag/internal/db"
 	"github.com/meesooqa/tgtag/internal/fs"
 	"github.com/meesooqa/tgtag/internal/proc"
+	"github.com/meesooqa/tgtag/internal/storage"
 	"github.com/meesooqa/tgtag/internal/tg"
 	"github.com/meesooqa/tgtag/pkg/repositories"
 )
 
 func main() {
+	force := flag.Bool("force", false, "parse all files, even unchanged ones")
+	watch := flag.Bool("watch", false, "keep running and save new or changed files until SIGINT")
+	interval := flag.Duration("interval", 10*time.Second, "how often the data path is polled in watch mode")
+	debounce := flag.Duration("debounce", 30*time.Second, "how long files must stay unchanged before they are saved in watch mode")
+	flag.Parse()
 	logger := config.InitConsoleLogger(slog.LevelDebug)
 
-	var wg sync.WaitGroup
 	conf, err := config.Load("etc/config.yml")
 	if err != nil {
 		logger.Error("can't load config", "err", err)
 	}
 
-	wg.Add(1)
-	filesChan := make(chan string, 2)
-	finder := fs.NewFinder(logger)
-	go finder.FindFiles(conf.System.DataPath, filesChan, &wg)
-
-	wg.Add(1)
-	mongoDB := db.NewMongoDB(logger, conf.Mongo)
-	err = mongoDB.Init()
+	repo, closeRepo, err := storage.Open(logger, conf)
 	if err != nil {
 		logger.Error("db connection failed", "err", err)
+		os.Exit(1)
 	}
-	defer mongoDB.Close()
+	defer closeRepo()
 
-	repo := repositories.NewMessageRepository(logger, mongoDB)
 	tgService := tg.NewService(logger, conf.System)
-	processor := proc.NewProcessor(logger, tgService, repo)
+
+	if !*watch {
+		if err := ingest(logger, conf.System, tgService, repo, *force); err != nil {
+			logger.Error("processing failed", "err", err)
+			closeRepo()
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	changesChan := make(chan struct{})
+	watcher := fs.NewWatcher(logger, *interval, *debounce)
+	go watcher.Watch(ctx, conf.System.DataPath, changesChan)
+	logger.Info("watching data path", "path", conf.System.DataPath, "interval", *interval, "debounce", *debounce)
+
+	for range changesChan {
+		// the manifest skips files saved by previous runs, so only new and changed files are parsed
+		if err := ingest(logger, conf.System, tgService, repo, *force); err != nil {
+			logger.Error("processing failed", "err", err)
+		}
+		// force is for the first run only
+		*force = false
+	}
+	logger.Info("watching stopped")
+}
+
+// ingest runs Finder → Processor → repository over the data path once
+func ingest(logger *slog.Logger, conf *config.SystemConfig, tgService tg.Service, repo repositories.Repository, force bool) error {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	filesChan := make(chan fs.ExportFile, 2)
+	finder := fs.NewFinder(logger)
+	go finder.FindFiles(conf.DataPath, filesChan, &wg)
+
+	wg.Add(1)
+	processor := proc.NewProcessor(logger, conf, tgService, repo)
+	processor.SetForce(force)
 	go processor.ProcessFile(filesChan, &wg)
 
 	wg.Wait()
-	logger.Info("all goroutines are done")
+	if err := finder.Close(); err != nil {
+		logger.Error("can't close archives", "err", err)
+	}
+	result := processor.Result()
+	logger.Info("all goroutines are done", "runID", processor.RunID(), "inserted", result.Inserted,
+		"modified", result.Modified, "unchanged", result.Unchanged, "failed", result.Failed)
+	for _, s := range processor.Stats() {
+		logger.Info("worker stats", "worker", s.Worker, "files", s.Files, "unchanged", s.Unchanged,
+			"failed", s.Failed, "messages", s.Messages, "busy", s.Busy)
+	}
+	return processor.Err()
 }
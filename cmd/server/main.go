@@ -5,14 +5,14 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/meesooqa/tgtag/internal/config"
-	"github.com/meesooqa/tgtag/internal/db"
+	"github.com/meesooqa/tgtag/internal/storage"
 	"github.com/meesooqa/tgtag/internal/web"
 	"github.com/meesooqa/tgtag/pkg/controllers"
 	"github.com/meesooqa/tgtag/pkg/extensions"
-	"github.com/meesooqa/tgtag/pkg/repositories"
 )
 
 func main() {
@@ -24,14 +24,13 @@ func main() {
 		logger.Error("can't load config", "err", err)
 	}
 
-	mongoDB := db.NewMongoDB(logger, conf.Mongo)
-	err = mongoDB.Init()
+	repo, closeRepo, err := storage.Open(logger, conf)
 	if err != nil {
 		logger.Error("db connection failed", slog.Any("err", err))
+		os.Exit(1)
 	}
-	defer mongoDB.Close()
+	defer closeRepo()
 
-	repo := repositories.NewMessageRepository(logger, mongoDB)
 	registerExtensions(repo)
 
 	mux := http.NewServeMux()
@@ -0,0 +1,83 @@
+package runs_ext
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/meesooqa/tgtag/pkg/controllers"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// defaultLimit is the number of the last runs listed
+const defaultLimit = 50
+
+// RunsController lists runs, with "?id=" it shows the run with its files
+type RunsController struct {
+	controllers.BaseController
+	repo repositories.Repository
+}
+
+func NewRunsController(repo repositories.Repository) *RunsController {
+	c := &RunsController{
+		BaseController: controllers.BaseController{
+			MethodApi:  http.MethodGet,
+			RouteApi:   "/api/runs",
+			Method:     http.MethodGet,
+			Route:      "/runs",
+			Title:      "Ingest Runs",
+			ContentTpl: "template/content/runs.html",
+			Children: []controllers.Controller{
+				NewTagChangesController(repo),
+			},
+		},
+		repo: repo,
+	}
+	c.Self = c
+	return c
+}
+
+func (c *RunsController) GetApiData(r *http.Request) map[string]any {
+	apiData, err := c.getData(r)
+	if err != nil {
+		c.Log.Error("getting api data", slog.Any("err", err))
+		return nil
+	}
+	return map[string]any{"data": apiData}
+}
+
+func (c *RunsController) GetTplData(r *http.Request) map[string]any {
+	contentData := map[string]any{
+		"Title": c.GetTitle(),
+		"Group": "",
+	}
+	apiData, err := c.getData(r)
+	if err != nil {
+		c.Log.Error("getting runs", slog.Any("err", err))
+	}
+	if r.URL.Query().Get("id") != "" {
+		contentData["Run"] = apiData
+	} else {
+		contentData["Runs"] = apiData
+	}
+	tplData, err := c.Tpl.GetData(r, contentData)
+	if err != nil {
+		c.Log.Error("getting template data", slog.Any("err", err))
+		return nil
+	}
+	return tplData
+}
+
+// getData returns the run of "?id=" or the last "?limit=" runs
+func (c *RunsController) getData(r *http.Request) (any, error) {
+	query := r.URL.Query()
+	if id := query.Get("id"); id != "" {
+		return c.repo.GetIngestRun(context.TODO(), id)
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	return c.repo.GetIngestRuns(context.TODO(), limit)
+}
@@ -0,0 +1,31 @@
+package runs_ext
+
+import (
+	"embed"
+
+	"github.com/meesooqa/tgtag/pkg/controllers"
+	"github.com/meesooqa/tgtag/pkg/extensions"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+//go:embed template/content/*.html
+var fsContentTpl embed.FS
+
+//go:embed template/static
+var fsStaticDir embed.FS
+
+// RunsExtension shows runs of cmd/save
+type RunsExtension struct {
+	extensions.BaseExtension
+}
+
+func NewRunsExtension(repo repositories.Repository) *RunsExtension {
+	return &RunsExtension{extensions.BaseExtension{
+		Name:         "runs_ext",
+		FsContentTpl: fsContentTpl,
+		FsStaticDir:  fsStaticDir,
+		Controllers: []controllers.Controller{
+			NewRunsController(repo),
+		},
+	}}
+}
@@ -0,0 +1,78 @@
+package runs_ext
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/meesooqa/tgtag/pkg/controllers"
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// TagChangesController lists retagging of messages, filtered by "?group=" and "?tag="
+type TagChangesController struct {
+	controllers.BaseController
+	repo repositories.Repository
+}
+
+func NewTagChangesController(repo repositories.Repository) *TagChangesController {
+	c := &TagChangesController{
+		BaseController: controllers.BaseController{
+			MethodApi:  http.MethodGet,
+			RouteApi:   "/api/tag-changes",
+			Method:     http.MethodGet,
+			Route:      "/runs/tags",
+			Title:      "Tag Changes",
+			ContentTpl: "template/content/tag_changes.html",
+		},
+		repo: repo,
+	}
+	c.Self = c
+	return c
+}
+
+func (c *TagChangesController) GetApiData(r *http.Request) map[string]any {
+	apiData, err := c.getData(r)
+	if err != nil {
+		c.Log.Error("getting api data", slog.Any("err", err))
+		return nil
+	}
+	return map[string]any{"data": apiData}
+}
+
+func (c *TagChangesController) GetTplData(r *http.Request) map[string]any {
+	query := r.URL.Query()
+	contentData := map[string]any{
+		"Title": c.GetTitle(),
+		"Group": query.Get("group"),
+		"Tag":   query.Get("tag"),
+	}
+	changes, err := c.getData(r)
+	if err != nil {
+		c.Log.Error("getting tag changes", slog.Any("err", err))
+	}
+	contentData["Changes"] = changes
+	groups, err := c.repo.GetGroups(context.TODO())
+	if err != nil {
+		c.Log.Error("getting groups", slog.Any("err", err))
+	}
+	contentData["Groups"] = groups
+	tplData, err := c.Tpl.GetData(r, contentData)
+	if err != nil {
+		c.Log.Error("getting template data", slog.Any("err", err))
+		return nil
+	}
+	return tplData
+}
+
+// getData returns the last "?limit=" changes of "?group=" adding or removing "?tag="
+func (c *TagChangesController) getData(r *http.Request) ([]*models.TagChange, error) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	return c.repo.GetTagChanges(context.TODO(), query.Get("group"), query.Get("tag"), limit)
+}
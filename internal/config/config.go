@@ -1,16 +1,34 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Conf from config yml
 type Conf struct {
-	Mongo  *MongoConfig  `yaml:"mongo"`
-	System *SystemConfig `yaml:"system"`
-	Server *ServerConfig `yaml:"server"`
+	Storage *StorageConfig `yaml:"storage"`
+	Mongo   *MongoConfig   `yaml:"mongo"`
+	System  *SystemConfig  `yaml:"system"`
+	Server  *ServerConfig  `yaml:"server"`
+}
+
+// Storage drivers
+const (
+	StorageMongo  = "mongo"
+	StorageSQLite = "sqlite"
+)
+
+// StorageConfig selects where messages are saved
+type StorageConfig struct {
+	// Driver is StorageMongo or StorageSQLite, StorageMongo if not set
+	Driver string `yaml:"driver"`
+	// SQLitePath is the database file of StorageSQLite, "var/tgtag.db" if not set
+	SQLitePath string `yaml:"sqlite_path"`
 }
 
 // MongoConfig is a set of parameters for MongoDB
@@ -18,11 +36,76 @@ type MongoConfig struct {
 	URI                string `yaml:"uri"`
 	Database           string `yaml:"database"`
 	CollectionMessages string `yaml:"collection_messages"`
+	// CollectionServiceEvents is "service_events" if not set
+	CollectionServiceEvents string `yaml:"collection_service_events"`
+	// CollectionManifest is "manifest" if not set
+	CollectionManifest string `yaml:"collection_manifest"`
+	// CollectionIngestRuns is "ingest_runs" if not set
+	CollectionIngestRuns string `yaml:"collection_ingest_runs"`
+	// CollectionTagHistory is "tag_history" if not set
+	CollectionTagHistory string `yaml:"collection_tag_history"`
+	// BatchSize is the number of messages of one bulk write, 10 if not set
+	BatchSize int `yaml:"batch_size"`
+	// BufferSize is the number of messages queued for writing, 50 if not set
+	BufferSize int `yaml:"buffer_size"`
+	// FlushPeriod is how often an incomplete batch is written, "2s" if not set
+	FlushPeriod time.Duration `yaml:"flush_period"`
+	// MaxRetries is the number of retries of transient write errors, 3 if not set, 0 disables retries
+	MaxRetries *int `yaml:"max_retries"`
+	// RetryBackoff is the delay before the first retry, it doubles every retry, "500ms" if not set
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// DeadLetterPath is the JSONL file of messages that could not be saved, they are only logged if not set
+	DeadLetterPath string `yaml:"dead_letter_path"`
 }
 
 // SystemConfig is the configuration for App
 type SystemConfig struct {
-	DataPath string `yaml:"data_path"`
+	DataPath string        `yaml:"data_path"`
+	Groups   []GroupConfig `yaml:"groups"`
+	// MaxSkipRatio is the share of skipped messages of a file that fails the run, 0 disables the check
+	MaxSkipRatio float64 `yaml:"max_skip_ratio"`
+	// Workers is the number of files parsed at the same time, 0 means one
+	Workers int         `yaml:"workers"`
+	Dates   DatesConfig `yaml:"dates"`
+	Tags    TagsConfig  `yaml:"tags"`
+}
+
+// Hash returns SHA-256 of the settings in hex, runs with different settings have different hashes
+func (c *SystemConfig) Hash() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// TagsConfig is the set of tag normalization steps, the steps that are not enabled are skipped
+type TagsConfig struct {
+	NFC             bool `yaml:"nfc"`
+	CaseFold        bool `yaml:"case_fold"`
+	TrimPunctuation bool `yaml:"trim_punctuation"`
+	// StopList are tags that are not saved
+	StopList []string `yaml:"stop_list"`
+	// Aliases map spellings of a tag to its canonical form
+	Aliases map[string]string `yaml:"aliases"`
+}
+
+// DatesConfig overrides how dates of exports are parsed
+type DatesConfig struct {
+	// Layouts are Go time layouts of HTML date titles without the time zone, they replace the built-in ones
+	Layouts []string `yaml:"layouts"`
+	// Timezone is the IANA time zone of dates without an offset, UTC if not set
+	Timezone string `yaml:"timezone"`
+}
+
+// GroupConfig maps export folders to a canonical group
+type GroupConfig struct {
+	ID      string   `yaml:"id"`
+	Title   string   `yaml:"title"`
+	Folders []string `yaml:"folders"`
+	// ChannelID maps JSON exports of the channel to the group from any folder, HTML exports have no channel ID and are mapped by Folders
+	ChannelID int64 `yaml:"channel_id"`
 }
 
 // ServerConfig is a configuration for the server
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,13 +13,37 @@ func TestLoad(t *testing.T) {
 
 	require.NoError(t, err)
 
+	assert.Equal(t, &StorageConfig{Driver: StorageSQLite, SQLitePath: "var/test.db"}, c.Storage)
+
 	assert.IsType(t, &MongoConfig{}, c.Mongo)
 	assert.Equal(t, "mongodb://localhost:27017", c.Mongo.URI)
 	assert.Equal(t, "database_name", c.Mongo.Database)
 	assert.Equal(t, "messages_collection_name", c.Mongo.CollectionMessages)
+	assert.Equal(t, "service_events_collection_name", c.Mongo.CollectionServiceEvents)
+	assert.Equal(t, "manifest_collection_name", c.Mongo.CollectionManifest)
+	assert.Equal(t, "ingest_runs_collection_name", c.Mongo.CollectionIngestRuns)
+	assert.Equal(t, "tag_history_collection_name", c.Mongo.CollectionTagHistory)
+	assert.Equal(t, 100, c.Mongo.BatchSize)
+	assert.Equal(t, 500, c.Mongo.BufferSize)
+	assert.Equal(t, 5*time.Second, c.Mongo.FlushPeriod)
+	require.NotNil(t, c.Mongo.MaxRetries)
+	assert.Equal(t, 5, *c.Mongo.MaxRetries)
+	assert.Equal(t, time.Second, c.Mongo.RetryBackoff)
+	assert.Equal(t, "var/dead_letter_test.jsonl", c.Mongo.DeadLetterPath)
 
 	assert.IsType(t, &SystemConfig{}, c.System)
 	assert.Equal(t, "test/data", c.System.DataPath)
+	assert.Equal(t, 0.25, c.System.MaxSkipRatio)
+	assert.Equal(t, 4, c.System.Workers)
+	assert.Equal(t, DatesConfig{Layouts: []string{"02/01/2006 15:04:05"}, Timezone: "Europe/Moscow"}, c.System.Dates)
+	assert.Equal(t, TagsConfig{
+		NFC: true, CaseFold: true, TrimPunctuation: true,
+		StopList: []string{"todo"},
+		Aliases:  map[string]string{"бууба": "booba"},
+	}, c.System.Tags)
+	assert.Equal(t, []GroupConfig{
+		{ID: "booba", Title: "Booba", Folders: []string{"booba_2024", "booba_2025"}, ChannelID: 1234567890},
+	}, c.System.Groups)
 
 	assert.IsType(t, &ServerConfig{}, c.Server)
 	assert.Equal(t, 12345, c.Server.Port)
@@ -36,3 +61,13 @@ func TestLoadConfigInvalidYaml(t *testing.T) {
 	assert.Nil(t, r)
 	assert.EqualError(t, err, "yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str `Not Yaml` into config.Conf")
 }
+
+func TestSystemConfig_Hash(t *testing.T) {
+	conf := &SystemConfig{DataPath: "var/data", MaxSkipRatio: 0.1}
+	hash := conf.Hash()
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, (&SystemConfig{DataPath: "var/data", MaxSkipRatio: 0.1}).Hash(), "Одинаковые настройки дают одинаковый хеш")
+
+	conf.Tags.CaseFold = true
+	assert.NotEqual(t, hash, conf.Hash())
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
@@ -44,6 +45,18 @@ func (db *MongoDB) Init() error {
 	if err := db.createUniqueUuidIndex(context.TODO()); err != nil {
 		db.log.Error("creating index", "err", err)
 	}
+	if err := db.createUniquePathIndex(context.TODO()); err != nil {
+		db.log.Error("creating index", "err", err)
+	}
+	if err := db.createUniqueRunIDIndex(context.TODO()); err != nil {
+		db.log.Error("creating index", "err", err)
+	}
+	if err := db.createTagHistoryIndexes(context.TODO()); err != nil {
+		db.log.Error("creating index", "err", err)
+	}
+	if err := db.createMessageQueryIndexes(context.TODO()); err != nil {
+		db.log.Error("creating index", "err", err)
+	}
 
 	return nil
 }
@@ -71,12 +84,76 @@ func (db *MongoDB) GetCollectionMessages() *mongo.Collection {
 	return db.GetDatabase().Collection(db.Conf.CollectionMessages)
 }
 
+// GetCollectionServiceEvents returns the collection of service messages: date separators, pins, title changes
+func (db *MongoDB) GetCollectionServiceEvents() *mongo.Collection {
+	return db.GetDatabase().Collection(cmp.Or(db.Conf.CollectionServiceEvents, "service_events"))
+}
+
+// GetCollectionManifest returns the collection of export files saved by runs
+func (db *MongoDB) GetCollectionManifest() *mongo.Collection {
+	return db.GetDatabase().Collection(cmp.Or(db.Conf.CollectionManifest, "manifest"))
+}
+
+// GetCollectionIngestRuns returns the collection of cmd/save runs
+func (db *MongoDB) GetCollectionIngestRuns() *mongo.Collection {
+	return db.GetDatabase().Collection(cmp.Or(db.Conf.CollectionIngestRuns, "ingest_runs"))
+}
+
+// GetCollectionTagHistory returns the collection of message tag changes
+func (db *MongoDB) GetCollectionTagHistory() *mongo.Collection {
+	return db.GetDatabase().Collection(cmp.Or(db.Conf.CollectionTagHistory, "tag_history"))
+}
+
 func (db *MongoDB) createUniqueUuidIndex(ctx context.Context) error {
-	collection := db.GetCollectionMessages()
 	indexModel := mongo.IndexModel{
 		Keys:    bson.D{{Key: "uuid", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	}
-	_, err := collection.Indexes().CreateOne(ctx, indexModel)
+	for _, collection := range []*mongo.Collection{db.GetCollectionMessages(), db.GetCollectionServiceEvents()} {
+		if _, err := collection.Indexes().CreateOne(ctx, indexModel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *MongoDB) createUniquePathIndex(ctx context.Context) error {
+	indexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "path", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := db.GetCollectionManifest().Indexes().CreateOne(ctx, indexModel)
+	return err
+}
+
+func (db *MongoDB) createUniqueRunIDIndex(ctx context.Context) error {
+	indexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "run_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := db.GetCollectionIngestRuns().Indexes().CreateOne(ctx, indexModel)
+	return err
+}
+
+// createTagHistoryIndexes indexes changes by group and by added and removed tags, the last seen first
+func (db *MongoDB) createTagHistoryIndexes(ctx context.Context) error {
+	indexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "group", Value: 1}, {Key: "seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "added", Value: 1}, {Key: "seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "removed", Value: 1}, {Key: "seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "uuid", Value: 1}, {Key: "seen_at", Value: -1}}},
+	}
+	_, err := db.GetCollectionTagHistory().Indexes().CreateMany(ctx, indexModels)
+	return err
+}
+
+// createMessageQueryIndexes indexes the order of repositories.MessageQuery, by all groups and by group, and tags
+func (db *MongoDB) createMessageQueryIndexes(ctx context.Context) error {
+	indexModels := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "datetime", Value: 1}, {Key: "uuid", Value: 1}}},
+		{Keys: bson.D{{Key: "group", Value: 1}, {Key: "datetime", Value: 1}, {Key: "uuid", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+	}
+	_, err := db.GetCollectionMessages().Indexes().CreateMany(ctx, indexModels)
 	return err
 }
@@ -0,0 +1,236 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tarGzFS is the read-only file system of the tar.gz archive. The archive is indexed in one pass without keeping the content,
+// tar has no random access, so an entry is opened by reading the archive up to it: only the entry being read is in memory.
+// Entries have no mtime, as files of memFS.
+type tarGzFS struct {
+	name string
+	// index has the paths of regular files without their content, it gives directories
+	index memFS
+	sizes map[string]int64
+}
+
+func newTarGzFS(name string) (*tarGzFS, error) {
+	r, err := openTarGz(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	fsys := &tarGzFS{name: name, index: make(memFS), sizes: make(map[string]int64)}
+	for {
+		hdr, entry, err := r.next()
+		if errors.Is(err, io.EOF) {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fsys.index[entry] = nil
+		fsys.sizes[entry] = hdr.Size
+	}
+}
+
+func (t *tarGzFS) Open(name string) (iofs.File, error) {
+	size, ok := t.sizes[name]
+	if !ok {
+		f, err := t.index.Open(name)
+		if d, ok := f.(*memDir); ok {
+			d.entries, err = t.ReadDir(name)
+		}
+		return f, err
+	}
+	r, err := openTarGz(t.name)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: err}
+	}
+	for {
+		_, entry, err := r.next()
+		if err != nil {
+			_ = r.Close()
+			if errors.Is(err, io.EOF) {
+				err = iofs.ErrNotExist
+			}
+			return nil, &iofs.PathError{Op: "open", Path: name, Err: err}
+		}
+		if entry == name {
+			return &tarGzFile{tarGzReader: r, info: memFileInfo{name: path.Base(name), size: size}}, nil
+		}
+	}
+}
+
+// Stat does not read the archive
+func (t *tarGzFS) Stat(name string) (iofs.FileInfo, error) {
+	if size, ok := t.sizes[name]; ok {
+		return memFileInfo{name: path.Base(name), size: size}, nil
+	}
+	return iofs.Stat(t.index, name)
+}
+
+// ReadDir returns entries of the index with the sizes of the archive
+func (t *tarGzFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	entries, err := t.index.ReadDir(name)
+	for i, entry := range entries {
+		if !entry.IsDir() {
+			entries[i] = iofs.FileInfoToDirEntry(memFileInfo{name: entry.Name(), size: t.sizes[path.Join(name, entry.Name())]})
+		}
+	}
+	return entries, err
+}
+
+// tarGzReader reads regular files of the tar.gz archive from the start
+type tarGzReader struct {
+	*tar.Reader
+	file *os.File
+	gz   *gzip.Reader
+}
+
+func openTarGz(name string) (*tarGzReader, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &tarGzReader{Reader: tar.NewReader(gz), file: file, gz: gz}, nil
+}
+
+// next moves to the next regular file and returns its header and slash-separated path, io.EOF at the end of the archive
+func (r *tarGzReader) next() (*tar.Header, string, error) {
+	for {
+		hdr, err := r.Reader.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		entry := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if hdr.Typeflag == tar.TypeReg && iofs.ValidPath(entry) {
+			return hdr, entry, nil
+		}
+	}
+}
+
+func (r *tarGzReader) Close() error {
+	return errors.Join(r.gz.Close(), r.file.Close())
+}
+
+type tarGzFile struct {
+	*tarGzReader
+	info memFileInfo
+}
+
+func (f *tarGzFile) Stat() (iofs.FileInfo, error) { return f.info, nil }
+
+// memFS is a read-only file system in memory: slash-separated path -> content, directories are implied by paths
+type memFS map[string][]byte
+
+func (m memFS) Open(name string) (iofs.File, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+	}
+	if data, ok := m[name]; ok {
+		return &memFile{Reader: bytes.NewReader(data), info: memFileInfo{name: path.Base(name), size: int64(len(data))}}, nil
+	}
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrNotExist}
+	}
+	return &memDir{info: memFileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+func (m memFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	children := make(map[string]memFileInfo)
+	for p, data := range m {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+		child, _, isDir := strings.Cut(rest, "/")
+		children[child] = memFileInfo{name: child, size: int64(len(data)), dir: isDir}
+	}
+	if len(children) == 0 && name != "." {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrNotExist}
+	}
+
+	entries := make([]iofs.DirEntry, 0, len(children))
+	for _, info := range children {
+		if info.dir {
+			info.size = 0
+		}
+		entries = append(entries, iofs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+type memFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (f *memFile) Stat() (iofs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error                 { return nil }
+
+type memDir struct {
+	info    memFileInfo
+	entries []iofs.DirEntry
+}
+
+func (d *memDir) Stat() (iofs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error                 { return nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() any           { return nil }
+func (i memFileInfo) Mode() iofs.FileMode {
+	if i.dir {
+		return iofs.ModeDir | 0555
+	}
+	return 0444
+}
@@ -0,0 +1,226 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	iofs "io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportEntries — содержимое архива экспорта: две связанные страницы, стили и фото
+var exportEntries = map[string]string{
+	"ChatExport/messages.html":        `<div class="message default" id="message1"></div><a class="pagination" href="messages2.html">Next messages</a>`,
+	"ChatExport/messages2.html":       `<a class="pagination" href="messages.html">Previous messages</a><div class="message default" id="message2"></div>`,
+	"ChatExport/css/style.css":        `body {}`,
+	"ChatExport/photos/photo_1.jpg":   `jpg`,
+	"ChatExport/result.json":          `{}`,
+	"ChatExport/photos/photo_2.thumb": `jpg`,
+}
+
+func writeZip(t *testing.T, name string) {
+	t.Helper()
+	file, err := os.Create(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	for entry, content := range exportEntries {
+		w, err := zw.Create(entry)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
+
+func writeTarGz(t *testing.T, name string) {
+	t.Helper()
+	file, err := os.Create(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./ChatExport/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for entry, content := range exportEntries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./" + entry, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+// findExportFiles запускает Finder и возвращает найденные файлы с их содержимым
+func findExportFiles(t *testing.T, path string) ([]string, map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	finder := NewFinder(logger)
+
+	t.Cleanup(func() {
+		assert.NoError(t, finder.Close())
+	})
+
+	filesChan := make(chan ExportFile)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go finder.FindFiles(path, filesChan, &wg)
+
+	var names []string
+	contents := make(map[string]string)
+	for file := range filesChan {
+		names = append(names, file.Name)
+		f, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		contents[file.Name] = string(data)
+	}
+	wg.Wait()
+	assert.NotContains(t, buf.String(), "level=WARN")
+	assert.NotContains(t, buf.String(), "level=ERROR")
+	return names, contents
+}
+
+func TestFindFiles_Archives(t *testing.T) {
+	for _, archive := range []string{"booba.zip", "booba.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			dataDir := t.TempDir()
+			name := filepath.Join(dataDir, archive)
+			if archive == "booba.zip" {
+				writeZip(t, name)
+			} else {
+				writeTarGz(t, name)
+			}
+
+			names, contents := findExportFiles(t, dataDir)
+			want := []string{
+				filepath.Join(name, "ChatExport", "result.json"),
+				filepath.Join(name, "ChatExport", "messages.html"),
+				filepath.Join(name, "ChatExport", "messages2.html"),
+			}
+			assert.Equal(t, want, names, "Из архива берутся только файлы экспорта, страницы — в порядке пагинации")
+			assert.Equal(t, exportEntries["ChatExport/messages2.html"], contents[filepath.Join(name, "ChatExport", "messages2.html")])
+
+			// архив можно передать и напрямую
+			names, _ = findExportFiles(t, name)
+			assert.Len(t, names, 3)
+		})
+	}
+}
+
+// writeTarGzEntries пишет архив с записями в заданном порядке
+func writeTarGzEntries(t *testing.T, name string, entries ...string) {
+	t.Helper()
+	file, err := os.Create(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		content := exportEntries[entry]
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: entry, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func TestFindFiles_TarGzOutOfOrder(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "booba.tar.gz")
+	writeTarGzEntries(t, name, "ChatExport/messages2.html", "ChatExport/photos/photo_1.jpg", "ChatExport/messages.html")
+
+	names, contents := findExportFiles(t, name)
+	assert.Equal(t, []string{
+		filepath.Join(name, "ChatExport", "messages.html"),
+		filepath.Join(name, "ChatExport", "messages2.html"),
+	}, names, "Страницы tar.gz идут в порядке пагинации, а не архива")
+	assert.Equal(t, exportEntries["ChatExport/messages.html"], contents[filepath.Join(name, "ChatExport", "messages.html")])
+}
+
+func TestFindFiles_TarGzMissingPage(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "booba.tar.gz")
+	writeTarGzEntries(t, name, "ChatExport/messages.html")
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	filesChan := make(chan ExportFile)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go NewFinder(logger).FindFiles(name, filesChan, &wg)
+	var names []string
+	for file := range filesChan {
+		names = append(names, file.Name)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []string{filepath.Join(name, "ChatExport", "messages.html")}, names)
+	assert.Contains(t, buf.String(), "page is missing", "Пагинация tar.gz проверяется, как пагинация папки")
+}
+
+func TestFindFiles_BrokenArchive(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	finder := NewFinder(logger)
+
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "broken.zip"), []byte("not a zip"), 0644))
+
+	filesChan := make(chan ExportFile)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go finder.FindFiles(dataDir, filesChan, &wg)
+	for range filesChan {
+	}
+	wg.Wait()
+
+	assert.Contains(t, buf.String(), "can't open archive")
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := memFS{
+		"ChatExport/messages.html": []byte("page"),
+		"ChatExport/result.json":   []byte("{}"),
+		"other.html":               []byte("other"),
+	}
+	require.NoError(t, fstest.TestFS(fsys, "ChatExport/messages.html", "ChatExport/result.json", "other.html"))
+}
+
+func TestTarGzFS(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "booba.tar.gz")
+	writeTarGz(t, name)
+	fsys, err := newTarGzFS(name)
+	require.NoError(t, err)
+	require.NoError(t, fstest.TestFS(fsys, "ChatExport/messages.html", "ChatExport/css/style.css", "ChatExport/photos/photo_2.thumb"))
+
+	info, err := iofs.Stat(fsys, "ChatExport/messages2.html")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(exportEntries["ChatExport/messages2.html"])), info.Size())
+
+	_, err = fsys.Open("ChatExport/missing.html")
+	assert.ErrorIs(t, err, iofs.ErrNotExist)
+}
+
+func TestTrimArchiveExt(t *testing.T) {
+	assert.Equal(t, "booba", TrimArchiveExt("booba.zip"))
+	assert.Equal(t, "booba", TrimArchiveExt("booba.tar.gz"))
+	assert.Equal(t, "booba", TrimArchiveExt("booba.TGZ"))
+	assert.Equal(t, "booba", TrimArchiveExt("booba"))
+	assert.True(t, IsArchive("var/data/booba.zip"))
+	assert.False(t, IsArchive("var/data/booba/messages.html"))
+}
@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveExts are the archives exports are read from without extracting them to disk
+var archiveExts = []string{".zip", ".tar.gz", ".tgz"}
+
+// ExportFile is an export file found by Finder. It may be a file on disk or an entry of an archive.
+type ExportFile struct {
+	// FS is the file system the file is opened from
+	FS iofs.FS
+	// Path is the slash-separated path of the file inside FS
+	Path string
+	// Name is the path on disk, entries of archives are named "var/data/group.zip/path/in/archive.html"
+	Name string
+}
+
+// NewExportFile returns the export file on disk
+func NewExportFile(path string) ExportFile {
+	return ExportFile{
+		FS:   os.DirFS(filepath.Dir(path)),
+		Path: filepath.Base(path),
+		Name: path,
+	}
+}
+
+func (f ExportFile) Open() (iofs.File, error) {
+	return f.FS.Open(f.Path)
+}
+
+// Stat returns the size and mtime of the file, entries of tar.gz archives have no mtime
+func (f ExportFile) Stat() (iofs.FileInfo, error) {
+	return iofs.Stat(f.FS, f.Path)
+}
+
+// Hash returns SHA-256 of the content in hex
+func (f ExportFile) Hash() (string, error) {
+	file, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentHash is SHA-256 of the content of the file computed while the file is parsed, see ExportFile.WithHash
+type ContentHash struct {
+	h    hash.Hash
+	done bool
+}
+
+// Sum returns SHA-256 of the content in hex, false if the file has not been read and closed
+func (c *ContentHash) Sum() (string, bool) {
+	if !c.done {
+		return "", false
+	}
+	return hex.EncodeToString(c.h.Sum(nil)), true
+}
+
+// WithHash returns the file that hashes its content while it is read, so that a changed file is not read twice.
+// The rest of the content the reader has not read is hashed on Close.
+func (f ExportFile) WithHash() (ExportFile, *ContentHash) {
+	c := &ContentHash{h: sha256.New()}
+	return ExportFile{FS: hashFS{FS: f.FS, hash: c}, Path: f.Path, Name: f.Name}, c
+}
+
+type hashFS struct {
+	iofs.FS
+	hash *ContentHash
+}
+
+// Open starts the hash again, the file opened last is hashed
+func (fsys hashFS) Open(name string) (iofs.File, error) {
+	file, err := fsys.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fsys.hash.h.Reset()
+	fsys.hash.done = false
+	return &hashFile{File: file, hash: fsys.hash}, nil
+}
+
+// Stat does not open the file, so that the hash is not started again
+func (fsys hashFS) Stat(name string) (iofs.FileInfo, error) {
+	return iofs.Stat(fsys.FS, name)
+}
+
+type hashFile struct {
+	iofs.File
+	hash *ContentHash
+}
+
+func (f *hashFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.hash.h.Write(p[:n])
+	return n, err
+}
+
+func (f *hashFile) Close() error {
+	// the parser may stop before the end, e.g. the JSON decoder after the last token
+	_, err := io.Copy(f.hash.h, f.File)
+	f.hash.done = err == nil
+	return errors.Join(err, f.File.Close())
+}
+
+// IsArchive checks that the file is a supported archive
+func IsArchive(name string) bool {
+	return TrimArchiveExt(name) != name
+}
+
+// TrimArchiveExt returns the archive name without its extension: "booba.tar.gz" -> "booba"
+func TrimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// isExportFile checks that the file is an HTML page or a JSON export, not CSS, JS or media
+func isExportFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm", ".json":
+		return true
+	}
+	return false
+}
+
+func isHTMLPage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportFile_StatHash(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "messages.html")
+	require.NoError(t, os.WriteFile(name, []byte("booba"), 0644))
+	file := NewExportFile(name)
+
+	info, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size())
+	assert.False(t, info.ModTime().IsZero())
+
+	hash, err := file.Hash()
+	require.NoError(t, err)
+	// sha256("booba")
+	assert.Equal(t, "778a9cb343ec8f2f1b7a12d467bf706b352fc28aa0d8095c0ae76a4674961504", hash)
+}
+
+func TestExportFile_WithHash(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "messages.html")
+	require.NoError(t, os.WriteFile(name, []byte("booba"), 0644))
+	file, hash := NewExportFile(name).WithHash()
+
+	_, ok := hash.Sum()
+	assert.False(t, ok, "Файл ещё не прочитан")
+
+	f, err := file.Open()
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	sum, ok := hash.Sum()
+	require.True(t, ok)
+	assert.Equal(t, "778a9cb343ec8f2f1b7a12d467bf706b352fc28aa0d8095c0ae76a4674961504", sum, "Непрочитанный остаток хешируется при закрытии")
+
+	info, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size())
+	_, ok = hash.Sum()
+	assert.True(t, ok, "Stat не начинает хеш заново")
+}
+
+func TestExportFile_StatHash_Archive(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "booba.tar.gz")
+	writeTarGz(t, name)
+	fsys, err := newTarGzFS(name)
+	require.NoError(t, err)
+	file := ExportFile{FS: fsys, Path: "ChatExport/result.json", Name: name + "/ChatExport/result.json"}
+
+	info, err := file.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.Size())
+	assert.True(t, info.ModTime().IsZero(), "У файлов tar.gz нет mtime")
+
+	hash, err := file.Hash()
+	require.NoError(t, err)
+	assert.Len(t, hash, 64)
+}
@@ -1,21 +1,41 @@
 package fs
 
 import (
+	"archive/zip"
+	"errors"
+	iofs "io/fs"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
 type Finder struct {
 	log *slog.Logger
+	// zips are open while their files are parsed, see Close
+	mu   sync.Mutex
+	zips []*zip.ReadCloser
 }
 
 func NewFinder(log *slog.Logger) *Finder {
 	return &Finder{log: log}
 }
 
-func (f *Finder) FindFiles(fileOrDirPath string, filesChan chan<- string, wg *sync.WaitGroup) {
+// Close closes zip archives of the found files, their files can't be opened after it
+func (f *Finder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for _, zr := range f.zips {
+		errs = append(errs, zr.Close())
+	}
+	f.zips = nil
+	return errors.Join(errs...)
+}
+
+func (f *Finder) FindFiles(fileOrDirPath string, filesChan chan<- ExportFile, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer close(filesChan)
 
@@ -25,26 +45,117 @@ func (f *Finder) FindFiles(fileOrDirPath string, filesChan chan<- string, wg *sy
 		return
 	}
 
-	if i.IsDir() {
+	switch {
+	case i.IsDir():
 		f.findFilesInDir(fileOrDirPath, filesChan)
-	} else {
+	case IsArchive(fileOrDirPath):
+		f.findFilesInArchive(fileOrDirPath, filesChan)
+	default:
 		// is file
-		filesChan <- fileOrDirPath
+		filesChan <- NewExportFile(fileOrDirPath)
+	}
+}
+
+func (f *Finder) findFilesInDir(root string, filesChan chan<- ExportFile) {
+	dirFS := os.DirFS(root)
+	for _, archive := range f.findFilesInFS(dirFS, root, filesChan) {
+		f.findFilesInArchive(filepath.Join(root, filepath.FromSlash(archive)), filesChan)
+	}
+}
+
+// findFilesInArchive sends export files of the zip or tar.gz archive without extracting it, in the same order as files of a folder.
+// The zip archive is read by one reader until Close, entries of the tar.gz archive are read by streaming it, see tarGzFS.
+func (f *Finder) findFilesInArchive(name string, filesChan chan<- ExportFile) {
+	var fsys iofs.FS
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			f.log.Error("can't open archive", "archive", name, "err", err)
+			return
+		}
+		f.mu.Lock()
+		f.zips = append(f.zips, zr)
+		f.mu.Unlock()
+		fsys = zr
+	} else {
+		tr, err := newTarGzFS(name)
+		if err != nil {
+			f.log.Error("can't open archive", "archive", name, "err", err)
+			return
+		}
+		fsys = tr
+	}
+	for _, archive := range f.findFilesInFS(fsys, name, filesChan) {
+		f.log.Warn("nested archives are not supported", "archive", name, "path", archive)
 	}
 }
 
-func (f *Finder) findFilesInDir(root string, filesChan chan<- string) {
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// findFilesInFS sends export files only: JSON as is and HTML pages of every folder in the order of their pagination.
+// Files are opened from fsys and named under root. Archives found are returned.
+func (f *Finder) findFilesInFS(fsys iofs.FS, root string, filesChan chan<- ExportFile) []string {
+	send := func(name string) {
+		filesChan <- ExportFile{FS: fsys, Path: name, Name: filepath.Join(root, filepath.FromSlash(name))}
+	}
+
+	var dirs, archives []string
+	pages := make(map[string][]string)
+	err := iofs.WalkDir(fsys, ".", func(name string, d iofs.DirEntry, err error) error {
 		if err != nil {
-			f.log.Error("error while walking", "path", path, "err", err)
+			f.log.Error("error while walking", "path", filepath.Join(root, filepath.FromSlash(name)), "err", err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
 		}
 
-		if !info.IsDir() {
-			filesChan <- path
+		switch {
+		case isHTMLPage(name):
+			dir := path.Dir(name)
+			if _, ok := pages[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			pages[dir] = append(pages[dir], name)
+		case isExportFile(name):
+			send(name)
+		case IsArchive(name):
+			archives = append(archives, name)
+		default:
+			// CSS, JS, images and other media of the export
+			f.log.Debug("not an export file", "path", name)
 		}
 		return nil
 	})
 	if err != nil {
 		f.log.Error("directory walk error", "err", err)
 	}
+
+	for _, dir := range dirs {
+		for _, name := range f.orderPages(fsys, filepath.Join(root, filepath.FromSlash(dir)), pages[dir]) {
+			send(name)
+		}
+	}
+	return archives
+}
+
+// orderPages returns pages of the folder in the order of their pagination and reports gaps, orphans and overlapping messages
+func (f *Finder) orderPages(fsys iofs.FS, dir string, names []string) []string {
+	scanned := make([]exportPage, 0, len(names))
+	for _, name := range names {
+		page, err := scanPage(fsys, name)
+		if err != nil {
+			f.log.Error("can't read pagination", "dir", dir, "page", name, "err", err)
+		}
+		scanned = append(scanned, page)
+	}
+	chain := buildPageChain(scanned)
+	for _, g := range chain.gaps {
+		f.log.Error("page is missing", "dir", dir, "page", g.page, "linkedFrom", g.linkedFrom)
+	}
+	for _, orphan := range chain.orphans {
+		f.log.Warn("page is not linked from any page", "dir", dir, "page", orphan)
+	}
+	for _, o := range chain.overlaps {
+		f.log.Warn("messages overlap between pages", "page", o.page, "prevPage", o.prevPage, "count", len(o.messageIDs), "messageIDs", o.messageIDs)
+	}
+	return chain.pages
 }
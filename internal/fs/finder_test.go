@@ -23,14 +23,14 @@ func TestFindFiles_File(t *testing.T) {
 	defer os.Remove(tmpFile.Name())
 	tmpFile.Close()
 
-	filesChan := make(chan string)
+	filesChan := make(chan ExportFile)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go finder.FindFiles(tmpFile.Name(), filesChan, &wg)
 
 	var files []string
 	for file := range filesChan {
-		files = append(files, file)
+		files = append(files, file.Name)
 	}
 	wg.Wait()
 
@@ -46,28 +46,35 @@ func TestFindFiles_Directory(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(tempDir)
 
-	file1Path := filepath.Join(tempDir, "file1.txt")
-	file2Path := filepath.Join(tempDir, "file2.txt")
+	file1Path := filepath.Join(tempDir, "messages.html")
+	file2Path := filepath.Join(tempDir, "result.json")
 	err = os.WriteFile(file1Path, []byte("content1"), 0644)
 	require.NoError(t, err)
 	err = os.WriteFile(file2Path, []byte("content2"), 0644)
 	require.NoError(t, err)
+	// файлы оформления и медиа экспорта не отдаются парсеру
+	err = os.MkdirAll(filepath.Join(tempDir, "css"), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "css", "style.css"), []byte("body {}"), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "photo_1.jpg"), []byte("jpg"), 0644)
+	require.NoError(t, err)
 
 	subDir := filepath.Join(tempDir, "subdir")
 	err = os.Mkdir(subDir, 0755)
 	require.NoError(t, err)
-	subFilePath := filepath.Join(subDir, "subfile.txt")
+	subFilePath := filepath.Join(subDir, "messages.html")
 	err = os.WriteFile(subFilePath, []byte("subcontent"), 0644)
 	require.NoError(t, err)
 
-	filesChan := make(chan string)
+	filesChan := make(chan ExportFile)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go finder.FindFiles(tempDir, filesChan, &wg)
 
 	var files []string
 	for file := range filesChan {
-		files = append(files, file)
+		files = append(files, file.Name)
 	}
 	wg.Wait()
 
@@ -81,14 +88,14 @@ func TestFindFiles_NonExistent(t *testing.T) {
 	finder := NewFinder(logger)
 
 	nonExistentPath := "/nonexistentpath_123456789"
-	filesChan := make(chan string)
+	filesChan := make(chan ExportFile)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go finder.FindFiles(nonExistentPath, filesChan, &wg)
 
 	var files []string
 	for file := range filesChan {
-		files = append(files, file)
+		files = append(files, file.Name)
 	}
 	wg.Wait()
 
@@ -101,7 +108,7 @@ func TestFindFiles_NonExistent_Logs(t *testing.T) {
 	finder := NewFinder(logger)
 
 	nonExistentPath := "/nonexistentpath_123456789"
-	filesChan := make(chan string)
+	filesChan := make(chan ExportFile)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go finder.FindFiles(nonExistentPath, filesChan, &wg)
@@ -127,7 +134,7 @@ func TestFindFiles_DirectoryWithInaccessibleSubDir(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(tempDir)
 
-	accessibleFile := filepath.Join(tempDir, "file1.txt")
+	accessibleFile := filepath.Join(tempDir, "messages.html")
 	err = os.WriteFile(accessibleFile, []byte("content"), 0644)
 	require.NoError(t, err)
 
@@ -138,14 +145,14 @@ func TestFindFiles_DirectoryWithInaccessibleSubDir(t *testing.T) {
 	err = os.Chmod(inaccessibleDir, 0000)
 	require.NoError(t, err)
 
-	filesChan := make(chan string)
+	filesChan := make(chan ExportFile)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go finder.FindFiles(tempDir, filesChan, &wg)
 
 	var files []string
 	for file := range filesChan {
-		files = append(files, file)
+		files = append(files, file.Name)
 	}
 	wg.Wait()
 
@@ -155,3 +162,33 @@ func TestFindFiles_DirectoryWithInaccessibleSubDir(t *testing.T) {
 	err = os.Chmod(inaccessibleDir, 0755)
 	require.NoError(t, err)
 }
+
+func TestFindFiles_PagesInPaginationOrder(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	finder := NewFinder(logger)
+
+	tempDir := t.TempDir()
+	writePage(t, tempDir, "messages.html", "", "messages2.html", "message1")
+	writePage(t, tempDir, "messages2.html", "messages.html", "messages10.html", "message2")
+	writePage(t, tempDir, "messages10.html", "messages2.html", "", "message3")
+
+	filesChan := make(chan ExportFile)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go finder.FindFiles(tempDir, filesChan, &wg)
+
+	var files []string
+	for file := range filesChan {
+		files = append(files, file.Name)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []string{
+		filepath.Join(tempDir, "messages.html"),
+		filepath.Join(tempDir, "messages2.html"),
+		filepath.Join(tempDir, "messages10.html"),
+	}, files, "Страницы должны идти в порядке пагинации, а не в лексикографическом")
+	assert.NotContains(t, buf.String(), "level=WARN")
+	assert.NotContains(t, buf.String(), "level=ERROR")
+}
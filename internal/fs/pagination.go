@@ -0,0 +1,205 @@
+package fs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var pageNumberRe = regexp.MustCompile(`(\d+)\.html?$`)
+
+// exportPage is a messagesN.html page with its "Previous messages" and "Next messages" links
+type exportPage struct {
+	path       string
+	prev       string
+	next       string
+	messageIDs []string
+}
+
+// pageChain is the order of pages of one export folder and the problems found while building it
+type pageChain struct {
+	pages []string
+	// gaps are linked pages missing on disk
+	gaps []pageGap
+	// orphans are pages nothing links to
+	orphans []string
+	// overlaps are message IDs met on several pages
+	overlaps []pageOverlap
+}
+
+// pageGap is a missing page and the page linking it
+type pageGap struct {
+	page       string
+	linkedFrom string
+}
+
+// pageOverlap is a set of message IDs of the page already met on a previous page
+type pageOverlap struct {
+	page       string
+	prevPage   string
+	messageIDs []string
+}
+
+// scanPage reads pagination links and message IDs of the page with the tokenizer.
+// A pagination link before the first message is "Previous messages", after it is "Next messages".
+func scanPage(fsys iofs.FS, name string) (exportPage, error) {
+	page := exportPage{path: name}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return page, err
+	}
+	defer file.Close()
+
+	dir := path.Dir(name)
+	z := html.NewTokenizer(bufio.NewReader(file))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if errors.Is(z.Err(), io.EOF) {
+				return page, nil
+			}
+			return page, z.Err()
+		}
+		if tt != html.StartTagToken {
+			continue
+		}
+		tok := z.Token()
+		classes := strings.Fields(attr(tok, "class"))
+		switch {
+		case tok.Data == "a" && slices.Contains(classes, "pagination"):
+			href, _, _ := strings.Cut(attr(tok, "href"), "#")
+			if href == "" {
+				continue
+			}
+			target := path.Join(dir, href)
+			if len(page.messageIDs) == 0 {
+				page.prev = target
+			} else {
+				page.next = target
+			}
+		case tok.Data == "div" && slices.Contains(classes, "message") && slices.Contains(classes, "default"):
+			if id := attr(tok, "id"); id != "" {
+				page.messageIDs = append(page.messageIDs, id)
+			}
+		}
+	}
+}
+
+// buildPageChain orders pages by their links starting from the first page.
+// Pages out of the chain (after a gap or orphans) follow it, so that nothing is lost.
+func buildPageChain(pages []exportPage) pageChain {
+	var chain pageChain
+	byPath := make(map[string]exportPage, len(pages))
+	for _, p := range pages {
+		byPath[p.path] = p
+	}
+
+	linked := make(map[string]bool)
+	for _, p := range sortPages(pages) {
+		for _, target := range []string{p.prev, p.next} {
+			if target == "" {
+				continue
+			}
+			linked[target] = true
+			if _, ok := byPath[target]; !ok {
+				chain.gaps = append(chain.gaps, pageGap{page: target, linkedFrom: p.path})
+			}
+		}
+	}
+
+	sorted := sortPages(pages)
+
+	// heads are pages without an existing previous page
+	visited := make(map[string]bool)
+	follow := func(p exportPage) {
+		for !visited[p.path] {
+			visited[p.path] = true
+			chain.pages = append(chain.pages, p.path)
+			next, ok := byPath[p.next]
+			if !ok {
+				return
+			}
+			p = next
+		}
+	}
+	for _, p := range sorted {
+		if _, ok := byPath[p.prev]; !ok {
+			follow(p)
+		}
+	}
+	// the rest are cycles
+	for _, p := range sorted {
+		follow(p)
+	}
+
+	if len(pages) > 1 {
+		for _, p := range sorted {
+			if !linked[p.path] {
+				chain.orphans = append(chain.orphans, p.path)
+			}
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, name := range chain.pages {
+		overlaps := make(map[string][]string)
+		for _, id := range byPath[name].messageIDs {
+			if prevPage, ok := seen[id]; ok && prevPage != name {
+				overlaps[prevPage] = append(overlaps[prevPage], id)
+				continue
+			}
+			seen[id] = name
+		}
+		prevPages := make([]string, 0, len(overlaps))
+		for prevPage := range overlaps {
+			prevPages = append(prevPages, prevPage)
+		}
+		sort.Strings(prevPages)
+		for _, prevPage := range prevPages {
+			chain.overlaps = append(chain.overlaps, pageOverlap{page: name, prevPage: prevPage, messageIDs: overlaps[prevPage]})
+		}
+	}
+
+	return chain
+}
+
+// sortPages sorts pages by their number: messages.html, messages2.html, ..., messages10.html
+func sortPages(pages []exportPage) []exportPage {
+	sorted := slices.Clone(pages)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ni, nj := pageNumber(sorted[i].path), pageNumber(sorted[j].path)
+		if ni != nj {
+			return ni < nj
+		}
+		return sorted[i].path < sorted[j].path
+	})
+	return sorted
+}
+
+// pageNumber returns N of messagesN.html, messages.html is the first page
+func pageNumber(name string) int {
+	matches := pageNumberRe.FindStringSubmatch(path.Base(name))
+	if matches == nil {
+		return 1
+	}
+	n, _ := strconv.Atoi(matches[1])
+	return n
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePage создаёт страницу экспорта со ссылками "Previous messages" / "Next messages" и сообщениями с указанными ID
+func writePage(t *testing.T, dir, name, prev, next string, messageIDs ...string) string {
+	t.Helper()
+	var sb strings.Builder
+	sb.WriteString(`<html><body><div class="page_wrap"><div class="history">`)
+	if prev != "" {
+		fmt.Fprintf(&sb, `<a class="pagination block_link" href="%s">Previous messages</a>`, prev)
+	}
+	for _, id := range messageIDs {
+		fmt.Fprintf(&sb, `<div class="message default clearfix" id="%s"><div class="body"><div class="text">#tag</div></div></div>`, id)
+	}
+	if next != "" {
+		fmt.Fprintf(&sb, `<a class="pagination block_link" href="%s">Next messages</a>`, next)
+	}
+	sb.WriteString(`</div></div></body></html>`)
+
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	require.NoError(t, os.WriteFile(path, []byte(sb.String()), 0644))
+	return path
+}
+
+func TestScanPage(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "booba"), 0755))
+	writePage(t, dir, "booba/messages2.html", "messages.html", "messages3.html#go_to_message5", "message3", "message4")
+
+	page, err := scanPage(os.DirFS(dir), "booba/messages2.html")
+	require.NoError(t, err)
+	assert.Equal(t, exportPage{
+		path:       "booba/messages2.html",
+		prev:       "booba/messages.html",
+		next:       "booba/messages3.html",
+		messageIDs: []string{"message3", "message4"},
+	}, page)
+}
+
+func TestScanPage_NotFound(t *testing.T) {
+	_, err := scanPage(os.DirFS(t.TempDir()), "messages.html")
+	assert.Error(t, err)
+}
+
+func TestBuildPageChain(t *testing.T) {
+	pages := []exportPage{
+		{path: "d/messages3.html", prev: "d/messages2.html", messageIDs: []string{"message5"}},
+		{path: "d/messages.html", next: "d/messages2.html", messageIDs: []string{"message1", "message2"}},
+		{path: "d/messages2.html", prev: "d/messages.html", next: "d/messages3.html", messageIDs: []string{"message3", "message4"}},
+	}
+
+	chain := buildPageChain(pages)
+	assert.Equal(t, []string{"d/messages.html", "d/messages2.html", "d/messages3.html"}, chain.pages)
+	assert.Empty(t, chain.gaps)
+	assert.Empty(t, chain.orphans)
+	assert.Empty(t, chain.overlaps)
+}
+
+func TestBuildPageChain_SinglePage(t *testing.T) {
+	chain := buildPageChain([]exportPage{{path: "d/messages.html", messageIDs: []string{"message1"}}})
+	assert.Equal(t, []string{"d/messages.html"}, chain.pages)
+	assert.Empty(t, chain.orphans, "Единственная страница не считается сиротой")
+}
+
+func TestBuildPageChain_Gap(t *testing.T) {
+	pages := []exportPage{
+		{path: "d/messages.html", next: "d/messages2.html"},
+		{path: "d/messages3.html", prev: "d/messages2.html"},
+	}
+
+	chain := buildPageChain(pages)
+	assert.Equal(t, []string{"d/messages.html", "d/messages3.html"}, chain.pages, "Страницы после разрыва всё равно обрабатываются")
+	assert.Equal(t, []pageGap{
+		{page: "d/messages2.html", linkedFrom: "d/messages.html"},
+		{page: "d/messages2.html", linkedFrom: "d/messages3.html"},
+	}, chain.gaps)
+	assert.Equal(t, []string{"d/messages.html", "d/messages3.html"}, chain.orphans)
+}
+
+func TestBuildPageChain_Orphan(t *testing.T) {
+	pages := []exportPage{
+		{path: "d/messages.html", next: "d/messages2.html"},
+		{path: "d/messages2.html", prev: "d/messages.html"},
+		{path: "d/copy.html"},
+	}
+
+	chain := buildPageChain(pages)
+	assert.Equal(t, []string{"d/copy.html", "d/messages.html", "d/messages2.html"}, chain.pages)
+	assert.Empty(t, chain.gaps)
+	assert.Equal(t, []string{"d/copy.html"}, chain.orphans)
+}
+
+func TestBuildPageChain_Overlap(t *testing.T) {
+	pages := []exportPage{
+		{path: "d/messages.html", next: "d/messages2.html", messageIDs: []string{"message1", "message2"}},
+		{path: "d/messages2.html", prev: "d/messages.html", messageIDs: []string{"message2", "message3"}},
+	}
+
+	chain := buildPageChain(pages)
+	assert.Equal(t, []pageOverlap{
+		{page: "d/messages2.html", prevPage: "d/messages.html", messageIDs: []string{"message2"}},
+	}, chain.overlaps)
+}
+
+func TestBuildPageChain_Cycle(t *testing.T) {
+	pages := []exportPage{
+		{path: "d/messages.html", prev: "d/messages2.html", next: "d/messages2.html"},
+		{path: "d/messages2.html", prev: "d/messages.html", next: "d/messages.html"},
+	}
+
+	chain := buildPageChain(pages)
+	assert.Equal(t, []string{"d/messages.html", "d/messages2.html"}, chain.pages, "Цикл не должен зацикливать обход")
+}
+
+func TestPageNumber(t *testing.T) {
+	assert.Equal(t, 1, pageNumber("d/messages.html"))
+	assert.Equal(t, 2, pageNumber("d/messages2.html"))
+	assert.Equal(t, 10, pageNumber("d/messages10.html"))
+}
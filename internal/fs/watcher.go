@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"context"
+	iofs "io/fs"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"time"
+)
+
+// Watcher polls the data path and signals when files have been added or changed.
+// A change is signalled after files stay the same for the debounce period, so an export being copied is not read half-done.
+type Watcher struct {
+	log      *slog.Logger
+	interval time.Duration
+	debounce time.Duration
+}
+
+// fileState is what a change of a file is detected by
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func NewWatcher(log *slog.Logger, interval, debounce time.Duration) *Watcher {
+	return &Watcher{log: log, interval: interval, debounce: debounce}
+}
+
+// Watch sends to changesChan until ctx is done, the files found at the start are a change too
+func (w *Watcher) Watch(ctx context.Context, root string, changesChan chan<- struct{}) {
+	defer close(changesChan)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var last map[string]fileState
+	var changedAt time.Time
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := w.snapshot(root)
+		if !maps.Equal(current, last) {
+			last, changedAt, pending = current, time.Now(), true
+			w.log.Debug("data path changed", "path", root, "files", len(current))
+			continue
+		}
+		if !pending || time.Since(changedAt) < w.debounce {
+			continue
+		}
+		pending = false
+		select {
+		case <-ctx.Done():
+			return
+		case changesChan <- struct{}{}:
+		}
+	}
+}
+
+// snapshot returns the size and mtime of every file under root, media included: they are copied with the export
+func (w *Watcher) snapshot(root string) map[string]fileState {
+	files := make(map[string]fileState)
+	err := filepath.WalkDir(root, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			w.log.Debug("error while watching", "path", path, "err", err)
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	if err != nil {
+		w.log.Error("error while watching", "path", root, "err", err)
+	}
+	return files
+}
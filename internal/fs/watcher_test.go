@@ -0,0 +1,62 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_Watch(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "messages.html"), []byte("booba"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changesChan := make(chan struct{})
+	watcher := NewWatcher(logger, 10*time.Millisecond, 100*time.Millisecond)
+	go watcher.Watch(ctx, root, changesChan)
+
+	select {
+	case <-changesChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Файлы, найденные при запуске, ожидаются как изменение")
+	}
+
+	// файл дописывается дольше debounce: изменение сигнализируется после окончания записи
+	name := filepath.Join(root, "group", "messages.html")
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	var written time.Time
+	for i := 0; i < 10; i++ {
+		require.NoError(t, os.WriteFile(name, bytes.Repeat([]byte("b"), i+1), 0644))
+		written = time.Now()
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-changesChan:
+		assert.GreaterOrEqual(t, time.Since(written), 100*time.Millisecond, "Изменение сигнализируется через debounce после последней записи")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ожидается изменение после записи файла")
+	}
+
+	select {
+	case <-changesChan:
+		t.Fatal("Без изменений файлов сигнала нет")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changesChan:
+		assert.False(t, ok, "Канал закрывается после отмены контекста")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ожидается закрытие канала")
+	}
+}
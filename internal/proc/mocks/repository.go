@@ -2,28 +2,159 @@ package mocks
 
 import (
 	"context"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"iter"
+	"sync"
+	"time"
 
 	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
 )
 
+// RepositoryMock is safe for concurrent use by parser workers
 type RepositoryMock struct {
+	mu          sync.Mutex
 	UpsertCalls []models.Message
-	Err         error
+	Events      []models.ServiceEvent
+	Manifest    map[string]models.ManifestEntry
+	// Runs are saved runs in the order of saving, a run is saved at the start and at the end
+	Runs []models.IngestRun
+	Err  error
+	// UpsertErr fails UpsertMany, the messages are counted as failed
+	UpsertErr error
+	Deleted   []MarkDeletedCall
+	// Seen are UUIDs passed to MarkSeen by run
+	Seen map[string][]string
+}
+
+// MarkDeletedCall is a call of MarkDeleted
+type MarkDeletedCall struct {
+	Group      string
+	KeepRunIDs []string
 }
 
-func (f *RepositoryMock) UpsertMany(messagesChan <-chan models.Message) {
+func (f *RepositoryMock) UpsertMany(messagesChan <-chan models.Message) (repositories.IngestResult, error) {
 	for m := range messagesChan {
 		f.UpsertCalls = append(f.UpsertCalls, m)
 	}
+	if f.UpsertErr != nil {
+		return repositories.IngestResult{Failed: len(f.UpsertCalls)}, f.UpsertErr
+	}
+	return repositories.IngestResult{Inserted: len(f.UpsertCalls)}, nil
 }
 
 func (f *RepositoryMock) GetGroups(ctx context.Context) ([]string, error) {
 	return nil, nil
 }
 
-func (f *RepositoryMock) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]*models.Message, error) {
+func (f *RepositoryMock) GetTagCountsByMediaKind(ctx context.Context, group string) ([]repositories.TagMediaKindCount, error) {
 	return nil, nil
 }
+
+func (f *RepositoryMock) GetMostReactedTags(ctx context.Context, group string, limit int) ([]repositories.TagReactions, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetReplyChains(ctx context.Context, group string) ([]repositories.ReplyChain, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetTagCounts(ctx context.Context, group string) ([]repositories.TagCount, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetTagCountsByPeriod(ctx context.Context, group string, period repositories.Period, loc *time.Location) ([]repositories.TagPeriodCount, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetTagsHistogram(ctx context.Context, group string) ([]repositories.TagsHistogramBucket, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetTagSpans(ctx context.Context, group string) ([]repositories.TagSpan, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetEditedMessages(ctx context.Context, group string) iter.Seq2[*models.Message, error] {
+	return func(yield func(*models.Message, error) bool) {}
+}
+
+func (f *RepositoryMock) MarkDeleted(ctx context.Context, group string, keepRunIDs []string, deletedAt time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Deleted = append(f.Deleted, MarkDeletedCall{Group: group, KeepRunIDs: keepRunIDs})
+	return 0, f.Err
+}
+
+func (f *RepositoryMock) MarkSeen(ctx context.Context, uuids []string, runID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Seen == nil {
+		f.Seen = make(map[string][]string)
+	}
+	f.Seen[runID] = append(f.Seen[runID], uuids...)
+	return f.Err
+}
+
+func (f *RepositoryMock) UpdateTags(ctx context.Context, uuid string, rawTags, tags []string) error {
+	return f.Err
+}
+
+func (f *RepositoryMock) UpsertServiceEvents(ctx context.Context, events []models.ServiceEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Events = append(f.Events, events...)
+	return f.Err
+}
+
+func (f *RepositoryMock) GetManifestEntry(ctx context.Context, path string) (*models.ManifestEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.Manifest[path]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (f *RepositoryMock) UpsertManifestEntries(ctx context.Context, entries []models.ManifestEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Manifest == nil {
+		f.Manifest = make(map[string]models.ManifestEntry)
+	}
+	for _, e := range entries {
+		f.Manifest[e.Path] = e
+	}
+	return f.Err
+}
+
+func (f *RepositoryMock) SaveIngestRun(ctx context.Context, run models.IngestRun) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Runs = append(f.Runs, run)
+	return f.Err
+}
+
+func (f *RepositoryMock) GetIngestRuns(ctx context.Context, limit int) ([]*models.IngestRun, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetIngestRun(ctx context.Context, runID string) (*models.IngestRun, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetTagChanges(ctx context.Context, group, tag string, limit int) ([]*models.TagChange, error) {
+	return nil, nil
+}
+
+func (f *RepositoryMock) GetServiceEvents(ctx context.Context, group string, types ...string) iter.Seq2[*models.ServiceEvent, error] {
+	return func(yield func(*models.ServiceEvent, error) bool) {}
+}
+
+func (f *RepositoryMock) FindMessages(ctx context.Context, q repositories.MessageQuery) iter.Seq2[*models.Message, error] {
+	return func(yield func(*models.Message, error) bool) {}
+}
+
+func (f *RepositoryMock) CountMessages(ctx context.Context, q repositories.MessageQuery) (int, error) {
+	return 0, nil
+}
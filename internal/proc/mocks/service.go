@@ -1,16 +1,58 @@
 package mocks
 
-import "github.com/meesooqa/tgtag/pkg/models"
+import (
+	"fmt"
+	"io"
+	"sync"
 
+	"github.com/meesooqa/tgtag/internal/fs"
+	"github.com/meesooqa/tgtag/internal/tg"
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// ServiceMock is safe for concurrent use by parser workers
 type ServiceMock struct {
+	mu        sync.Mutex
 	CallCount int
 	Err       error
+	// Skipped is the number of skipped messages reported for every file
+	Skipped int
+	// Events are service events reported for every file
+	Events []models.ServiceEvent
+	// Tags are tags of the message sent for every file
+	Tags []string
+	// Messages is the number of messages sent for every file, one if not set.
+	// The first one has the file name as message ID and UUID, the others "name#N".
+	Messages int
 }
 
-func (fs *ServiceMock) ParseArchivedFile(filename string, messagesChan chan<- models.Message) error {
+func (fs *ServiceMock) ParseArchivedFile(file fs.ExportFile, messagesChan chan<- models.Message) (*tg.ParseReport, error) {
+	fs.mu.Lock()
 	fs.CallCount++
-	messagesChan <- models.Message{
-		MessageID: filename,
+	fs.mu.Unlock()
+
+	// the file is read as parsers do, files that don't exist are parsed too
+	if f, err := file.Open(); err == nil {
+		_, _ = io.Copy(io.Discard, f)
+		_ = f.Close()
+	}
+	count := max(fs.Messages, 1)
+	for i := 0; i < count; i++ {
+		id := file.Name
+		if i > 0 {
+			id = fmt.Sprintf("%s#%d", file.Name, i)
+		}
+		messagesChan <- models.Message{
+			UUID:      id,
+			MessageID: id,
+			Tags:      fs.Tags,
+		}
+	}
+	report := tg.NewParseReport(file.Name)
+	report.Parsed = count
+	report.Events = fs.Events
+	for i := 0; i < fs.Skipped; i++ {
+		report.Skip("", "selector", "reason")
 	}
-	return fs.Err
+	return report, fs.Err
 }
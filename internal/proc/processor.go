@@ -1,46 +1,499 @@
 package proc
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
+	"github.com/meesooqa/tgtag/internal/tags"
 	"github.com/meesooqa/tgtag/internal/tg"
 	"github.com/meesooqa/tgtag/pkg/models"
 	"github.com/meesooqa/tgtag/pkg/repositories"
 )
 
+// maxHeldMessages is the default limit of held messages of one file: an HTML page has fewer messages
+const maxHeldMessages = 10000
+
 type Processor struct {
-	log     *slog.Logger
-	service tg.Service
-	repo    repositories.Repository
+	log          *slog.Logger
+	service      tg.Service
+	repo         repositories.Repository
+	normalizer   *tags.Normalizer
+	maxSkipRatio float64
+	// maxHeld limits messages of one file held until its skip ratio is checked, see parseFile
+	maxHeld int
+	workers int
+	// runID marks the run record, files of the manifest and messages saved by the run
+	runID      string
+	configHash string
+	// force parses files even if the manifest has them unchanged
+	force  bool
+	stats  []WorkerStats
+	result repositories.IngestResult
+
+	// mu guards the state shared by workers
+	mu       sync.Mutex
+	manifest []models.ManifestEntry
+	run      models.IngestRun
+	// skipReasons counts skipped messages of the run per reason
+	skipReasons map[string]int
+	err         error
+	// groups are groups of parsed messages, written by normalizeTags only
+	groups map[string]bool
+	// skippedGroups are groups of files with skipped messages, their saved messages may be skipped now and are not deleted.
+	// skippedUnknown is set by a file that has skipped messages only, its group is not known.
+	skippedGroups  map[string]bool
+	skippedUnknown bool
 }
 
-func NewProcessor(log *slog.Logger, service tg.Service, repo repositories.Repository) *Processor {
+// WorkerStats is the work done by one parser worker during the run
+type WorkerStats struct {
+	Worker int
+	// Files is the number of parsed files, Unchanged and Failed files are not counted
+	Files     int
+	Unchanged int
+	Failed    int
+	Messages  int
+	// Busy is the time spent on parsing
+	Busy time.Duration
+}
+
+func NewProcessor(log *slog.Logger, conf *config.SystemConfig, service tg.Service, repo repositories.Repository) *Processor {
 	return &Processor{
-		log:     log,
-		service: service,
-		repo:    repo,
+		log:          log,
+		service:      service,
+		repo:         repo,
+		normalizer:   tags.NewNormalizer(conf.Tags),
+		maxSkipRatio: conf.MaxSkipRatio,
+		maxHeld:      maxHeldMessages,
+		workers:      max(conf.Workers, 1),
+		runID:        uuid.NewString(),
+		configHash:   conf.Hash(),
 	}
 }
 
-func (p *Processor) ProcessFile(filesChan <-chan string, wg *sync.WaitGroup) {
+// SetForce makes the processor parse unchanged files too
+func (p *Processor) SetForce(force bool) {
+	p.force = force
+}
+
+// RunID returns the ID of the run saved to the manifest
+func (p *Processor) RunID() string {
+	return p.runID
+}
+
+// Run returns the record of the run, it is ready when ProcessFile is done
+func (p *Processor) Run() models.IngestRun {
+	return p.run
+}
+
+// Result returns the numbers of saved messages, it is ready when ProcessFile is done
+func (p *Processor) Result() repositories.IngestResult {
+	return p.result
+}
+
+// Stats returns the work done by every worker, it is ready when ProcessFile is done
+func (p *Processor) Stats() []WorkerStats {
+	return p.stats
+}
+
+// ProcessFile parses files of filesChan with the pool of workers and saves their messages.
+// Workers finish, then the messages channel is closed, then the repository saves the rest.
+// With several workers files are parsed in any order, pages of one export too.
+func (p *Processor) ProcessFile(filesChan <-chan fs.ExportFile, wg *sync.WaitGroup) {
 	defer wg.Done()
+	p.startRun()
+	defer p.finishRun()
 
+	parsedChan := make(chan models.Message, 10)
 	messagesChan := make(chan models.Message, 10)
 
 	var wgm sync.WaitGroup
 	wgm.Add(1)
 	go func() {
 		defer wgm.Done()
-		p.repo.UpsertMany(messagesChan)
+		result, err := p.repo.UpsertMany(messagesChan)
+		p.result = result
+		if err != nil {
+			p.fail(fmt.Errorf("saving messages: %w", err))
+		}
+	}()
+	go func() {
+		defer close(messagesChan)
+		p.normalizeTags(parsedChan, messagesChan)
 	}()
 
-	for filename := range filesChan {
-		if err := p.service.ParseArchivedFile(filename, messagesChan); err != nil {
-			p.log.Error("error processing file", "filename", filename, "err", err)
-		}
+	p.stats = make([]WorkerStats, p.workers)
+	var wgw sync.WaitGroup
+	for i := range p.stats {
+		p.stats[i].Worker = i + 1
+		wgw.Add(1)
+		go func(stats *WorkerStats) {
+			defer wgw.Done()
+			p.parseFiles(filesChan, parsedChan, stats)
+			p.log.Debug("worker done", "worker", stats.Worker, "files", stats.Files, "unchanged", stats.Unchanged,
+				"failed", stats.Failed, "messages", stats.Messages, "busy", stats.Busy)
+		}(&p.stats[i])
 	}
-	close(messagesChan)
+	wgw.Wait()
+	close(parsedChan)
 
 	wgm.Wait()
+	if p.result.Failed > 0 {
+		// it is not known which files the messages are from, the files are parsed again next time
+		p.log.Warn("manifest is not saved, some messages are not saved", "failed", p.result.Failed)
+		return
+	}
+	// files are added to the manifest after their messages are saved
+	if err := p.repo.UpsertManifestEntries(context.TODO(), p.manifest); err != nil {
+		p.log.Error("error saving manifest", "err", err)
+	}
+	p.markDeleted()
+}
+
+// markDeleted marks saved messages of the parsed groups that are not found by this run.
+// Messages of unchanged files are marked seen by checkManifest, they have the run too.
+func (p *Processor) markDeleted() {
+	if p.Err() != nil || p.run.FilesFailed > 0 {
+		// a failed file may have messages of any group
+		p.log.Debug("deleted messages are not marked, the run is not complete", "failed", p.run.FilesFailed)
+		return
+	}
+	if p.skippedUnknown {
+		p.log.Info("deleted messages are not marked, a file has skipped messages only")
+		return
+	}
+	groups := make([]string, 0, len(p.groups))
+	for group := range p.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	deletedAt := time.Now().UTC()
+	for _, group := range groups {
+		if p.skippedGroups[group] {
+			// a skipped message is in the export, but it is not in this run
+			p.log.Info("deleted messages are not marked, the group has skipped messages", "group", group)
+			continue
+		}
+		deleted, err := p.repo.MarkDeleted(context.TODO(), group, []string{p.runID}, deletedAt)
+		if err != nil {
+			p.log.Error("error marking deleted messages", "group", group, "err", err)
+			continue
+		}
+		if deleted > 0 {
+			p.log.Info("messages not found in the export are marked deleted", "group", group, "deleted", deleted)
+		}
+		p.run.MessagesDeleted += deleted
+	}
+}
+
+// parseFiles is the worker: it parses files until filesChan is closed
+func (p *Processor) parseFiles(filesChan <-chan fs.ExportFile, parsedChan chan<- models.Message, stats *WorkerStats) {
+	for file := range filesChan {
+		p.countFileSeen()
+		if p.Err() != nil {
+			// the run has failed, the rest of files is drained so that the finder is not blocked
+			continue
+		}
+		entry, unchanged := p.checkManifest(file)
+		if unchanged {
+			p.log.Info("file unchanged, skipped", "filename", file.Name)
+			stats.Unchanged++
+			p.addRunFile(models.IngestRunFile{Path: file.Name, Status: models.IngestFileUnchanged}, nil)
+			continue
+		}
+		var hash *fs.ContentHash
+		if entry != nil && entry.Hash == "" {
+			file, hash = file.WithHash()
+		}
+		start := time.Now()
+		parsed, err := p.parseFile(file, parsedChan)
+		stats.Busy += time.Since(start)
+		report := parsed.report
+		if err != nil {
+			p.log.Error("error processing file", "filename", file.Name, "err", err)
+			stats.Failed++
+			p.addRunFile(models.IngestRunFile{Path: file.Name, Status: models.IngestFileFailed, Error: err.Error()}, report)
+			// messages parsed before the error are saved, the file is parsed again next time
+			sendMessages(parsed.held, parsedChan)
+			continue
+		}
+		stats.Files++
+		stats.Messages += report.Parsed
+		if err := p.checkReport(report); err != nil {
+			// held messages of the file are not saved, the run fails
+			if parsed.sent {
+				p.log.Warn("messages of the file are saved, it has more messages than are held", "filename", file.Name, "held", p.maxHeld)
+			}
+			p.addRunFile(models.IngestRunFile{Path: file.Name, Status: models.IngestFileFailed, Error: err.Error()}, report)
+			p.fail(err)
+			continue
+		}
+		p.addSkippedGroups(parsed.groups, report)
+		sendMessages(parsed.held, parsedChan)
+		if err := p.repo.UpsertServiceEvents(context.TODO(), report.Events); err != nil {
+			p.log.Error("error saving service events", "filename", file.Name, "err", err)
+		}
+		p.addRunFile(models.IngestRunFile{Path: file.Name, Status: models.IngestFileParsed}, report)
+		if entry != nil && hash != nil {
+			entry.Hash = p.contentHash(file, hash)
+		}
+		if entry != nil && entry.Hash != "" {
+			entry.Messages = report.Parsed
+			entry.RunID = p.runID
+			entry.UUIDs = parsed.uuids
+			p.addToManifest(*entry)
+		}
+	}
+}
+
+// parsedFile is the file parsed by parseFile
+type parsedFile struct {
+	report *tg.ParseReport
+	// held are messages that are not sent to parsedChan yet
+	held []models.Message
+	// sent is set when the file has more than maxHeld messages and they are sent as they are parsed
+	sent bool
+	// groups are groups of messages of the file
+	groups map[string]bool
+	// uuids are messages of the file, they are saved to the manifest
+	uuids []string
+}
+
+// parseFile holds messages of the file, so that they are saved only after checkReport of the whole file.
+// Up to maxHeld messages are held: a bigger file (a large result.json) is sent as it is parsed after that,
+// the memory of the streaming parser is not given up for the skip check.
+func (p *Processor) parseFile(file fs.ExportFile, parsedChan chan<- models.Message) (*parsedFile, error) {
+	parsed := &parsedFile{groups: make(map[string]bool)}
+	fileChan := make(chan models.Message)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range fileChan {
+			parsed.groups[msg.Group] = true
+			parsed.uuids = append(parsed.uuids, msg.UUID)
+			if parsed.sent {
+				parsedChan <- msg
+				continue
+			}
+			parsed.held = append(parsed.held, msg)
+			if len(parsed.held) >= p.maxHeld {
+				sendMessages(parsed.held, parsedChan)
+				parsed.held, parsed.sent = nil, true
+			}
+		}
+	}()
+	report, err := p.service.ParseArchivedFile(file, fileChan)
+	close(fileChan)
+	<-done
+	parsed.report = report
+	return parsed, err
+}
+
+func sendMessages(messages []models.Message, parsedChan chan<- models.Message) {
+	for _, msg := range messages {
+		parsedChan <- msg
+	}
+}
+
+// startRun saves the record of the run, so that a run that has not finished is seen too
+func (p *Processor) startRun() {
+	p.run = models.IngestRun{RunID: p.runID, StartedAt: time.Now().UTC(), ConfigHash: p.configHash}
+	p.skipReasons = make(map[string]int)
+	p.groups = make(map[string]bool)
+	p.skippedGroups = make(map[string]bool)
+	if err := p.repo.SaveIngestRun(context.TODO(), p.run); err != nil {
+		p.log.Error("error saving ingest run", "runID", p.runID, "err", err)
+	}
+}
+
+// finishRun saves the record of the run with its results
+func (p *Processor) finishRun() {
+	p.run.FinishedAt = time.Now().UTC()
+	p.run.MessagesUpserted = p.result.Inserted
+	p.run.MessagesModified = p.result.Modified
+	p.run.MessagesFailed = p.result.Failed
+	for reason, count := range p.skipReasons {
+		p.run.SkipReasons = append(p.run.SkipReasons, models.SkipReasonCount{Reason: reason, Count: count})
+	}
+	sort.Slice(p.run.SkipReasons, func(i, j int) bool {
+		a, b := p.run.SkipReasons[i], p.run.SkipReasons[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Reason < b.Reason
+	})
+	if err := p.Err(); err != nil {
+		p.run.Error = err.Error()
+	}
+	if err := p.repo.SaveIngestRun(context.TODO(), p.run); err != nil {
+		p.log.Error("error saving ingest run", "runID", p.runID, "err", err)
+	}
+}
+
+func (p *Processor) countFileSeen() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.run.FilesSeen++
+}
+
+// addRunFile adds the file and its skipped messages to the run, report is nil for unchanged files
+func (p *Processor) addRunFile(file models.IngestRunFile, report *tg.ParseReport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch file.Status {
+	case models.IngestFileUnchanged:
+		p.run.FilesSkipped++
+	case models.IngestFileFailed:
+		p.run.FilesFailed++
+	}
+	if report != nil {
+		file.Messages = report.Parsed
+		file.Skipped = len(report.Skipped)
+		p.run.MessagesParsed += report.Parsed
+		p.run.MessagesSkipped += len(report.Skipped)
+		for _, skip := range report.Skipped {
+			p.skipReasons[skip.Reason]++
+		}
+	}
+	p.run.Files = append(p.run.Files, file)
+}
+
+// addSkippedGroups keeps groups of the file with skipped messages from being reconciled
+func (p *Processor) addSkippedGroups(groups map[string]bool, report *tg.ParseReport) {
+	if len(report.Skipped) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(groups) == 0 {
+		p.skippedUnknown = true
+	}
+	for group := range groups {
+		p.skippedGroups[group] = true
+	}
+}
+
+// fail keeps the first reason of the failed run
+func (p *Processor) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// markSeen sets the run to messages of the unchanged file, so that they are not marked deleted.
+// The file is parsed again if its messages are not known or can't be marked.
+func (p *Processor) markSeen(file fs.ExportFile, prev *models.ManifestEntry) bool {
+	if len(prev.UUIDs) == 0 && prev.Messages > 0 {
+		// the entry is saved before UUIDs were added to the manifest
+		p.log.Debug("messages of the file are not in the manifest, it is parsed again", "filename", file.Name)
+		return false
+	}
+	if err := p.repo.MarkSeen(context.TODO(), prev.UUIDs, p.runID); err != nil {
+		p.log.Warn("can't mark messages of the unchanged file, it is parsed again", "filename", file.Name, "err", err)
+		return false
+	}
+	return true
+}
+
+func (p *Processor) addToManifest(entry models.ManifestEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.manifest = append(p.manifest, entry)
+}
+
+// checkManifest compares the file with the manifest: the same size and mtime, then the same content hash.
+// Only a file of the same size may be unchanged, a new file or a file of another size is not hashed here, it is hashed while parsing.
+// It returns the manifest entry to save after parsing, nil if the file can't be checked.
+func (p *Processor) checkManifest(file fs.ExportFile) (*models.ManifestEntry, bool) {
+	info, err := file.Stat()
+	if err != nil {
+		// the parser reports the file it can't read
+		p.log.Debug("can't stat file, manifest is not checked", "filename", file.Name, "err", err)
+		return nil, false
+	}
+	entry := &models.ManifestEntry{Path: file.Name, Size: info.Size(), ModTime: info.ModTime().UTC()}
+
+	prev, err := p.repo.GetManifestEntry(context.TODO(), file.Name)
+	if err != nil {
+		p.log.Warn("can't get manifest entry", "filename", file.Name, "err", err)
+	}
+	// entries of tar.gz archives have no mtime, their hash is compared
+	if !p.force && prev != nil && prev.Size == entry.Size && !entry.ModTime.IsZero() && prev.ModTime.Equal(entry.ModTime) {
+		if !p.markSeen(file, prev) {
+			return entry, false
+		}
+		return entry, true
+	}
+
+	if p.force || prev == nil || prev.Size != entry.Size {
+		return entry, false
+	}
+	entry.Hash, err = file.Hash()
+	if err != nil {
+		p.log.Debug("can't hash file, manifest is not checked", "filename", file.Name, "err", err)
+		return nil, false
+	}
+	if prev.Hash == entry.Hash && p.markSeen(file, prev) {
+		// the file is touched only, the new mtime is saved to skip hashing next time
+		entry.Messages, entry.RunID, entry.UUIDs = prev.Messages, prev.RunID, prev.UUIDs
+		p.addToManifest(*entry)
+		return entry, true
+	}
+	return entry, false
+}
+
+// contentHash returns the hash computed while parsing, the file is read again if the parser has not read it
+func (p *Processor) contentHash(file fs.ExportFile, hash *fs.ContentHash) string {
+	if sum, ok := hash.Sum(); ok {
+		return sum
+	}
+	sum, err := file.Hash()
+	if err != nil {
+		p.log.Debug("can't hash file, it is not added to the manifest", "filename", file.Name, "err", err)
+	}
+	return sum
+}
+
+// normalizeTags is the stage between the parser and the repository, raw tags are kept to re-apply changed rules.
+// Messages are marked with the run that saves them.
+func (p *Processor) normalizeTags(in <-chan models.Message, out chan<- models.Message) {
+	for msg := range in {
+		msg.RawTags = msg.Tags
+		msg.RunID = p.runID
+		p.groups[msg.Group] = true
+		msg.Tags = p.normalizer.Normalize(msg.Tags)
+		out <- msg
+	}
+}
+
+// Err returns the reason of the failed run
+func (p *Processor) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// checkReport logs the summary of the parsed file and fails when too many messages are skipped
+func (p *Processor) checkReport(report *tg.ParseReport) error {
+	for _, skip := range report.Skipped {
+		p.log.Debug("message skipped", "filename", report.Filename, "messageID", skip.MessageID, "selector", skip.Selector, "reason", skip.Reason)
+	}
+	p.log.Info("file parsed", "filename", report.Filename, "parsed", report.Parsed, "skipped", len(report.Skipped))
+
+	if p.maxSkipRatio > 0 && report.SkipRatio() > p.maxSkipRatio {
+		return fmt.Errorf("file %s: skipped %d of %d messages, skip ratio %.2f exceeds %.2f",
+			report.Filename, len(report.Skipped), report.Total(), report.SkipRatio(), p.maxSkipRatio)
+	}
+	return nil
 }
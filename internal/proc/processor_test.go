@@ -2,15 +2,26 @@ package proc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
 	"github.com/meesooqa/tgtag/internal/proc/mocks"
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+	"github.com/meesooqa/tgtag/pkg/repositories/memory"
 )
 
 func TestProcessor_ProcessFile_Success(t *testing.T) {
@@ -18,10 +29,10 @@ func TestProcessor_ProcessFile_Success(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	fService := &mocks.ServiceMock{}
 	fRepo := &mocks.RepositoryMock{}
-	processor := NewProcessor(logger, fService, fRepo)
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
 
-	filesChan := make(chan string, 1)
-	filesChan <- "file1.txt"
+	filesChan := make(chan fs.ExportFile, 1)
+	filesChan <- fs.NewExportFile("file1.txt")
 	close(filesChan)
 
 	var wg sync.WaitGroup
@@ -42,10 +53,10 @@ func TestProcessor_ProcessFile_Error(t *testing.T) {
 		Err: parseErr,
 	}
 	fRepo := &mocks.RepositoryMock{}
-	processor := NewProcessor(logger, fService, fRepo)
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
 
-	filesChan := make(chan string, 1)
-	filesChan <- "file2.txt"
+	filesChan := make(chan fs.ExportFile, 1)
+	filesChan <- fs.NewExportFile("file2.txt")
 	close(filesChan)
 
 	var wg sync.WaitGroup
@@ -66,3 +77,373 @@ func TestProcessor_ProcessFile_Error(t *testing.T) {
 	assert.Equal(t, "error processing file", logMap["msg"], "Ожидается, что ошибка обработки сообщения будет залогирована")
 	assert.Equal(t, "file2.txt", logMap["filename"])
 }
+
+func TestProcessor_ProcessFile_SkipRatioExceeded(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	// 1 сообщение разобрано, 3 пропущено: доля пропусков 0.75
+	fService := &mocks.ServiceMock{Skipped: 3}
+	fRepo := &mocks.RepositoryMock{}
+	processor := NewProcessor(logger, &config.SystemConfig{MaxSkipRatio: 0.5}, fService, fRepo)
+
+	filesChan := make(chan fs.ExportFile, 2)
+	filesChan <- fs.NewExportFile("file1.html")
+	filesChan <- fs.NewExportFile("file2.html")
+	close(filesChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processor.ProcessFile(filesChan, &wg)
+	wg.Wait()
+
+	assert.EqualError(t, processor.Err(), "file file1.html: skipped 3 of 4 messages, skip ratio 0.75 exceeds 0.50")
+	assert.Equal(t, 1, fService.CallCount, "После превышения порога файлы не разбираются")
+	assert.Contains(t, buf.String(), "file parsed")
+	assert.Empty(t, fRepo.UpsertCalls, "Сообщения файла с превышением порога не сохраняются")
+}
+
+func TestProcessor_ProcessFile_SkipRatioExceededHeldLimit(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	// 3 сообщения разобрано, 9 пропущено, в памяти держится не больше 2
+	fService := &mocks.ServiceMock{Messages: 3, Skipped: 9}
+	fRepo := &mocks.RepositoryMock{}
+	processor := NewProcessor(logger, &config.SystemConfig{MaxSkipRatio: 0.5}, fService, fRepo)
+	processor.maxHeld = 2
+	runProcessor(processor, "file1.html")
+
+	assert.Error(t, processor.Err())
+	assert.Len(t, fRepo.UpsertCalls, 3, "Сообщения сверх лимита отправляются по мере разбора")
+	assert.Contains(t, buf.String(), "messages of the file are saved")
+}
+
+func TestProcessor_ProcessFile_SkipRatioWithinThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	fService := &mocks.ServiceMock{Skipped: 1}
+	fRepo := &mocks.RepositoryMock{}
+	processor := NewProcessor(logger, &config.SystemConfig{MaxSkipRatio: 0.5}, fService, fRepo)
+
+	filesChan := make(chan fs.ExportFile, 2)
+	filesChan <- fs.NewExportFile("file1.html")
+	filesChan <- fs.NewExportFile("file2.html")
+	close(filesChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processor.ProcessFile(filesChan, &wg)
+	wg.Wait()
+
+	assert.NoError(t, processor.Err())
+	assert.Equal(t, 2, fService.CallCount)
+	assert.Equal(t, 2, len(fRepo.UpsertCalls))
+}
+
+func TestProcessor_ProcessFile_ServiceEvents(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	events := []models.ServiceEvent{{UUID: "1", Type: models.ServiceEventPin}}
+	fService := &mocks.ServiceMock{Events: events}
+	fRepo := &mocks.RepositoryMock{}
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+
+	filesChan := make(chan fs.ExportFile, 1)
+	filesChan <- fs.NewExportFile("file1.html")
+	close(filesChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processor.ProcessFile(filesChan, &wg)
+	wg.Wait()
+
+	assert.Equal(t, events, fRepo.Events, "Сервисные события файла сохраняются в репозиторий")
+}
+
+func TestProcessor_ProcessFile_NormalizeTags(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	fService := &mocks.ServiceMock{Tags: []string{"Booba", "booba_"}}
+	fRepo := &mocks.RepositoryMock{}
+	conf := &config.SystemConfig{Tags: config.TagsConfig{CaseFold: true, TrimPunctuation: true}}
+	processor := NewProcessor(logger, conf, fService, fRepo)
+
+	filesChan := make(chan fs.ExportFile, 1)
+	filesChan <- fs.NewExportFile("file1.html")
+	close(filesChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processor.ProcessFile(filesChan, &wg)
+	wg.Wait()
+
+	assert.Equal(t, 1, len(fRepo.UpsertCalls))
+	assert.Equal(t, []string{"booba"}, fRepo.UpsertCalls[0].Tags, "Теги нормализуются перед сохранением")
+	assert.Equal(t, []string{"Booba", "booba_"}, fRepo.UpsertCalls[0].RawTags, "Исходные теги сохраняются")
+}
+
+func runProcessor(processor *Processor, files ...string) {
+	filesChan := make(chan fs.ExportFile, len(files))
+	for _, file := range files {
+		filesChan <- fs.NewExportFile(file)
+	}
+	close(filesChan)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processor.ProcessFile(filesChan, &wg)
+	wg.Wait()
+}
+
+func TestProcessor_ProcessFile_Manifest(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	name := filepath.Join(t.TempDir(), "messages.html")
+	require.NoError(t, os.WriteFile(name, []byte("booba"), 0644))
+	fService := &mocks.ServiceMock{}
+	fRepo := &mocks.RepositoryMock{}
+
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	runProcessor(processor, name)
+	assert.Equal(t, 1, fService.CallCount)
+	require.Contains(t, fRepo.Manifest, name)
+	entry := fRepo.Manifest[name]
+	assert.Equal(t, int64(5), entry.Size)
+	assert.Equal(t, 1, entry.Messages)
+	assert.Equal(t, processor.RunID(), entry.RunID)
+	assert.Len(t, entry.Hash, 64)
+
+	runProcessor(NewProcessor(logger, &config.SystemConfig{}, fService, fRepo), name)
+	assert.Equal(t, 1, fService.CallCount, "Неизменённый файл не разбирается")
+	assert.Contains(t, buf.String(), "file unchanged, skipped")
+
+	// только mtime изменён: сверяется хеш, файл не разбирается
+	touched := entry.ModTime.Add(time.Hour)
+	require.NoError(t, os.Chtimes(name, touched, touched))
+	runProcessor(NewProcessor(logger, &config.SystemConfig{}, fService, fRepo), name)
+	assert.Equal(t, 1, fService.CallCount, "Файл с тем же содержимым не разбирается")
+	assert.True(t, touched.Equal(fRepo.Manifest[name].ModTime), "Новый mtime сохраняется в манифест")
+	assert.Equal(t, entry.RunID, fRepo.Manifest[name].RunID)
+
+	forced := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	forced.SetForce(true)
+	runProcessor(forced, name)
+	assert.Equal(t, 2, fService.CallCount, "С force файл разбирается")
+	assert.Equal(t, forced.RunID(), fRepo.Manifest[name].RunID)
+
+	require.NoError(t, os.WriteFile(name, []byte("booba shy"), 0644))
+	runProcessor(NewProcessor(logger, &config.SystemConfig{}, fService, fRepo), name)
+	assert.Equal(t, 3, fService.CallCount, "Изменённый файл разбирается")
+}
+
+func TestProcessor_ProcessFile_ManifestSkipRatioExceeded(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	name := filepath.Join(t.TempDir(), "messages.html")
+	require.NoError(t, os.WriteFile(name, []byte("booba"), 0644))
+	fService := &mocks.ServiceMock{Skipped: 3}
+	fRepo := &mocks.RepositoryMock{}
+
+	processor := NewProcessor(logger, &config.SystemConfig{MaxSkipRatio: 0.5}, fService, fRepo)
+	runProcessor(processor, name)
+	assert.Error(t, processor.Err())
+	assert.NotContains(t, fRepo.Manifest, name, "Файл с превышением порога пропусков не попадает в манифест")
+}
+
+func TestProcessor_ProcessFile_Workers(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	fService := &mocks.ServiceMock{Messages: 3}
+	fRepo := &mocks.RepositoryMock{}
+	processor := NewProcessor(logger, &config.SystemConfig{Workers: 4}, fService, fRepo)
+
+	files := make([]string, 100)
+	for i := range files {
+		files[i] = "file" + strconv.Itoa(i) + ".html"
+	}
+	runProcessor(processor, files...)
+
+	assert.Equal(t, 100, fService.CallCount)
+	assert.Equal(t, 300, len(fRepo.UpsertCalls), "Все сообщения сохраняются")
+	seen := make(map[string]bool, len(fRepo.UpsertCalls))
+	for _, msg := range fRepo.UpsertCalls {
+		assert.False(t, seen[msg.MessageID], "Сообщение %s отправлено дважды", msg.MessageID)
+		seen[msg.MessageID] = true
+	}
+
+	stats := processor.Stats()
+	assert.Len(t, stats, 4)
+	parsed, messages := 0, 0
+	for i, s := range stats {
+		assert.Equal(t, i+1, s.Worker)
+		parsed += s.Files
+		messages += s.Messages
+	}
+	assert.Equal(t, 100, parsed, "Каждый файл разобран одним воркером")
+	assert.Equal(t, 300, messages)
+}
+
+func TestProcessor_ProcessFile_UpsertError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	name := filepath.Join(t.TempDir(), "messages.html")
+	require.NoError(t, os.WriteFile(name, []byte("booba"), 0644))
+	fService := &mocks.ServiceMock{}
+	fRepo := &mocks.RepositoryMock{UpsertErr: errors.New("bulk write failed")}
+
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	runProcessor(processor, name)
+
+	assert.EqualError(t, processor.Err(), "saving messages: bulk write failed")
+	assert.Equal(t, 1, processor.Result().Failed)
+	assert.NotContains(t, fRepo.Manifest, name, "Файлы не попадают в манифест, если сообщения не сохранены")
+}
+
+func TestProcessor_ProcessFile_IngestRun(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.html")
+	changed := filepath.Join(dir, "changed.html")
+	require.NoError(t, os.WriteFile(unchanged, []byte("booba"), 0644))
+	require.NoError(t, os.WriteFile(changed, []byte("shy"), 0644))
+	fService := &mocks.ServiceMock{Skipped: 1}
+	fRepo := &mocks.RepositoryMock{}
+	conf := &config.SystemConfig{}
+	runProcessor(NewProcessor(logger, conf, fService, fRepo), unchanged)
+
+	fRepo.Runs = nil
+	require.NoError(t, os.WriteFile(changed, []byte("stare"), 0644))
+	processor := NewProcessor(logger, conf, fService, fRepo)
+	runProcessor(processor, unchanged, changed, filepath.Join(dir, "missing.html"))
+
+	require.Len(t, fRepo.Runs, 2, "Запуск сохраняется в начале и в конце")
+	assert.Equal(t, processor.RunID(), fRepo.Runs[0].RunID)
+	assert.True(t, fRepo.Runs[0].FinishedAt.IsZero(), "В начале запуск не завершён")
+
+	run := fRepo.Runs[1]
+	assert.Equal(t, processor.Run(), run)
+	assert.Equal(t, conf.Hash(), run.ConfigHash)
+	assert.False(t, run.FinishedAt.Before(run.StartedAt))
+	assert.Equal(t, 3, run.FilesSeen)
+	assert.Equal(t, 1, run.FilesSkipped)
+	// ServiceMock разбирает и несуществующий файл
+	assert.Equal(t, 0, run.FilesFailed)
+	assert.Equal(t, 2, run.MessagesParsed)
+	assert.Equal(t, 2, run.MessagesSkipped)
+	assert.Equal(t, 3, run.MessagesUpserted, "RepositoryMock считает все сообщения обоих запусков")
+	assert.Equal(t, []models.SkipReasonCount{{Reason: "reason", Count: 2}}, run.SkipReasons)
+	assert.ElementsMatch(t, []models.IngestRunFile{
+		{Path: unchanged, Status: models.IngestFileUnchanged},
+		{Path: changed, Status: models.IngestFileParsed, Messages: 1, Skipped: 1},
+		{Path: filepath.Join(dir, "missing.html"), Status: models.IngestFileParsed, Messages: 1, Skipped: 1},
+	}, run.Files)
+	for _, msg := range fRepo.UpsertCalls[1:] {
+		assert.Equal(t, processor.RunID(), msg.RunID, "Сообщения ссылаются на запуск")
+	}
+}
+
+func TestProcessor_ProcessFile_IngestRunFailed(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	fService := &mocks.ServiceMock{Err: errors.New("broken file")}
+	fRepo := &mocks.RepositoryMock{}
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	runProcessor(processor, "file1.html")
+
+	run := processor.Run()
+	assert.Equal(t, 1, run.FilesFailed)
+	assert.Equal(t, []models.IngestRunFile{
+		{Path: "file1.html", Status: models.IngestFileFailed, Messages: 1, Error: "broken file"},
+	}, run.Files)
+}
+
+func TestProcessor_ProcessFile_MarkDeleted(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.html")
+	changed := filepath.Join(dir, "changed.html")
+	require.NoError(t, os.WriteFile(unchanged, []byte("booba"), 0644))
+	require.NoError(t, os.WriteFile(changed, []byte("shy"), 0644))
+	fService := &mocks.ServiceMock{}
+	fRepo := &mocks.RepositoryMock{}
+	first := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	runProcessor(first, unchanged)
+	require.Equal(t, []mocks.MarkDeletedCall{{Group: "", KeepRunIDs: []string{first.RunID()}}}, fRepo.Deleted)
+
+	fRepo.Deleted = nil
+	require.NoError(t, os.WriteFile(changed, []byte("stare"), 0644))
+	second := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	runProcessor(second, unchanged, changed)
+	assert.Equal(t, []mocks.MarkDeletedCall{{Group: "", KeepRunIDs: []string{second.RunID()}}}, fRepo.Deleted)
+	assert.Equal(t, map[string][]string{second.RunID(): {unchanged}}, fRepo.Seen,
+		"Сообщения неизменённого файла получают новый запуск и не удаляются")
+
+	fRepo.Deleted = nil
+	fService.Err = errors.New("broken file")
+	failed := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	failed.SetForce(true)
+	runProcessor(failed, changed)
+	require.Equal(t, 1, failed.Run().FilesFailed)
+	assert.Empty(t, fRepo.Deleted, "Незавершённый запуск не помечает сообщения удалёнными")
+
+	fService.Err = nil
+	fService.Skipped = 1
+	skipped := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	skipped.SetForce(true)
+	runProcessor(skipped, changed)
+	require.Empty(t, skipped.Run().FilesFailed)
+	assert.Empty(t, fRepo.Deleted, "Группа с пропущенными сообщениями не сверяется")
+	assert.Contains(t, buf.String(), "the group has skipped messages")
+}
+
+func TestProcessor_ProcessFile_MarkDeletedWithUnchangedFile(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.html")
+	changed := filepath.Join(dir, "changed.html")
+	require.NoError(t, os.WriteFile(unchanged, []byte("booba"), 0644))
+	require.NoError(t, os.WriteFile(changed, []byte("shy"), 0644))
+	fService := &mocks.ServiceMock{Messages: 2}
+	repo := memory.NewRepository()
+	runProcessor(NewProcessor(logger, &config.SystemConfig{}, fService, repo), unchanged, changed)
+
+	// из изменённого файла той же группы удалено сообщение
+	require.NoError(t, os.WriteFile(changed, []byte("stare"), 0644))
+	fService.Messages = 1
+	second := NewProcessor(logger, &config.SystemConfig{}, fService, repo)
+	runProcessor(second, unchanged, changed)
+	require.Equal(t, 1, second.Run().FilesSkipped)
+	assert.Equal(t, 1, second.Run().MessagesDeleted)
+
+	deleted := make(map[string]bool)
+	for msg, err := range repo.FindMessages(context.Background(), repositories.MessageQuery{IncludeDeleted: true}) {
+		require.NoError(t, err)
+		if msg.DeletedAt == nil {
+			assert.Equal(t, second.RunID(), msg.RunID, "Сообщения неизменённого файла получают новый запуск")
+		}
+		deleted[msg.MessageID] = msg.DeletedAt != nil
+	}
+	assert.Equal(t, map[string]bool{
+		unchanged: false, unchanged + "#1": false,
+		changed: false, changed + "#1": true,
+	}, deleted, "Удалённое сообщение помечено, сообщения неизменённого файла — нет")
+}
+
+func TestProcessor_ProcessFile_ManifestWithoutUUIDs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	name := filepath.Join(t.TempDir(), "messages.html")
+	require.NoError(t, os.WriteFile(name, []byte("booba"), 0644))
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	fService := &mocks.ServiceMock{}
+	fRepo := &mocks.RepositoryMock{Manifest: map[string]models.ManifestEntry{
+		name: {Path: name, Size: info.Size(), ModTime: info.ModTime().UTC(), Messages: 1, RunID: "run0"},
+	}}
+	processor := NewProcessor(logger, &config.SystemConfig{}, fService, fRepo)
+	runProcessor(processor, name)
+
+	assert.Equal(t, 1, fService.CallCount, "Файл из старого манифеста без UUID разбирается снова")
+	assert.Empty(t, fRepo.Seen)
+	assert.Equal(t, []string{name}, fRepo.Manifest[name].UUIDs)
+}
@@ -0,0 +1,43 @@
+// Package storage opens the repository of storage.driver of the config
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/db"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+	"github.com/meesooqa/tgtag/pkg/repositories/sqlite"
+)
+
+// Open returns the repository and the function closing it, MongoDB is used if the config has no storage section
+func Open(log *slog.Logger, conf *config.Conf) (repositories.Repository, func(), error) {
+	storage := config.StorageConfig{}
+	if conf.Storage != nil {
+		storage = *conf.Storage
+	}
+	switch driver := cmp.Or(storage.Driver, config.StorageMongo); driver {
+	case config.StorageMongo:
+		mongoDB := db.NewMongoDB(log, conf.Mongo)
+		if err := mongoDB.Init(); err != nil {
+			return nil, nil, err
+		}
+		return repositories.NewMessageRepository(log, mongoDB), mongoDB.Close, nil
+	case config.StorageSQLite:
+		path := cmp.Or(storage.SQLitePath, "var/tgtag.db")
+		repo, err := sqlite.Open(log, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't open %s: %w", path, err)
+		}
+		closeRepo := func() {
+			if err := repo.Close(); err != nil {
+				log.Error("can't close SQLite", "err", err)
+			}
+		}
+		return repo, closeRepo, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage driver %q", driver)
+	}
+}
@@ -0,0 +1,69 @@
+package tags
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/meesooqa/tgtag/internal/config"
+)
+
+// Normalizer brings spellings of one tag to one form: "#Booba", "#booba_" -> "booba".
+// Steps run in order: NFC, case folding, trimming of trailing punctuation, aliases, stop-list.
+type Normalizer struct {
+	conf     config.TagsConfig
+	folder   cases.Caser
+	aliases  map[string]string
+	stopList map[string]bool
+}
+
+func NewNormalizer(conf config.TagsConfig) *Normalizer {
+	n := &Normalizer{
+		conf:     conf,
+		folder:   cases.Fold(),
+		aliases:  make(map[string]string, len(conf.Aliases)),
+		stopList: make(map[string]bool, len(conf.StopList)),
+	}
+	// aliases and the stop-list are written by people, they are brought to the same form as tags
+	for alias, canonical := range conf.Aliases {
+		n.aliases[n.clean(alias)] = n.clean(canonical)
+	}
+	for _, tag := range conf.StopList {
+		n.stopList[n.clean(tag)] = true
+	}
+	return n
+}
+
+// Normalize returns normalized tags without duplicates in the order of raw tags
+func (n *Normalizer) Normalize(raw []string) []string {
+	var result []string
+	seen := make(map[string]bool, len(raw))
+	for _, tag := range raw {
+		tag = n.clean(tag)
+		if canonical, ok := n.aliases[tag]; ok {
+			tag = canonical
+		}
+		if tag == "" || n.stopList[tag] || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// clean applies the steps that change the spelling of a tag
+func (n *Normalizer) clean(tag string) string {
+	if n.conf.NFC {
+		tag = norm.NFC.String(tag)
+	}
+	if n.conf.CaseFold {
+		tag = n.folder.String(tag)
+	}
+	if n.conf.TrimPunctuation {
+		tag = strings.TrimRightFunc(tag, unicode.IsPunct)
+	}
+	return tag
+}
@@ -0,0 +1,38 @@
+package tags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/meesooqa/tgtag/internal/config"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	conf := config.TagsConfig{
+		NFC:             true,
+		CaseFold:        true,
+		TrimPunctuation: true,
+		StopList:        []string{"Реклама"},
+		Aliases:         map[string]string{"Бууба": "booba"},
+	}
+	n := NewNormalizer(conf)
+
+	// "е́" и "é" совпадают после NFC
+	raw := []string{"Booba", "booba_", "бууба", "реклама", "café", "café", "news..."}
+	assert.Equal(t, []string{"booba", "caf\u00e9", "news"}, n.Normalize(raw))
+}
+
+func TestNormalizer_Normalize_Disabled(t *testing.T) {
+	n := NewNormalizer(config.TagsConfig{})
+
+	raw := []string{"Booba", "booba_", "Booba"}
+	assert.Equal(t, []string{"Booba", "booba_"}, n.Normalize(raw), "Без шагов нормализации убираются только дубли")
+}
+
+func TestNormalizer_Normalize_Empty(t *testing.T) {
+	n := NewNormalizer(config.TagsConfig{TrimPunctuation: true})
+
+	assert.Nil(t, n.Normalize(nil))
+	assert.Nil(t, n.Normalize([]string{"", "_"}), "Пустые теги отбрасываются")
+}
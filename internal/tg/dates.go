@@ -0,0 +1,159 @@
+package tg
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meesooqa/tgtag/internal/config"
+)
+
+// defaultDateLayouts are date layouts of HTML date titles made by different client versions and locales.
+// Day-first and month-first dates with slashes are ambiguous, only the US-style one is detected, the other one has to be set in the config.
+var defaultDateLayouts = []string{
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"1/2/2006 3:04:05 PM",
+	"1/2/2006 15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// defaultDayLayouts are layouts of date separators ("21 November 2024"), the date parts of the date layouts are tried too
+var defaultDayLayouts = []string{
+	"2 January 2006",
+	"January 2, 2006",
+}
+
+// tzFormats are time zone formats of HTML date titles, the groups are the sign, hours and minutes
+var tzFormats = []*regexp.Regexp{
+	regexp.MustCompile(`^UTC([+-])(\d{2}):(\d{2})$`),                 // UTC+03:00
+	regexp.MustCompile(`^(?:UTC|GMT)([+-])(\d{1,2})(?::?(\d{2}))?$`), // GMT+3, UTC+0300
+	regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})$`),                   // +03:00, +0300
+	regexp.MustCompile(`^(?:UTC|GMT|Z)$`),                            // bare UTC
+}
+
+// dateFormats parses dates of exports with the layouts and the time zone from the config
+type dateFormats struct {
+	layouts []string
+	// dayLayouts are layouts of date separators
+	dayLayouts []string
+	// loc is the time zone of dates without an offset
+	loc *time.Location
+}
+
+func newDateFormats(log *slog.Logger, conf config.DatesConfig) dateFormats {
+	d := dateFormats{layouts: defaultDateLayouts, loc: time.UTC}
+	if len(conf.Layouts) > 0 {
+		d.layouts = conf.Layouts
+	}
+	d.dayLayouts = append(d.dayLayouts, defaultDayLayouts...)
+	for _, layout := range d.layouts {
+		day, _, _ := strings.Cut(layout, " ")
+		if !slices.Contains(d.dayLayouts, day) {
+			d.dayLayouts = append(d.dayLayouts, day)
+		}
+	}
+	if conf.Timezone != "" {
+		loc, err := time.LoadLocation(conf.Timezone)
+		if err != nil {
+			log.Error("invalid dates timezone, UTC is used", "timezone", conf.Timezone, "err", err)
+		} else {
+			d.loc = loc
+		}
+	}
+	return d
+}
+
+// parse парсит дату из атрибута title: "21.11.2024 19:20:37 UTC+03:00", "11/21/2024 7:20:37 PM".
+// detected — формат, найденный в файле ранее: он пробуется первым и обновляется, так формат определяется один раз на файл.
+func (d dateFormats) parse(value string, detected *string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	dateStr, loc := value, d.loc
+	if i := strings.LastIndexByte(value, ' '); i > 0 {
+		if offset, err := parseTimezone(value[i+1:]); err == nil {
+			dateStr, loc = value[:i], time.FixedZone(formatTZOffset(offset), offset)
+		}
+	}
+
+	layouts := d.layouts
+	if *detected != "" {
+		layouts = append([]string{*detected}, layouts...)
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, dateStr, loc); err == nil {
+			*detected = layout
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format: %s", value)
+}
+
+// parseDay парсит день разделителя дат: "21 November 2024", "21.11.2024". Разделители без смещения, день начинается в loc.
+// detected — формат разделителя, найденный в файле ранее, как в parse.
+func (d dateFormats) parseDay(value string, detected *string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	layouts := d.dayLayouts
+	if *detected != "" {
+		layouts = append([]string{*detected}, layouts...)
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, d.loc); err == nil {
+			*detected = layout
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown day format: %s", value)
+}
+
+// parseTimezone парсит часовой пояс любого из форматов tzFormats и возвращает смещение в секундах
+func parseTimezone(tzStr string) (int, error) {
+	for _, re := range tzFormats {
+		if matches := re.FindStringSubmatch(tzStr); matches != nil {
+			return offsetFromMatches(tzStr, matches)
+		}
+	}
+	return 0, fmt.Errorf("invalid timezone format: %s", tzStr)
+}
+
+// offsetFromMatches переводит группы знака, часов и минут в секунды, без групп смещение нулевое
+func offsetFromMatches(tzStr string, matches []string) (int, error) {
+	if len(matches) < 3 {
+		return 0, nil
+	}
+	sign := matches[1]
+	hours, _ := strconv.Atoi(matches[2])
+	minutes := 0
+	if len(matches) > 3 && matches[3] != "" {
+		minutes, _ = strconv.Atoi(matches[3])
+	}
+
+	// Проверяем диапазон значений
+	if hours > 14 || (hours == 14 && minutes > 0) || minutes >= 60 {
+		return 0, fmt.Errorf("invalid timezone values: %s", tzStr)
+	}
+
+	totalSeconds := hours*3600 + minutes*60
+	if sign == "-" {
+		totalSeconds = -totalSeconds
+	}
+	return totalSeconds, nil
+}
+
+// normalizeTime returns the time in UTC and its original offset in seconds
+func normalizeTime(t time.Time) (time.Time, int) {
+	_, offset := t.Zone()
+	return t.UTC(), offset
+}
+
+// normalizeTimePtr returns the time in UTC, nil stays nil
+func normalizeTimePtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,84 @@
+package tg
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// entityKind is a kind of text entity of a message
+type entityKind int
+
+const (
+	entityUnknown entityKind = iota
+	entityHashtag
+	entityMention
+	entityCashtag
+	entityURL
+	entityBotCommand
+)
+
+// classifyHTMLLink определяет тип ссылки из div.text HTML-экспорта и возвращает значение без префикса (#, @, $, /)
+func classifyHTMLLink(a *goquery.Selection) (entityKind, string) {
+	text := strings.TrimSpace(a.Text())
+	onclick, _ := a.Attr("onclick")
+	href, _ := a.Attr("href")
+
+	switch {
+	case strings.Contains(onclick, "ShowHashtag("):
+		return entityHashtag, strings.TrimPrefix(text, "#")
+	case strings.Contains(onclick, "ShowCashtag("):
+		return entityCashtag, strings.TrimPrefix(text, "$")
+	case strings.Contains(onclick, "ShowBotCommand("):
+		return entityBotCommand, strings.TrimPrefix(text, "/")
+	case strings.Contains(onclick, "ShowMentionName("):
+		return entityMention, text
+	case strings.HasPrefix(text, "@"):
+		return entityMention, strings.TrimPrefix(text, "@")
+	case href != "":
+		return entityURL, href
+	}
+	return entityUnknown, ""
+}
+
+// classifyJSONEntity определяет тип элемента text_entities JSON-экспорта
+func classifyJSONEntity(e jsonTextEntity) (entityKind, string) {
+	switch e.Type {
+	case "hashtag":
+		return entityHashtag, strings.TrimPrefix(e.Text, "#")
+	case "cashtag":
+		return entityCashtag, strings.TrimPrefix(e.Text, "$")
+	case "bot_command":
+		return entityBotCommand, strings.TrimPrefix(e.Text, "/")
+	case "mention":
+		return entityMention, strings.TrimPrefix(e.Text, "@")
+	case "mention_name":
+		return entityMention, e.Text
+	case "link":
+		return entityURL, e.Text
+	case "text_link":
+		return entityURL, e.Href
+	}
+	return entityUnknown, ""
+}
+
+// addEntity кладёт значение в список сообщения, соответствующий типу сущности
+func addEntity(msg *models.Message, kind entityKind, value string) {
+	if value == "" {
+		return
+	}
+	switch kind {
+	case entityHashtag:
+		msg.Tags = append(msg.Tags, value)
+	case entityMention:
+		msg.Mentions = append(msg.Mentions, value)
+	case entityCashtag:
+		msg.Cashtags = append(msg.Cashtags, value)
+	case entityURL:
+		msg.URLs = append(msg.URLs, value)
+	case entityBotCommand:
+		msg.BotCommands = append(msg.BotCommands, value)
+	}
+}
@@ -0,0 +1,210 @@
+package tg
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// TgArchivedJSONParser parses Telegram Desktop machine-readable exports (result.json)
+type TgArchivedJSONParser struct {
+	archivedParser
+}
+
+// jsonMessage is an element of the "messages" array of result.json
+type jsonMessage struct {
+	ID            int64            `json:"id"`
+	Type          string           `json:"type"`
+	Date          string           `json:"date"`
+	DateUnixtime  string           `json:"date_unixtime"`
+	Edited        string           `json:"edited"`
+	EditedUnix    string           `json:"edited_unixtime"`
+	From          string           `json:"from"`
+	Photo         string           `json:"photo"`
+	PhotoFileSize int64            `json:"photo_file_size"`
+	File          string           `json:"file"`
+	FileSize      int64            `json:"file_size"`
+	MediaType     string           `json:"media_type"`
+	TextEntities  []jsonTextEntity `json:"text_entities"`
+
+	ReplyToMessageID int64          `json:"reply_to_message_id"`
+	ForwardedFrom    string         `json:"forwarded_from"`
+	Reactions        []jsonReaction `json:"reactions"`
+
+	// service messages
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Title     string `json:"title"`
+	MessageID int64  `json:"message_id"`
+}
+
+// jsonReaction is a reaction counter, custom emoji reactions have no "emoji"
+type jsonReaction struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	Emoji string `json:"emoji"`
+}
+
+// jsonTextEntity is a typed piece of the message text
+type jsonTextEntity struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Href string `json:"href,omitempty"`
+}
+
+func NewTgArchivedJSONParser(log *slog.Logger, conf *config.SystemConfig) *TgArchivedJSONParser {
+	return &TgArchivedJSONParser{newArchivedParser(log, conf)}
+}
+
+// ParseFile reads result.json token by token, so that only one message is decoded in memory at a time
+func (p *TgArchivedJSONParser) ParseFile(file fs.ExportFile, messagesChan chan<- models.Message) (*ParseReport, error) {
+	report := NewParseReport(file.Name)
+	f, err := file.Open()
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	// Telegram writes the channel "name" and "id" before "messages"
+	var channelTitle string
+	var channelID int64
+
+	dec := json.NewDecoder(f)
+	if err := expectDelim(dec, '{'); err != nil {
+		return report, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return report, err
+		}
+		switch key {
+		case "name":
+			if err := dec.Decode(&channelTitle); err != nil {
+				return report, err
+			}
+			continue
+		case "id":
+			if err := dec.Decode(&channelID); err != nil {
+				return report, err
+			}
+			continue
+		case "messages":
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		if err := p.checkChannel(file.Name, channelID); err != nil {
+			return report, err
+		}
+		group := p.obtainGroup(file.Name, channelTitle, channelID)
+		if err := expectDelim(dec, '['); err != nil {
+			return report, err
+		}
+		for dec.More() {
+			var jm jsonMessage
+			if err := dec.Decode(&jm); err != nil {
+				return report, err
+			}
+			if jm.Type == "service" {
+				if event, ok := p.toServiceEvent(jm, group); ok {
+					report.Events = append(report.Events, event)
+				}
+				continue
+			}
+			if msg, ok := p.toMessage(jm, group, report); ok {
+				messagesChan <- msg
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (p *TgArchivedJSONParser) toMessage(jm jsonMessage, group exportGroup, report *ParseReport) (models.Message, bool) {
+	// other types are not skipped messages
+	if jm.Type != "message" {
+		return models.Message{}, false
+	}
+
+	// the same message ID as in HTML exports, so both formats of one group produce the same UUID
+	id := "message" + strconv.FormatInt(jm.ID, 10)
+	datetime, err := parseJSONDate(jm.Date, jm.DateUnixtime, p.dates.loc)
+	if err != nil {
+		report.Skip(id, "date", err.Error())
+		return models.Message{}, false
+	}
+	datetime, tzOffset := normalizeTime(datetime)
+
+	msg := models.Message{
+		UUID:       p.obtainUUID(id, group.id),
+		MessageID:  id,
+		Datetime:   datetime,
+		TZOffset:   tzOffset,
+		Group:      group.id,
+		GroupTitle: group.title,
+		ChannelID:  group.channelID,
+		From:       jm.From,
+		Media:      extractJSONMedia(jm),
+	}
+	if jm.Edited != "" {
+		if edited, err := parseJSONDate(jm.Edited, jm.EditedUnix, p.dates.loc); err == nil {
+			msg.Edited = normalizeTimePtr(&edited)
+		}
+	}
+	var text strings.Builder
+	for _, e := range jm.TextEntities {
+		text.WriteString(e.Text)
+		kind, value := classifyJSONEntity(e)
+		addEntity(&msg, kind, value)
+	}
+	msg.Text = strings.TrimSpace(text.String())
+	extractJSONRelations(jm, &msg)
+	report.Parsed++
+	return msg, true
+}
+
+// parseJSONDate restores the time zone of the exporting client:
+// "date" is the local wall clock, "date_unixtime" is the absolute moment, their difference is the offset.
+// Old exports have no "date_unixtime", their dates are in loc.
+func parseJSONDate(date, unixtime string, loc *time.Location) (time.Time, error) {
+	// "2024-11-21T19:20:37"
+	if unixtime == "" {
+		return time.ParseInLocation("2006-01-02T15:04:05", date, loc)
+	}
+	local, err := time.Parse("2006-01-02T15:04:05", date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, err := strconv.ParseInt(unixtime, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset := int(local.Unix() - sec)
+	return time.Unix(sec, 0).In(time.FixedZone(formatTZOffset(offset), offset)), nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %q, got %v", delim, t)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package tg
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+func TestTgArchivedJSONParser_ParseFile(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedJSONParser(logger, &config.SystemConfig{})
+	testFile := "testdata/result.json"
+
+	messagesChan := make(chan models.Message, 10)
+	report, err := parser.ParseFile(fs.NewExportFile(testFile), messagesChan)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(messagesChan), "Ожидается 2 сообщения, сервисные сообщения пропускаются")
+	assert.Equal(t, 2, report.Parsed)
+	assert.Empty(t, report.Skipped, "Сервисные сообщения не считаются пропущенными")
+
+	fixedZone := time.FixedZone("UTC+03:00", 3*60*60)
+
+	msg2203 := <-messagesChan
+	assert.Equal(t, "message2203", msg2203.MessageID)
+	assert.Equal(t, "channel1234567890", msg2203.Group, "Группа JSON-экспорта выводится из ID канала")
+	assert.Equal(t, "Channel Title", msg2203.GroupTitle)
+	assert.Equal(t, int64(1234567890), msg2203.ChannelID)
+	assert.Equal(t, expectedUUID("message2203", "channel1234567890"), msg2203.UUID)
+	assert.Equal(t, time.Date(2024, time.November, 21, 19, 20, 37, 0, fixedZone).UTC(), msg2203.Datetime)
+	assert.Equal(t, 3*60*60, msg2203.TZOffset)
+	assert.Equal(t, []string{"shy", "booba"}, msg2203.Tags)
+	assert.Equal(t, "Channel Title", msg2203.From)
+	assert.Equal(t, "#shy #booba", msg2203.Text)
+	assert.Equal(t, []models.Media{{Kind: models.MediaKindVideo, Title: "Animation", Size: 239104, Included: false}}, msg2203.Media)
+
+	msg3217 := <-messagesChan
+	assert.Equal(t, "message3217", msg3217.MessageID)
+	assert.Equal(t, time.Date(2025, time.January, 29, 11, 52, 44, 0, fixedZone).UTC(), msg3217.Datetime)
+	assert.Equal(t, []string{"where"}, msg3217.Tags, "Ссылки и упоминания не являются тегами")
+	assert.Equal(t, []string{"https://example.com", "https://example.com/hidden"}, msg3217.URLs)
+	assert.Equal(t, []string{"someone"}, msg3217.Mentions)
+	assert.Equal(t, []string{"BTC"}, msg3217.Cashtags)
+	assert.Equal(t, []string{"start"}, msg3217.BotCommands)
+	assert.Equal(t, "see https://example.com #where @someone this $BTC /start", msg3217.Text)
+	assert.Equal(t, []models.Media{{Kind: models.MediaKindPhoto, Title: "Photo", Size: 51200, Included: true}}, msg3217.Media)
+	assert.Equal(t, "message2203", msg3217.ReplyTo)
+	assert.Equal(t, &models.Forward{From: "Original Channel"}, msg3217.Forward)
+	assert.Equal(t, map[string]int{"👍": 5}, msg3217.Reactions)
+	assert.Empty(t, msg2203.ReplyTo)
+	assert.Nil(t, msg2203.Forward)
+	assert.Nil(t, msg2203.Edited)
+	require.NotNil(t, msg3217.Edited)
+	assert.Equal(t, time.Date(2025, time.January, 29, 12, 0, 0, 0, fixedZone).UTC(), *msg3217.Edited)
+
+	require.Len(t, report.Events, 2)
+	assert.Equal(t, models.ServiceEvent{
+		UUID: expectedUUID("message2202", "channel1234567890"), MessageID: "message2202",
+		Group: "channel1234567890", GroupTitle: "Channel Title", ChannelID: 1234567890,
+		Type: models.ServiceEventPin, Datetime: time.Date(2024, time.November, 21, 19, 20, 0, 0, fixedZone).UTC(),
+		Actor: "Channel Title", Action: "pin_message", PinnedMessageID: "message2199",
+	}, report.Events[0])
+	assert.Equal(t, models.ServiceEvent{
+		UUID: expectedUUID("message3218", "channel1234567890"), MessageID: "message3218",
+		Group: "channel1234567890", GroupTitle: "Channel Title", ChannelID: 1234567890,
+		Type: models.ServiceEventTitle, Datetime: time.Date(2025, time.January, 30, 9, 0, 0, 0, fixedZone).UTC(),
+		Actor: "Channel Title", Action: "edit_group_title", Title: "New Title",
+	}, report.Events[1])
+}
+
+func TestTgArchivedJSONParser_ParseFile_InvalidJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedJSONParser(logger, &config.SystemConfig{})
+
+	messagesChan := make(chan models.Message, 10)
+	_, err := parser.ParseFile(fs.NewExportFile("testdata/test.html"), messagesChan)
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(messagesChan))
+}
+
+func TestTgArchivedJSONParser_ParseFile_Report(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedJSONParser(logger, &config.SystemConfig{})
+
+	file := filepath.Join(t.TempDir(), "result.json")
+	err := os.WriteFile(file, []byte(`{"name": "Channel", "id": 1, "messages": [
+		{"id": 1, "type": "message", "date": "2024-11-21T19:20:37", "date_unixtime": "1732206037", "text_entities": []},
+		{"id": 2, "type": "message", "date": "21.11.2024 19:20:37", "text_entities": []}
+	]}`), 0644)
+	require.NoError(t, err)
+
+	messagesChan := make(chan models.Message, 10)
+	report, err := parser.ParseFile(fs.NewExportFile(file), messagesChan)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(messagesChan))
+	assert.Equal(t, 1, report.Parsed)
+	require.Len(t, report.Skipped, 1)
+	assert.Equal(t, "message2", report.Skipped[0].MessageID)
+	assert.Equal(t, "date", report.Skipped[0].Selector)
+}
+
+func TestParseJSONDate(t *testing.T) {
+	dt, err := parseJSONDate("2024-11-21T19:20:37", "1732206037", time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, "UTC+03:00", dt.Location().String())
+	assert.Equal(t, int64(1732206037), dt.Unix())
+
+	dt, err = parseJSONDate("2024-11-21T19:20:37", "", time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.November, 21, 19, 20, 37, 0, time.UTC), dt)
+
+	// дата без date_unixtime — в часовом поясе из конфига
+	loc := time.FixedZone("MSK", 3*3600)
+	dt, err = parseJSONDate("2024-11-21T19:20:37", "", loc)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1732206037), dt.Unix())
+
+	_, err = parseJSONDate("21.11.2024 19:20:37", "1732206037", time.UTC)
+	assert.Error(t, err)
+}
+
+func TestFormatTZOffset(t *testing.T) {
+	assert.Equal(t, "UTC+03:00", formatTZOffset(3*3600))
+	assert.Equal(t, "UTC-05:30", formatTZOffset(-5*3600-30*60))
+	assert.Equal(t, "UTC+00:00", formatTZOffset(0))
+}
+
+func expectedUUID(messageID, group string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(messageID+group)).String()
+}
@@ -0,0 +1,121 @@
+package tg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+var (
+	sizeRe = regexp.MustCompile(`([\d.]+)\s*(B|KB|MB|GB)\b`)
+
+	sizeUnits = map[string]float64{
+		"B":  1,
+		"KB": 1 << 10,
+		"MB": 1 << 20,
+		"GB": 1 << 30,
+	}
+
+	// jsonMediaTypes сопоставляет media_type JSON-экспорта с видом и заголовком, которые показывает HTML-экспорт
+	jsonMediaTypes = map[string]models.Media{
+		"animation":     {Kind: models.MediaKindVideo, Title: "Animation"},
+		"video_file":    {Kind: models.MediaKindVideo, Title: "Video"},
+		"video_message": {Kind: models.MediaKindVideo, Title: "Video message"},
+		"voice_message": {Kind: models.MediaKindVoiceMessage, Title: "Voice message"},
+		"audio_file":    {Kind: models.MediaKindAudioFile, Title: "Audio file"},
+		"sticker":       {Kind: models.MediaKindSticker, Title: "Sticker"},
+	}
+)
+
+// extractHTMLMedia разбирает блоки div.media_wrap сообщения
+func extractHTMLMedia(s *goquery.Selection) []models.Media {
+	var result []models.Media
+	s.Find("div.media_wrap").Each(func(i int, w *goquery.Selection) {
+		// включённые в экспорт фото и видео показываются превью без div.media
+		w.Find("a.photo_wrap").Each(func(i int, a *goquery.Selection) {
+			result = append(result, models.Media{Kind: models.MediaKindPhoto, Title: "Photo", Included: true})
+		})
+		w.Find("a.video_file_wrap").Each(func(i int, a *goquery.Selection) {
+			result = append(result, models.Media{Kind: models.MediaKindVideo, Title: "Video", Included: true})
+		})
+		w.Find("a.animated_wrap").Each(func(i int, a *goquery.Selection) {
+			result = append(result, models.Media{Kind: models.MediaKindVideo, Title: "Animation", Included: true})
+		})
+		w.Find("a.sticker_wrap").Each(func(i int, a *goquery.Selection) {
+			result = append(result, models.Media{Kind: models.MediaKindSticker, Title: "Sticker", Included: true})
+		})
+
+		w.Find(".media").Each(func(i int, m *goquery.Selection) {
+			description := strings.TrimSpace(m.Find(".description").Text())
+			title := strings.TrimSpace(m.Find(".title").Text())
+			kind := htmlMediaKind(m)
+			// не включённый в экспорт стикер показывается как media_photo
+			if title == "Sticker" {
+				kind = models.MediaKindSticker
+			}
+			result = append(result, models.Media{
+				Kind:     kind,
+				Title:    title,
+				Size:     parseSize(m.Find(".status").Text()),
+				Included: isIncluded(description),
+			})
+		})
+	})
+	return result
+}
+
+// extractJSONMedia собирает вложение из полей photo/file JSON-экспорта
+func extractJSONMedia(jm jsonMessage) []models.Media {
+	if jm.Photo != "" {
+		return []models.Media{{
+			Kind:     models.MediaKindPhoto,
+			Title:    "Photo",
+			Size:     jm.PhotoFileSize,
+			Included: isIncluded(jm.Photo),
+		}}
+	}
+	if jm.File != "" {
+		media, ok := jsonMediaTypes[jm.MediaType]
+		if !ok {
+			media = models.Media{Kind: models.MediaKindFile, Title: "File"}
+		}
+		media.Size = jm.FileSize
+		media.Included = isIncluded(jm.File)
+		return []models.Media{media}
+	}
+	return nil
+}
+
+// htmlMediaKind возвращает вид вложения по классу media_*: "media_video" -> "video"
+func htmlMediaKind(m *goquery.Selection) string {
+	class, _ := m.Attr("class")
+	for _, c := range strings.Fields(class) {
+		if kind, ok := strings.CutPrefix(c, "media_"); ok {
+			return kind
+		}
+	}
+	return models.MediaKindFile
+}
+
+// isIncluded проверяет, что файл попал в экспорт: иначе Telegram пишет "Not included..." или "File not included..."
+func isIncluded(description string) bool {
+	d := strings.ToLower(description)
+	return !strings.Contains(d, "not included") && !strings.Contains(d, "exceeds maximum size")
+}
+
+// parseSize переводит размер вида "233.5 KB" (или "00:05, 1.2 MB") в байты
+func parseSize(status string) int64 {
+	matches := sizeRe.FindStringSubmatch(status)
+	if matches == nil {
+		return 0
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(value * sizeUnits[matches[2]])
+}
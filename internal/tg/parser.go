@@ -1,141 +1,314 @@
 package tg
 
 import (
+	"cmp"
 	"fmt"
 	"log/slog"
-	"os"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/google/uuid"
+	"golang.org/x/net/html"
 
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
 	"github.com/meesooqa/tgtag/pkg/models"
 )
 
 type Parser interface {
-	ParseFile(filename string, messagesChan chan<- models.Message) error
+	// ParseFile sends messages of the file to messagesChan and reports the skipped ones
+	ParseFile(file fs.ExportFile, messagesChan chan<- models.Message) (*ParseReport, error)
 }
 
-type TgArchivedHTMLParser struct {
+// archivedParser holds what every Telegram export parser shares: where the data lives and how groups and UUIDs are derived
+type archivedParser struct {
 	log     *slog.Logger
 	baseDir string
+	groups  []config.GroupConfig
+	dates   dateFormats
 }
 
-func NewTgArchivedHTMLParser(log *slog.Logger, baseDir string) *TgArchivedHTMLParser {
-	return &TgArchivedHTMLParser{
+type TgArchivedHTMLParser struct {
+	archivedParser
+}
+
+func NewTgArchivedHTMLParser(log *slog.Logger, conf *config.SystemConfig) *TgArchivedHTMLParser {
+	return &TgArchivedHTMLParser{newArchivedParser(log, conf)}
+}
+
+func newArchivedParser(log *slog.Logger, conf *config.SystemConfig) archivedParser {
+	return archivedParser{
 		log:     log,
-		baseDir: baseDir,
+		baseDir: conf.DataPath,
+		groups:  conf.Groups,
+		dates:   newDateFormats(log, conf.Dates),
 	}
 }
 
-func (p *TgArchivedHTMLParser) ParseFile(filename string, messagesChan chan<- models.Message) error {
-	file, err := os.Open(filename)
+func (p *TgArchivedHTMLParser) ParseFile(file fs.ExportFile, messagesChan chan<- models.Message) (*ParseReport, error) {
+	report := NewParseReport(file.Name)
+	f, err := file.Open()
 	if err != nil {
-		return err
+		return report, err
 	}
-	defer file.Close()
+	defer f.Close()
 
-	doc, err := goquery.NewDocumentFromReader(file)
+	doc, err := goquery.NewDocumentFromReader(f)
 	if err != nil {
-		return err
+		return report, err
 	}
 
-	group := p.obtainGroup(filename)
+	page := p.newHTMLPage(file.Name, htmlPageTitle(doc.Find("div.page_header")), report)
+	doc.Find("div.message").Each(func(i int, s *goquery.Selection) {
+		p.handleHTMLMessage(s, page, messagesChan)
+	})
 
-	doc.Find("div.message.default").Each(func(i int, s *goquery.Selection) {
-		id, exists := s.Attr("id")
-		if !exists {
-			return
-		}
+	return report, nil
+}
 
-		dateStr, exists := s.Find("div.pull_right.date.details").Attr("title")
-		if !exists {
-			return
-		}
-		// "21.11.2024 19:20:37 UTC+03:00"
-		parts := strings.Split(dateStr, " ")
-		if len(parts) < 3 {
-			return
-		}
+// htmlPage is the state carried from message to message of one HTML page
+type htmlPage struct {
+	group exportGroup
+	// joined messages have no div.from_name, Telegram shows them under the previous sender
+	lastFrom string
+	// service messages have no date, the date of the last separator or message is used
+	lastDate time.Time
+	// dateLayout and dayLayout are the date and the date separator layouts detected in the file
+	dateLayout string
+	dayLayout  string
+	report     *ParseReport
+}
 
-		dateTimeStr := parts[0] + " " + parts[1] // "21.11.2024 19:20:37"
-		parsedTime, err := time.Parse("02.01.2006 15:04:05", dateTimeStr)
-		if err != nil {
-			return
-		}
+func (p *archivedParser) newHTMLPage(filename, title string, report *ParseReport) *htmlPage {
+	// HTML-экспорт не содержит ID канала, только его название
+	return &htmlPage{group: p.obtainGroup(filename, title, 0), report: report}
+}
+
+// htmlPageTitle возвращает название канала из div.page_header
+func htmlPageTitle(header *goquery.Selection) string {
+	return strings.TrimSpace(header.Find(".text.bold").First().Text())
+}
 
-		tzStr := parts[2] // "UTC+03:00"
-		offset, err := parseTZOffset(tzStr)
-		if err != nil {
-			return
+// handleHTMLMessage отправляет сообщение в messagesChan, а сервисное сообщение добавляет в отчёт
+func (p *archivedParser) handleHTMLMessage(s *goquery.Selection, page *htmlPage, messagesChan chan<- models.Message) {
+	switch {
+	case s.HasClass("service"):
+		if event, ok := p.parseHTMLService(s, page); ok {
+			page.report.Events = append(page.report.Events, event)
 		}
-		loc := time.FixedZone(tzStr, offset)
-
-		datetime := time.Date(
-			parsedTime.Year(), parsedTime.Month(), parsedTime.Day(),
-			parsedTime.Hour(), parsedTime.Minute(), parsedTime.Second(),
-			0, loc,
-		)
-
-		var tags []string
-		s.Find("div.text a").Each(func(i int, a *goquery.Selection) {
-			text := strings.TrimPrefix(a.Text(), "#")
-			tags = append(tags, text)
-		})
-
-		messagesChan <- models.Message{
-			UUID:      p.obtainUUID(id, group),
-			MessageID: id,
-			Datetime:  datetime,
-			Group:     group,
-			Tags:      tags,
+	case s.HasClass("default"):
+		if msg, ok := p.parseHTMLMessage(s, page); ok {
+			messagesChan <- msg
 		}
+	}
+}
+
+// parseHTMLMessage разбирает div.message.default, общий для DOM- и потокового парсеров
+func (p *archivedParser) parseHTMLMessage(s *goquery.Selection, page *htmlPage) (models.Message, bool) {
+	id, exists := s.Attr("id")
+	if !exists {
+		page.report.Skip("", "div.message.default[id]", "no message id")
+		return models.Message{}, false
+	}
+
+	body := s.ChildrenFiltered("div.body")
+	from := page.lastFrom
+	if !s.HasClass("joined") {
+		from = ownText(body.ChildrenFiltered("div.from_name"))
+		page.lastFrom = from
+	}
+
+	const dateSelector = "div.pull_right.date.details[title]"
+	dateStr, exists := s.Find("div.pull_right.date.details").First().Attr("title")
+	if !exists {
+		page.report.Skip(id, dateSelector, "no date title")
+		return models.Message{}, false
+	}
+	// "21.11.2024 19:20:37 UTC+03:00\nEdited: 21.11.2024 20:00:00 UTC+03:00"
+	dateStr, editedStr, _ := strings.Cut(dateStr, "\n")
+	datetime, err := p.dates.parse(dateStr, &page.dateLayout)
+	if err != nil {
+		page.report.Skip(id, dateSelector, err.Error())
+		return models.Message{}, false
+	}
+	datetime, tzOffset := normalizeTime(datetime)
+	page.lastDate = datetime
+
+	msg := models.Message{
+		UUID:       p.obtainUUID(id, page.group.id),
+		MessageID:  id,
+		Datetime:   datetime,
+		TZOffset:   tzOffset,
+		Group:      page.group.id,
+		GroupTitle: page.group.title,
+		ChannelID:  page.group.channelID,
+		Edited:     p.parseHTMLEdited(id, editedStr, page),
+		From:       from,
+		Media:      extractHTMLMedia(s),
+		ReplyTo:    extractHTMLReplyTo(body),
+		Forward:    p.extractHTMLForward(body, page),
+		Reactions:  extractHTMLReactions(s),
+	}
+	// текст пересланного сообщения лежит внутри div.forwarded.body
+	msg.Text = extractText(body.Find("div.text").First())
+	s.Find("div.text a").Each(func(i int, a *goquery.Selection) {
+		kind, value := classifyHTMLLink(a)
+		addEntity(&msg, kind, value)
 	})
+	page.report.Parsed++
+	return msg, true
+}
 
-	return nil
+// parseHTMLEdited парсит время редактирования из второй строки title: "Edited: 21.11.2024 20:00:00 UTC+03:00"
+func (p *archivedParser) parseHTMLEdited(id, editedStr string, page *htmlPage) *time.Time {
+	editedStr, found := strings.CutPrefix(strings.TrimSpace(editedStr), "Edited: ")
+	if !found {
+		return nil
+	}
+	edited, err := p.dates.parse(editedStr, &page.dateLayout)
+	if err != nil {
+		p.log.Debug("invalid edited date", "messageID", id, "err", err)
+		return nil
+	}
+	return normalizeTimePtr(&edited)
 }
 
-func (p *TgArchivedHTMLParser) obtainUUID(messageId, group string) string {
+func (p *archivedParser) obtainUUID(messageId, group string) string {
 	input := messageId + group
 
 	namespace := uuid.NameSpaceURL
 	return uuid.NewSHA1(namespace, []byte(input)).String()
 }
 
-func (p *TgArchivedHTMLParser) obtainGroup(path string) string {
-	return p.extractFirstSubfolder(path, p.baseDir)
+// exportGroup is the group of an export file
+type exportGroup struct {
+	id    string
+	title string
+	// channelID is 0 if the export has no channel ID and the config has none for the group
+	channelID int64
 }
 
-// parseTZOffset парсит строку часового пояса формата "UTC±HH:MM" и возвращает смещение в секундах.
-func parseTZOffset(offsetStr string) (int, error) {
-	re := regexp.MustCompile(`^UTC([+-])(\d{2}):(\d{2})$`)
-	matches := re.FindStringSubmatch(offsetStr)
-	if matches == nil {
-		return 0, fmt.Errorf("invalid timezone format: %s", offsetStr)
+// obtainGroup возвращает стабильный ID группы, её название и ID канала, channelID есть только у JSON-экспорта.
+// ID берётся из конфига: группа с groups[].channel_id канала, затем группа с папкой в groups[].folders.
+// Иначе ID выводится из ID канала ("channel123"), а без него (HTML-экспорт) это имя папки или архива без расширения.
+// Название берётся из конфига, затем из экспорта, иначе совпадает с ID.
+func (p *archivedParser) obtainGroup(path string, exportTitle string, channelID int64) exportGroup {
+	g := p.groupOfChannel(channelID)
+	if g == nil {
+		g = p.groupOfFolder(p.exportFolder(path))
 	}
+	if g != nil {
+		return exportGroup{id: g.ID, title: cmp.Or(g.Title, exportTitle, g.ID), channelID: cmp.Or(channelID, g.ChannelID)}
+	}
+	id := p.exportFolder(path)
+	if channelID != 0 {
+		id = channelGroupID(channelID)
+	}
+	return exportGroup{id: id, title: cmp.Or(exportTitle, id), channelID: channelID}
+}
+
+// channelGroupID возвращает ID группы канала, которого нет в конфиге
+func channelGroupID(channelID int64) string {
+	return "channel" + strconv.FormatInt(channelID, 10)
+}
+
+// checkChannel проверяет, что канал JSON-экспорта не противоречит конфигу: папка экспорта
+// не принадлежит группе другого канала или другой группе с groups[].channel_id этого канала
+func (p *archivedParser) checkChannel(path string, channelID int64) error {
+	if channelID == 0 {
+		return nil
+	}
+	folder := p.exportFolder(path)
+	byFolder := p.groupOfFolder(folder)
+	if byFolder == nil {
+		return nil
+	}
+	if byFolder.ChannelID != 0 && byFolder.ChannelID != channelID {
+		return fmt.Errorf("folder %q of group %q (channel %d) has an export of channel %d", folder, byFolder.ID, byFolder.ChannelID, channelID)
+	}
+	if byChannel := p.groupOfChannel(channelID); byChannel != nil && byChannel.ID != byFolder.ID {
+		return fmt.Errorf("channel %d of group %q is exported to folder %q of group %q", channelID, byChannel.ID, folder, byFolder.ID)
+	}
+	return nil
+}
+
+// groupOfChannel возвращает группу конфига с groups[].channel_id канала, nil если её нет
+func (p *archivedParser) groupOfChannel(channelID int64) *config.GroupConfig {
+	if channelID == 0 {
+		return nil
+	}
+	for i := range p.groups {
+		if p.groups[i].ChannelID == channelID {
+			return &p.groups[i]
+		}
+	}
+	return nil
+}
+
+// groupOfFolder возвращает группу конфига с папкой в groups[].folders, nil если её нет
+func (p *archivedParser) groupOfFolder(folder string) *config.GroupConfig {
+	for i := range p.groups {
+		if slices.Contains(p.groups[i].Folders, folder) {
+			return &p.groups[i]
+		}
+	}
+	return nil
+}
 
-	sign := matches[1]
-	hours, _ := strconv.Atoi(matches[2])
-	minutes, _ := strconv.Atoi(matches[3])
+// exportFolder возвращает папку экспорта в data_path, у архива — его имя без расширения
+func (p *archivedParser) exportFolder(path string) string {
+	return fs.TrimArchiveExt(p.extractFirstSubfolder(path, p.baseDir))
+}
 
-	// Проверяем диапазон значений
-	if hours > 14 || (hours == 14 && minutes > 0) || minutes >= 60 {
-		return 0, fmt.Errorf("invalid timezone values: %s", offsetStr)
+// ownText возвращает текст элемента без вложенных элементов: "Name<span class="details"> via @bot</span>" -> "Name"
+func ownText(s *goquery.Selection) string {
+	var sb strings.Builder
+	for _, n := range s.Nodes {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				sb.WriteString(c.Data)
+			}
+		}
 	}
+	return strings.TrimSpace(sb.String())
+}
 
-	totalSeconds := hours*3600 + minutes*60
-	if sign == "-" {
-		totalSeconds = -totalSeconds
+// extractText возвращает текст сообщения как его видит читатель: ссылки и хэштеги остаются на своих местах, <br> становится переводом строки
+func extractText(s *goquery.Selection) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			sb.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			sb.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range s.Nodes {
+		walk(n)
 	}
+	return strings.TrimSpace(sb.String())
+}
 
-	return totalSeconds, nil
+// formatTZOffset формирует строку часового пояса формата "UTC±HH:MM" по смещению в секундах (обратно parseTimezone).
+func formatTZOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, offset/3600, offset%3600/60)
 }
 
 // extractFirstSubfolder возвращает первую подпапку, следующую за каталогом base
-func (p *TgArchivedHTMLParser) extractFirstSubfolder(path string, base string) string {
+func (p *archivedParser) extractFirstSubfolder(path string, base string) string {
 	// Нормализуем базовый путь, удаляя возможные ведущие и завершающие слэши.
 	base = strings.Trim(base, "/")
 	baseParts := strings.Split(base, "/")
@@ -3,23 +3,27 @@ package tg
 import (
 	"bytes"
 	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
 	"github.com/meesooqa/tgtag/pkg/models"
 )
 
 func TestTgArchivedHTMLParser_ParseFile(t *testing.T) {
 	var buf bytes.Buffer
 	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
-	parser := NewTgArchivedHTMLParser(logger, "")
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
 	testFile := "testdata/test.html"
 
 	messagesChan := make(chan models.Message, 10)
-	err := parser.ParseFile(testFile, messagesChan)
+	_, err := parser.ParseFile(fs.NewExportFile(testFile), messagesChan)
 	require.NoError(t, err)
 
 	require.Equal(t, 3, len(messagesChan), "Ожидается, что будет 3 сообщения, полученных из HTML")
@@ -48,20 +52,132 @@ func TestTgArchivedHTMLParser_ParseFile(t *testing.T) {
 
 	fixedZone := time.FixedZone("UTC+03:00", 3*60*60)
 
-	expectedTime2203 := time.Date(2024, time.November, 21, 19, 20, 37, 0, fixedZone)
+	expectedTime2203 := time.Date(2024, time.November, 21, 19, 20, 37, 0, fixedZone).UTC()
 	assert.Equal(t, expectedTime2203, msg2203.Datetime, "Некорректная дата для message2203")
+	assert.Equal(t, 3*60*60, msg2203.TZOffset, "Исходное смещение сохраняется отдельно от даты в UTC")
 	assert.ElementsMatch(t, []string{"shy", "booba"}, msg2203.Tags, "Некорректные теги для message2203")
+	assert.Equal(t, "Channel Title", msg2203.From)
+	assert.Equal(t, "Channel Title", msg2203.GroupTitle)
+	assert.Equal(t, "#shy #booba", msg2203.Text)
+	assert.Equal(t, []models.Media{{Kind: models.MediaKindVideo, Title: "Animation", Size: 239104, Included: false}}, msg2203.Media)
 
-	expectedTime2204 := time.Date(2024, time.November, 21, 19, 20, 37, 0, fixedZone)
+	expectedTime2204 := time.Date(2024, time.November, 21, 19, 20, 37, 0, fixedZone).UTC()
 	assert.Equal(t, expectedTime2204, msg2204.Datetime, "Некорректная дата для message2204")
 	assert.ElementsMatch(t, []string{"shy", "stare", "todo", "ginger"}, msg2204.Tags, "Некорректные теги для message2204")
+	assert.Equal(t, "Channel Title", msg2204.From, "joined-сообщение наследует отправителя")
+	assert.Equal(t, []models.Media{{Kind: models.MediaKindVideo, Title: "Animation", Size: 473190, Included: false}}, msg2204.Media)
 
-	expectedTime3217 := time.Date(2025, time.January, 29, 11, 52, 44, 0, fixedZone)
+	expectedTime3217 := time.Date(2025, time.January, 29, 11, 52, 44, 0, fixedZone).UTC()
 	assert.Equal(t, expectedTime3217, msg3217.Datetime, "Некорректная дата для message3217")
 	assert.ElementsMatch(t, []string{"where", "booba", "slontar4"}, msg3217.Tags, "Некорректные теги для message3217")
 }
 
-func TestParseTZOffset(t *testing.T) {
+func TestObtainGroup(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{
+		DataPath: "var/data",
+		Groups: []config.GroupConfig{
+			{ID: "booba", Title: "Booba", Folders: []string{"booba_2024", "booba_2025"}},
+			{ID: "shy", Folders: []string{"shy_old"}, ChannelID: 777},
+		},
+	})
+
+	testCases := []struct {
+		name          string
+		path          string
+		exportTitle   string
+		channelID     int64
+		expectedID    string
+		expectedTitle string
+		expectedChan  int64
+	}{
+		{"folder from config", "var/data/booba_2024/messages.html", "Booba Channel", 0, "booba", "Booba", 0},
+		{"another folder of the same group", "var/data/booba_2025/messages.html", "", 0, "booba", "Booba", 0},
+		{"config without title", "var/data/shy_old/messages.html", "Shy Channel", 0, "shy", "Shy Channel", 777},
+		{"JSON export of the folder", "var/data/shy_old/result.json", "Shy Channel", 777, "shy", "Shy Channel", 777},
+		{"channel from config in another folder", "var/data/shy_new/result.json", "Shy Channel", 777, "shy", "Shy Channel", 777},
+		{"folder from config of JSON export", "var/data/booba_2024/result.json", "Booba Channel", 123, "booba", "Booba", 123},
+		{"folder name", "var/data/other/messages.html", "Other Channel", 0, "other", "Other Channel", 0},
+		{"channel ID of JSON export", "var/data/other/result.json", "Other Channel", 123, "channel123", "Other Channel", 123},
+		{"channel ID without title", "var/data/renamed/result.json", "", 123, "channel123", "channel123", 123},
+		{"folder name without title", "var/data/other/messages.html", "", 0, "other", "other", 0},
+		{"archive name", "var/data/other.zip/ChatExport/messages.html", "Other Channel", 0, "other", "Other Channel", 0},
+		{"archive from config", "var/data/booba_2025.tar.gz/ChatExport/messages.html", "", 0, "booba", "Booba", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			group := parser.obtainGroup(tc.path, tc.exportTitle, tc.channelID)
+			assert.Equal(t, tc.expectedID, group.id)
+			assert.Equal(t, tc.expectedTitle, group.title)
+			assert.Equal(t, tc.expectedChan, group.channelID)
+		})
+	}
+}
+
+func TestCheckChannel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{
+		DataPath: "var/data",
+		Groups: []config.GroupConfig{
+			{ID: "shy", Folders: []string{"shy_old"}, ChannelID: 777},
+			{ID: "booba", Folders: []string{"booba"}},
+		},
+	})
+
+	assert.NoError(t, parser.checkChannel("var/data/shy_old/result.json", 777))
+	assert.NoError(t, parser.checkChannel("var/data/other/result.json", 123), "Канал не из конфига — группа по ID канала")
+	assert.NoError(t, parser.checkChannel("var/data/other/result.json", 0))
+	assert.NoError(t, parser.checkChannel("var/data/renamed/result.json", 777), "Группа канала не зависит от папки")
+	assert.NoError(t, parser.checkChannel("var/data/booba/result.json", 123), "Папка группы без channel_id")
+
+	err := parser.checkChannel("var/data/shy_old/result.json", 123)
+	assert.ErrorContains(t, err, `folder "shy_old" of group "shy" (channel 777) has an export of channel 123`)
+	err = parser.checkChannel("var/data/booba/result.json", 777)
+	assert.ErrorContains(t, err, `channel 777 of group "shy" is exported to folder "booba" of group "booba"`)
+}
+
+func TestExtractHTMLMedia(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<div class="media_wrap clearfix">
+		<a class="animated_wrap clearfix pull_left" href="video_files/animation.mp4"></a>
+		<a class="sticker_wrap clearfix pull_left" href="stickers/sticker.webp"></a>
+		<div class="media clearfix pull_left media_photo">
+			<div class="body"><div class="title bold">Sticker</div><div class="description">Not included, change data exporting settings to download.</div></div>
+		</div>
+	</div>`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []models.Media{
+		{Kind: models.MediaKindVideo, Title: "Animation", Included: true},
+		{Kind: models.MediaKindSticker, Title: "Sticker", Included: true},
+		{Kind: models.MediaKindSticker, Title: "Sticker", Included: false},
+	}, extractHTMLMedia(doc.Selection), "Стикеры не считаются фото")
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"233.5 KB", 239104},
+		{"2.4 MB", 2516582},
+		{"00:05, 1.5 MB", 1572864},
+		{"512 B", 512},
+		{"1 GB", 1 << 30},
+		{"", 0},
+		{"00:05", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseSize(tt.input))
+		})
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected int
@@ -73,23 +189,30 @@ func TestParseTZOffset(t *testing.T) {
 		{"UTC-00:45", -45 * 60, false},
 		{"UTC+12:15", 12*3600 + 15*60, false},
 		{"UTC-11:59", -11*3600 - 59*60, false},
-		{"UTC+99:99", 0, true}, // Нереальная зона
-		{"GMT+03:00", 0, true}, // Неподдерживаемый формат
-		{"UTC+3", 0, true},     // Неполный формат
-		{"UTC+03:XX", 0, true}, // Ошибка в минутах
-		{"random", 0, true},    // Полностью некорректный ввод
-		{"UTC+15:00", 0, true}, // Превышает максимум (14:00)
-		{"UTC-14:01", 0, true}, // Превышает минимум (-14:00)
+		{"UTC", 0, false},              // Без смещения
+		{"GMT", 0, false},              // Без смещения
+		{"GMT+03:00", 3 * 3600, false}, // GMT вместо UTC
+		{"GMT+3", 3 * 3600, false},     // Только часы
+		{"UTC+3", 3 * 3600, false},     // Только часы
+		{"UTC+0530", 5*3600 + 30*60, false},
+		{"+03:00", 3 * 3600, false}, // Без префикса
+		{"-0800", -8 * 3600, false}, // Без префикса и двоеточия
+		{"UTC+99:99", 0, true},      // Нереальная зона
+		{"UTC+03:XX", 0, true},      // Ошибка в минутах
+		{"random", 0, true},         // Полностью некорректный ввод
+		{"UTC+15:00", 0, true},      // Превышает максимум (14:00)
+		{"UTC-14:01", 0, true},      // Превышает минимум (-14:00)
+		{"MSK", 0, true},            // Аббревиатуры не поддерживаются
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			result, err := parseTZOffset(tt.input)
+			result, err := parseTimezone(tt.input)
 			if (err != nil) != tt.hasError {
-				t.Errorf("parseTZOffset(%q) error = %v, expected error = %v", tt.input, err, tt.hasError)
+				t.Errorf("parseTimezone(%q) error = %v, expected error = %v", tt.input, err, tt.hasError)
 			}
 			if result != tt.expected {
-				t.Errorf("parseTZOffset(%q) = %d, expected %d", tt.input, result, tt.expected)
+				t.Errorf("parseTimezone(%q) = %d, expected %d", tt.input, result, tt.expected)
 			}
 		})
 	}
@@ -98,7 +221,7 @@ func TestParseTZOffset(t *testing.T) {
 func TestExtractFirstSubfolder(t *testing.T) {
 	var buf bytes.Buffer
 	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
-	parser := NewTgArchivedHTMLParser(logger, "")
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
 
 	testCases := []struct {
 		path     string
@@ -119,3 +242,276 @@ func TestExtractFirstSubfolder(t *testing.T) {
 		assert.Equal(t, tc.expected, result)
 	}
 }
+
+func TestTgArchivedHTMLParser_ParseFile_Entities(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
+
+	messagesChan := make(chan models.Message, 10)
+	_, err := parser.ParseFile(fs.NewExportFile("testdata/entities.html"), messagesChan)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(messagesChan))
+
+	msg100 := <-messagesChan
+	assert.Equal(t, []string{"booba"}, msg100.Tags)
+	assert.Equal(t, []string{"someone"}, msg100.Mentions, "Упоминание не должно попадать в теги")
+	assert.Empty(t, msg100.URLs)
+
+	msg101 := <-messagesChan
+	assert.Empty(t, msg101.Tags, "Ссылки и кэштеги не должны попадать в теги")
+	assert.Equal(t, []string{"BTC"}, msg101.Cashtags)
+	assert.Equal(t, []string{"https://example.com/page", "https://example.com/hidden"}, msg101.URLs)
+	assert.Equal(t, []models.Media{
+		{Kind: models.MediaKindPhoto, Title: "Photo", Included: true},
+		{Kind: models.MediaKindVoiceMessage, Title: "Voice message", Size: 1572864, Included: false},
+	}, msg101.Media)
+
+	msg102 := <-messagesChan
+	assert.Equal(t, []string{"todo"}, msg102.Tags)
+	assert.Equal(t, []string{"start"}, msg102.BotCommands)
+	assert.Equal(t, []string{"John Doe"}, msg102.Mentions)
+
+	msg103 := <-messagesChan
+	assert.Equal(t, "Admin", msg103.From, "joined-сообщение наследует отправителя предыдущего сообщения")
+	assert.Equal(t, "Joined post", msg103.Text)
+}
+
+func TestTgArchivedHTMLParser_ParseFile_TextAndFrom(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
+
+	messagesChan := make(chan models.Message, 10)
+	_, err := parser.ParseFile(fs.NewExportFile("testdata/entities.html"), messagesChan)
+	require.NoError(t, err)
+	close(messagesChan)
+
+	var messages []models.Message
+	for msg := range messagesChan {
+		messages = append(messages, msg)
+	}
+	require.Len(t, messages, 4)
+
+	assert.Equal(t, "Channel Title", messages[0].From)
+	assert.Equal(t, "#booba by @someone", messages[0].Text, "Хэштеги и упоминания остаются в тексте на своих местах")
+	assert.Equal(t, "Buy $BTC, see https://example.com/page and this", messages[1].Text)
+	assert.Equal(t, "Admin", messages[2].From)
+	assert.Equal(t, "Press /start,\nask John Doe #todo", messages[2].Text)
+}
+
+func TestTgArchivedHTMLParser_ParseFile_Relations(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
+
+	messagesChan := make(chan models.Message, 10)
+	_, err := parser.ParseFile(fs.NewExportFile("testdata/relations.html"), messagesChan)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(messagesChan))
+
+	fixedZone := time.FixedZone("UTC+03:00", 3*60*60)
+
+	msg200 := <-messagesChan
+	assert.Equal(t, "Channel Title", msg200.From)
+	require.NotNil(t, msg200.Forward)
+	assert.Equal(t, "Original Channel", msg200.Forward.From)
+	assert.Equal(t, time.Date(2024, time.November, 20, 10, 0, 0, 0, fixedZone).UTC(), msg200.Forward.Datetime)
+	assert.Equal(t, "Forwarded #booba", msg200.Text, "Текст пересланного сообщения")
+	assert.Equal(t, []string{"booba"}, msg200.Tags)
+	assert.Equal(t, map[string]int{"👍": 12, "❤": 2}, msg200.Reactions)
+	assert.Empty(t, msg200.ReplyTo)
+
+	msg201 := <-messagesChan
+	assert.Equal(t, "message200", msg201.ReplyTo)
+	assert.Nil(t, msg201.Forward)
+	assert.Nil(t, msg201.Reactions)
+	assert.Equal(t, []string{"shy"}, msg201.Tags)
+
+	msg202 := <-messagesChan
+	assert.Equal(t, "message150", msg202.ReplyTo, "Ответ на сообщение с другой страницы экспорта")
+}
+
+func TestTgArchivedHTMLParser_ParseFile_Report(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
+
+	messagesChan := make(chan models.Message, 10)
+	report, err := parser.ParseFile(fs.NewExportFile("testdata/broken.html"), messagesChan)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(messagesChan))
+	assert.Equal(t, "message304", (<-messagesChan).MessageID)
+
+	assert.Equal(t, "testdata/broken.html", report.Filename)
+	assert.Equal(t, 1, report.Parsed)
+	assert.Equal(t, 5, report.Total())
+	assert.InDelta(t, 0.8, report.SkipRatio(), 1e-9)
+	assert.Equal(t, []SkipReason{
+		{MessageID: "", Selector: "div.message.default[id]", Reason: "no message id"},
+		{MessageID: "message301", Selector: "div.pull_right.date.details[title]", Reason: "no date title"},
+		{MessageID: "message302", Selector: "div.pull_right.date.details[title]", Reason: "unknown date format: yesterday at 7:20 PM"},
+		{MessageID: "message303", Selector: "div.pull_right.date.details[title]", Reason: "unknown date format: 21.11.2024 19:20:37 MSK"},
+	}, report.Skipped)
+}
+
+func TestTgArchivedHTMLParser_ParseFile_Service(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{})
+
+	messagesChan := make(chan models.Message, 10)
+	report, err := parser.ParseFile(fs.NewExportFile("testdata/service.html"), messagesChan)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(messagesChan))
+	assert.Equal(t, 2, report.Parsed, "Сервисные сообщения не считаются сообщениями")
+	assert.Empty(t, report.Skipped)
+
+	fixedZone := time.FixedZone("UTC+03:00", 3*60*60)
+	msg402 := <-messagesChan
+	require.NotNil(t, msg402.Edited)
+	assert.Equal(t, time.Date(2024, time.November, 21, 20, 5, 0, 0, fixedZone).UTC(), *msg402.Edited)
+	assert.Equal(t, time.Date(2024, time.November, 21, 19, 20, 37, 0, fixedZone).UTC(), msg402.Datetime)
+	assert.Equal(t, []string{"booba"}, msg402.Tags)
+	msg404 := <-messagesChan
+	assert.Nil(t, msg404.Edited)
+
+	day1 := time.Date(2024, time.November, 21, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, time.November, 22, 0, 0, 0, 0, time.UTC)
+	// сервисное сообщение до первого разделителя дат не имеет даты и пропускается
+	require.Len(t, report.Events, 4)
+	assert.Equal(t, models.ServiceEvent{
+		UUID: parser.obtainUUID("date2024-11-21", ""), MessageID: "message-1", Group: "", GroupTitle: "Channel Title",
+		Type: models.ServiceEventDate, Datetime: day1, Text: "21 November 2024",
+	}, report.Events[0])
+	assert.Equal(t, models.ServiceEvent{
+		UUID: parser.obtainUUID("message401", ""), MessageID: "message401", Group: "", GroupTitle: "Channel Title",
+		Type: models.ServiceEventTitle, Datetime: day1, Text: "Channel title changed to «New Title»", Title: "New Title",
+	}, report.Events[1])
+	assert.Equal(t, models.ServiceEvent{
+		UUID: parser.obtainUUID("message403", ""), MessageID: "message403", Group: "", GroupTitle: "Channel Title",
+		Type: models.ServiceEventPin, Datetime: msg402.Datetime, Actor: "Channel Title", Text: "Channel Title pinned this message",
+		PinnedMessageID: "message402",
+	}, report.Events[2])
+	assert.Equal(t, models.ServiceEventDate, report.Events[3].Type)
+	assert.Equal(t, day2, report.Events[3].Datetime)
+}
+
+func TestTgArchivedHTMLParser_ParseFile_Dates(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	moscow := time.FixedZone("MSK", 3*3600)
+
+	testCases := []struct {
+		name     string
+		conf     config.DatesConfig
+		expected []time.Time
+		offsets  []int
+	}{
+		{
+			name: "detected",
+			expected: []time.Time{
+				time.Date(2024, time.November, 22, 0, 20, 37, 0, time.UTC),
+				time.Date(2024, time.November, 22, 7, 20, 37, 0, time.UTC),
+				time.Date(2024, time.November, 23, 19, 0, 0, 0, time.UTC),
+				time.Date(2024, time.November, 24, 10, 0, 0, 0, time.UTC),
+			},
+			offsets: []int{-5 * 3600, 0, 3 * 3600, 0},
+		},
+		{
+			name: "timezone from config",
+			conf: config.DatesConfig{Timezone: "Europe/Moscow"},
+			expected: []time.Time{
+				time.Date(2024, time.November, 22, 0, 20, 37, 0, time.UTC),
+				time.Date(2024, time.November, 22, 7, 20, 37, 0, time.UTC),
+				time.Date(2024, time.November, 23, 19, 0, 0, 0, time.UTC),
+				time.Date(2024, time.November, 24, 10, 0, 0, 0, moscow).UTC(),
+			},
+			offsets: []int{-5 * 3600, 0, 3 * 3600, 3 * 3600},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := NewTgArchivedHTMLParser(logger, &config.SystemConfig{Dates: tc.conf})
+			messagesChan := make(chan models.Message, 10)
+			report, err := parser.ParseFile(fs.NewExportFile("testdata/dates.html"), messagesChan)
+			require.NoError(t, err)
+			require.Empty(t, report.Skipped, "Даты других локалей и версий клиента не должны теряться")
+			close(messagesChan)
+
+			var datetimes []time.Time
+			var offsets []int
+			for msg := range messagesChan {
+				datetimes = append(datetimes, msg.Datetime)
+				offsets = append(offsets, msg.TZOffset)
+			}
+			assert.Equal(t, tc.expected, datetimes)
+			assert.Equal(t, tc.offsets, offsets)
+		})
+	}
+}
+
+func TestDateFormats_Parse(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	// день и месяц через слэш неоднозначны, европейский формат задаётся в конфиге
+	dates := newDateFormats(logger, config.DatesConfig{Layouts: []string{"02/01/2006 15:04:05"}})
+	var detected string
+	dt, err := dates.parse("03/02/2025 10:00:00 UTC+03:00", &detected)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, time.February, 3, 7, 0, 0, 0, time.UTC), dt.UTC())
+	assert.Equal(t, "02/01/2006 15:04:05", detected)
+
+	_, err = dates.parse("21.11.2024 19:20:37 UTC+03:00", &detected)
+	assert.EqualError(t, err, "unknown date format: 21.11.2024 19:20:37 UTC+03:00", "Встроенные форматы заменяются форматами из конфига")
+
+	// формат, найденный в файле, пробуется первым
+	dates = newDateFormats(logger, config.DatesConfig{})
+	detected = "02/01/2006 15:04:05"
+	dt, err = dates.parse("03/02/2025 10:00:00 UTC", &detected)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, time.February, 3, 10, 0, 0, 0, time.UTC), dt.UTC())
+
+	dates = newDateFormats(logger, config.DatesConfig{Timezone: "Nowhere/Invalid"})
+	assert.Equal(t, time.UTC, dates.loc)
+	assert.Contains(t, buf.String(), "invalid dates timezone")
+}
+
+func TestDateFormats_ParseDay(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	dates := newDateFormats(logger, config.DatesConfig{Timezone: "Europe/Moscow"})
+	var detected string
+	day, err := dates.parseDay("21 November 2024", &detected)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.November, 20, 21, 0, 0, 0, time.UTC), day.UTC(), "День начинается в часовом поясе из конфига")
+	assert.Equal(t, "2 January 2006", detected)
+
+	// дата из форматов дат сообщений
+	detected = ""
+	day, err = dates.parseDay("21.11.2024", &detected)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-11-21", day.Format(time.DateOnly))
+	assert.Equal(t, "02.01.2006", detected)
+
+	dates = newDateFormats(logger, config.DatesConfig{Layouts: []string{"02/01/2006 15:04:05"}})
+	day, err = dates.parseDay("03/02/2025", &detected)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, time.February, 3, 0, 0, 0, 0, time.UTC), day)
+
+	_, err = dates.parseDay("21 ноября 2024", &detected)
+	assert.EqualError(t, err, "unknown day format: 21 ноября 2024")
+}
+
+func TestParseReport_SkipRatio(t *testing.T) {
+	report := NewParseReport("file.html")
+	assert.Equal(t, 0.0, report.SkipRatio(), "Файл без сообщений")
+
+	report.Parsed = 3
+	report.Skip("message1", "selector", "reason")
+	assert.Equal(t, 4, report.Total())
+	assert.Equal(t, 0.25, report.SkipRatio())
+}
@@ -0,0 +1,86 @@
+package tg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+var goToMessageRe = regexp.MustCompile(`go_to_message(\d+)`)
+
+// extractHTMLReplyTo возвращает ID сообщения, на которое отвечает сообщение: "In reply to <a href="#go_to_message2200">"
+func extractHTMLReplyTo(body *goquery.Selection) string {
+	href, exists := body.ChildrenFiltered("div.reply_to").Find("a").First().Attr("href")
+	if !exists {
+		return ""
+	}
+	return goToMessageID(href)
+}
+
+// goToMessageID возвращает ID сообщения из ссылки "#go_to_message2200"
+func goToMessageID(href string) string {
+	matches := goToMessageRe.FindStringSubmatch(href)
+	if matches == nil {
+		return ""
+	}
+	return "message" + matches[1]
+}
+
+// extractHTMLForward возвращает источник пересланного сообщения из div.forwarded.body
+func (p *archivedParser) extractHTMLForward(body *goquery.Selection, page *htmlPage) *models.Forward {
+	fromName := body.ChildrenFiltered("div.forwarded.body").ChildrenFiltered("div.from_name")
+	if fromName.Length() == 0 {
+		return nil
+	}
+	forward := &models.Forward{From: ownText(fromName)}
+	if dateStr, exists := fromName.Find(".date.details").Attr("title"); exists {
+		if datetime, err := p.dates.parse(dateStr, &page.dateLayout); err == nil {
+			forward.Datetime = datetime.UTC()
+		}
+	}
+	return forward
+}
+
+// extractHTMLReactions собирает счётчики реакций: emoji -> количество
+func extractHTMLReactions(s *goquery.Selection) map[string]int {
+	reactions := make(map[string]int)
+	s.Find(".reactions .reaction").Each(func(i int, r *goquery.Selection) {
+		emoji := strings.TrimSpace(r.Find(".emoji").Text())
+		if emoji == "" {
+			return
+		}
+		// при малом числе реакций Telegram показывает аватарки вместо счётчика
+		count, err := strconv.Atoi(strings.TrimSpace(r.Find(".count").Text()))
+		if err != nil {
+			count = max(r.Find(".userpic").Length(), 1)
+		}
+		reactions[emoji] += count
+	})
+	if len(reactions) == 0 {
+		return nil
+	}
+	return reactions
+}
+
+// extractJSONRelations переносит ответы, пересылки и реакции JSON-экспорта в сообщение
+func extractJSONRelations(jm jsonMessage, msg *models.Message) {
+	if jm.ReplyToMessageID != 0 {
+		msg.ReplyTo = "message" + strconv.FormatInt(jm.ReplyToMessageID, 10)
+	}
+	if jm.ForwardedFrom != "" {
+		msg.Forward = &models.Forward{From: jm.ForwardedFrom}
+	}
+	for _, r := range jm.Reactions {
+		if r.Emoji == "" {
+			continue
+		}
+		if msg.Reactions == nil {
+			msg.Reactions = make(map[string]int)
+		}
+		msg.Reactions[r.Emoji] += r.Count
+	}
+}
@@ -0,0 +1,41 @@
+package tg
+
+import "github.com/meesooqa/tgtag/pkg/models"
+
+// ParseReport is the result of parsing one file: how many messages were sent and which were skipped and why
+type ParseReport struct {
+	Filename string
+	Parsed   int
+	Skipped  []SkipReason
+	// Events are service messages of the file, they are not counted as parsed or skipped
+	Events []models.ServiceEvent
+}
+
+// SkipReason describes a message that was not parsed
+type SkipReason struct {
+	MessageID string
+	Selector  string
+	Reason    string
+}
+
+func NewParseReport(filename string) *ParseReport {
+	return &ParseReport{Filename: filename}
+}
+
+// Skip records a skipped message
+func (r *ParseReport) Skip(messageID, selector, reason string) {
+	r.Skipped = append(r.Skipped, SkipReason{MessageID: messageID, Selector: selector, Reason: reason})
+}
+
+// Total returns the number of messages met in the file
+func (r *ParseReport) Total() int {
+	return r.Parsed + len(r.Skipped)
+}
+
+// SkipRatio returns the share of skipped messages, 0 for a file without messages
+func (r *ParseReport) SkipRatio() float64 {
+	if r.Total() == 0 {
+		return 0
+	}
+	return float64(len(r.Skipped)) / float64(r.Total())
+}
@@ -2,27 +2,44 @@ package tg
 
 import (
 	"log/slog"
+	"path/filepath"
+	"strings"
 
 	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
 	"github.com/meesooqa/tgtag/pkg/models"
 )
 
 type Service interface {
-	ParseArchivedFile(filename string, messagesChan chan<- models.Message) error
+	ParseArchivedFile(file fs.ExportFile, messagesChan chan<- models.Message) (*ParseReport, error)
 }
 
 type TgService struct {
-	log    *slog.Logger
-	parser Parser
+	log     *slog.Logger
+	parsers map[string]Parser
 }
 
 func NewService(log *slog.Logger, conf *config.SystemConfig) *TgService {
+	htmlParser := NewTgStreamingHTMLParser(log, conf)
+	jsonParser := NewTgArchivedJSONParser(log, conf)
 	return &TgService{
-		log:    log,
-		parser: NewTgArchivedHTMLParser(log, conf.DataPath),
+		log: log,
+		parsers: map[string]Parser{
+			".html": htmlParser,
+			".htm":  htmlParser,
+			".json": jsonParser,
+		},
 	}
 }
 
-func (s *TgService) ParseArchivedFile(filename string, messagesChan chan<- models.Message) error {
-	return s.parser.ParseFile(filename, messagesChan)
+func (s *TgService) ParseArchivedFile(file fs.ExportFile, messagesChan chan<- models.Message) (*ParseReport, error) {
+	return s.parserFor(file.Path).ParseFile(file, messagesChan)
+}
+
+// parserFor picks the parser by file extension, HTML is the default export format
+func (s *TgService) parserFor(filename string) Parser {
+	if parser, ok := s.parsers[strings.ToLower(filepath.Ext(filename))]; ok {
+		return parser
+	}
+	return s.parsers[".html"]
 }
@@ -0,0 +1,108 @@
+package tg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// titleChangeRe matches "Channel title changed to «New»" and "Name changed group title to «New»"
+var titleChangeRe = regexp.MustCompile(`title (?:changed )?to «(.*)»$`)
+
+// parseHTMLService разбирает div.message.service: разделитель дат, закреп, смену названия.
+// У сервисных сообщений HTML-экспорта нет своей даты, берётся дата последнего разделителя или сообщения страницы.
+func (p *archivedParser) parseHTMLService(s *goquery.Selection, page *htmlPage) (models.ServiceEvent, bool) {
+	id, exists := s.Attr("id")
+	if !exists {
+		p.log.Debug("service message skipped", "filename", page.report.Filename, "reason", "no message id")
+		return models.ServiceEvent{}, false
+	}
+
+	body := s.ChildrenFiltered("div.body")
+	event := models.ServiceEvent{
+		MessageID:  id,
+		Group:      page.group.id,
+		GroupTitle: page.group.title,
+		ChannelID:  page.group.channelID,
+		Type:       models.ServiceEventOther,
+		Text:       extractText(body),
+	}
+
+	// разделители дат имеют отрицательные ID: "message-1"
+	if strings.HasPrefix(id, "message-") {
+		day, err := p.dates.parseDay(event.Text, &page.dayLayout)
+		if err != nil {
+			p.log.Debug("service message skipped", "filename", page.report.Filename, "messageID", id, "reason", err.Error())
+			return models.ServiceEvent{}, false
+		}
+		page.lastDate = day.UTC()
+		event.Type = models.ServiceEventDate
+		event.Datetime = day.UTC()
+		// ID разделителей различаются между страницами и экспортами, разделитель определяется днём
+		event.UUID = p.obtainUUID("date"+day.Format(time.DateOnly), page.group.id)
+		return event, true
+	}
+
+	if page.lastDate.IsZero() {
+		p.log.Debug("service message skipped", "filename", page.report.Filename, "messageID", id, "reason", "no date")
+		return models.ServiceEvent{}, false
+	}
+	event.Datetime = page.lastDate
+	event.UUID = p.obtainUUID(id, page.group.id)
+
+	if href, exists := body.Find("a").First().Attr("href"); exists && strings.Contains(event.Text, " pinned ") {
+		event.Type = models.ServiceEventPin
+		event.PinnedMessageID = goToMessageID(href)
+		event.Actor, _, _ = strings.Cut(event.Text, " pinned ")
+	} else if matches := titleChangeRe.FindStringSubmatch(event.Text); matches != nil {
+		event.Type = models.ServiceEventTitle
+		event.Title = matches[1]
+		if actor, _, found := strings.Cut(event.Text, " changed group title"); found {
+			event.Actor = actor
+		}
+	}
+	return event, true
+}
+
+// jsonServiceTypes maps actions of JSON exports to service event types
+var jsonServiceTypes = map[string]string{
+	"pin_message":      models.ServiceEventPin,
+	"edit_group_title": models.ServiceEventTitle,
+	"edit_chat_title":  models.ServiceEventTitle,
+}
+
+func (p *TgArchivedJSONParser) toServiceEvent(jm jsonMessage, group exportGroup) (models.ServiceEvent, bool) {
+	id := "message" + strconv.FormatInt(jm.ID, 10)
+	datetime, err := parseJSONDate(jm.Date, jm.DateUnixtime, p.dates.loc)
+	if err != nil {
+		p.log.Debug("service message skipped", "messageID", id, "reason", err.Error())
+		return models.ServiceEvent{}, false
+	}
+
+	event := models.ServiceEvent{
+		UUID:       p.obtainUUID(id, group.id),
+		MessageID:  id,
+		Group:      group.id,
+		GroupTitle: group.title,
+		ChannelID:  group.channelID,
+		Type:       models.ServiceEventOther,
+		Datetime:   datetime.UTC(),
+		Actor:      jm.Actor,
+		Action:     jm.Action,
+	}
+	if t, ok := jsonServiceTypes[jm.Action]; ok {
+		event.Type = t
+	}
+	switch event.Type {
+	case models.ServiceEventPin:
+		event.PinnedMessageID = "message" + strconv.FormatInt(jm.MessageID, 10)
+	case models.ServiceEventTitle:
+		event.Title = jm.Title
+	}
+	return event, true
+}
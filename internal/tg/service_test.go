@@ -0,0 +1,22 @@
+package tg
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/meesooqa/tgtag/internal/config"
+)
+
+func TestTgService_ParserFor(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	service := NewService(logger, &config.SystemConfig{DataPath: "var/data"})
+
+	assert.IsType(t, &TgStreamingHTMLParser{}, service.parserFor("var/data/group/messages.html"))
+	assert.IsType(t, &TgArchivedJSONParser{}, service.parserFor("var/data/group/result.json"))
+	assert.IsType(t, &TgArchivedJSONParser{}, service.parserFor("var/data/group/RESULT.JSON"))
+	assert.IsType(t, &TgStreamingHTMLParser{}, service.parserFor("var/data/group/file"))
+}
@@ -0,0 +1,139 @@
+package tg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// voidElements never have an end tag, so they are not pushed onto the stack of open elements
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// TgStreamingHTMLParser parses HTML exports with the tokenizer.
+// Only the element being parsed (the page header or one message) is kept in memory,
+// so the memory does not depend on the file size. The output is the same as of TgArchivedHTMLParser.
+type TgStreamingHTMLParser struct {
+	archivedParser
+}
+
+func NewTgStreamingHTMLParser(log *slog.Logger, conf *config.SystemConfig) *TgStreamingHTMLParser {
+	return &TgStreamingHTMLParser{newArchivedParser(log, conf)}
+}
+
+func (p *TgStreamingHTMLParser) ParseFile(file fs.ExportFile, messagesChan chan<- models.Message) (*ParseReport, error) {
+	report := NewParseReport(file.Name)
+	f, err := file.Open()
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	var title string
+	var page *htmlPage
+	err = p.walk(bufio.NewReader(f), func(root *html.Node) {
+		s := goquery.NewDocumentFromNode(root).Selection
+		if s.HasClass("page_header") {
+			title = htmlPageTitle(s)
+			return
+		}
+		// the header goes before messages, the group is resolved once at the first message
+		if page == nil {
+			page = p.newHTMLPage(file.Name, title, report)
+		}
+		p.handleHTMLMessage(s, page, messagesChan)
+	})
+	return report, err
+}
+
+// walk builds a detached subtree for every div.page_header, div.message.default and div.message.service and passes it to handle
+// as soon as the element is closed, everything outside of these elements is skipped.
+// A message nested in another one (an unclosed div of the export) is passed after it with its own subtree, as goquery finds it.
+func (p *TgStreamingHTMLParser) walk(r io.Reader, handle func(root *html.Node)) error {
+	z := html.NewTokenizer(r)
+	// open elements of the subtree being built, stack[0] is its root
+	var stack []*html.Node
+	// roots are elements of the subtree to be handled in document order, roots[0] is stack[0]
+	var roots []*html.Node
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				return nil
+			}
+			return z.Err()
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			isRoot := tt == html.StartTagToken && isCaptureRoot(tok)
+			if len(stack) == 0 && !isRoot {
+				continue
+			}
+			n := &html.Node{Type: html.ElementNode, Data: tok.Data, DataAtom: tok.DataAtom, Attr: tok.Attr}
+			if len(stack) > 0 {
+				stack[len(stack)-1].AppendChild(n)
+			}
+			if isRoot {
+				roots = append(roots, n)
+			}
+			if tt == html.StartTagToken && !voidElements[tok.Data] {
+				stack = append(stack, n)
+			}
+
+		case html.EndTagToken:
+			if len(stack) == 0 {
+				continue
+			}
+			name, _ := z.TagName()
+			// unclosed elements are closed implicitly, as the HTML parser does
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].Data != string(name) {
+					continue
+				}
+				stack = stack[:i]
+				if len(stack) == 0 {
+					for _, root := range roots {
+						handle(root)
+					}
+					roots = nil
+				}
+				break
+			}
+
+		case html.TextToken:
+			if len(stack) == 0 {
+				continue
+			}
+			stack[len(stack)-1].AppendChild(&html.Node{Type: html.TextNode, Data: string(z.Text())})
+		}
+	}
+}
+
+// isCaptureRoot checks that the element starts a subtree to be parsed
+func isCaptureRoot(tok html.Token) bool {
+	if tok.Data != "div" {
+		return false
+	}
+	for _, a := range tok.Attr {
+		if a.Key != "class" {
+			continue
+		}
+		classes := strings.Fields(a.Val)
+		return slices.Contains(classes, "page_header") ||
+			(slices.Contains(classes, "message") && (slices.Contains(classes, "default") || slices.Contains(classes, "service")))
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package tg
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meesooqa/tgtag/internal/config"
+	"github.com/meesooqa/tgtag/internal/fs"
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// TestTgStreamingHTMLParser_SameAsDOM проверяет, что потоковый парсер выдаёт то же, что и goquery-парсер.
+func TestTgStreamingHTMLParser_SameAsDOM(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	conf := &config.SystemConfig{}
+	domParser := NewTgArchivedHTMLParser(logger, conf)
+	streamParser := NewTgStreamingHTMLParser(logger, conf)
+
+	files, err := filepath.Glob("testdata/*.html")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			expected, expectedReport := collectMessages(t, domParser, file)
+			actual, actualReport := collectMessages(t, streamParser, file)
+			require.NotEmpty(t, expected)
+			assert.Equal(t, expected, actual)
+			assert.Equal(t, expectedReport, actualReport)
+		})
+	}
+}
+
+func TestTgStreamingHTMLParser_ParseFile_NotFound(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	parser := NewTgStreamingHTMLParser(logger, &config.SystemConfig{})
+
+	_, err := parser.ParseFile(fs.NewExportFile("testdata/not_found.html"), make(chan models.Message, 1))
+	assert.Error(t, err)
+}
+
+func BenchmarkHTMLParsers(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	conf := &config.SystemConfig{}
+	file := generateLargeExport(b, 5000)
+
+	parsers := []struct {
+		name   string
+		parser Parser
+	}{
+		{"goquery", NewTgArchivedHTMLParser(logger, conf)},
+		{"tokenizer", NewTgStreamingHTMLParser(logger, conf)},
+	}
+	for _, bp := range parsers {
+		b.Run(bp.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				messagesChan := make(chan models.Message, 100)
+				done := make(chan struct{})
+				go func() {
+					for range messagesChan {
+					}
+					close(done)
+				}()
+				if _, err := bp.parser.ParseFile(fs.NewExportFile(file), messagesChan); err != nil {
+					b.Fatal(err)
+				}
+				close(messagesChan)
+				<-done
+			}
+		})
+	}
+}
+
+func collectMessages(t *testing.T, parser Parser, file string) ([]models.Message, *ParseReport) {
+	messagesChan := make(chan models.Message, 100)
+	report, err := parser.ParseFile(fs.NewExportFile(file), messagesChan)
+	require.NoError(t, err)
+	close(messagesChan)
+	var messages []models.Message
+	for msg := range messagesChan {
+		messages = append(messages, msg)
+	}
+	return messages, report
+}
+
+// generateLargeExport размножает сообщения testdata/test.html до n штук во временном файле.
+func generateLargeExport(b *testing.B, n int) string {
+	data, err := os.ReadFile("testdata/test.html")
+	require.NoError(b, err)
+	src := string(data)
+
+	start := strings.Index(src, `<div class="message default clearfix" id="message2203">`)
+	end := strings.Index(src, `<a class="pagination block_link" href="messages4.html">`)
+	require.True(b, start > 0 && end > start)
+	block := src[start:end]
+
+	var sb strings.Builder
+	sb.WriteString(src[:start])
+	for i := 0; i < n; i++ {
+		sb.WriteString(strings.ReplaceAll(block, `id="message`, fmt.Sprintf(`id="message%d_`, i)))
+	}
+	sb.WriteString(src[end:])
+
+	file := filepath.Join(b.TempDir(), "messages.html")
+	require.NoError(b, os.WriteFile(file, []byte(sb.String()), 0644))
+	return file
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// IngestRun is a run of cmd/save: what files were read and what was saved
+type IngestRun struct {
+	RunID      string    `bson:"run_id" json:"runID"`
+	StartedAt  time.Time `bson:"started_at" json:"startedAt"`
+	FinishedAt time.Time `bson:"finished_at,omitempty" json:"finishedAt,omitempty"`
+	// ConfigHash tells runs with different parsing settings apart
+	ConfigHash string `bson:"config_hash" json:"configHash"`
+	// FilesSeen is the number of files found, FilesSkipped are unchanged since the last run
+	FilesSeen    int `bson:"files_seen" json:"filesSeen"`
+	FilesSkipped int `bson:"files_skipped" json:"filesSkipped"`
+	FilesFailed  int `bson:"files_failed" json:"filesFailed"`
+	// MessagesParsed and MessagesSkipped are numbers of the parser, the others are numbers of the repository
+	MessagesParsed   int `bson:"messages_parsed" json:"messagesParsed"`
+	MessagesSkipped  int `bson:"messages_skipped" json:"messagesSkipped"`
+	MessagesUpserted int `bson:"messages_upserted" json:"messagesUpserted"`
+	MessagesModified int `bson:"messages_modified" json:"messagesModified"`
+	MessagesFailed   int `bson:"messages_failed" json:"messagesFailed"`
+	// MessagesDeleted are saved messages of the ingested groups that are not found in the exports any more
+	MessagesDeleted int `bson:"messages_deleted" json:"messagesDeleted"`
+	// SkipReasons counts skipped messages per reason, the most frequent first
+	SkipReasons []SkipReasonCount `bson:"skip_reasons,omitempty" json:"skipReasons,omitempty"`
+	// Error is the reason of the failed run
+	Error string          `bson:"error,omitempty" json:"error,omitempty"`
+	Files []IngestRunFile `bson:"files,omitempty" json:"files,omitempty"`
+}
+
+// Ingest run file statuses
+const (
+	IngestFileParsed    = "parsed"
+	IngestFileUnchanged = "unchanged"
+	IngestFileFailed    = "failed"
+)
+
+// IngestRunFile is a file of the run
+type IngestRunFile struct {
+	Path     string `bson:"path" json:"path"`
+	Status   string `bson:"status" json:"status"`
+	Messages int    `bson:"messages" json:"messages"`
+	Skipped  int    `bson:"skipped" json:"skipped"`
+	Error    string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// SkipReasonCount is a number of messages skipped for the reason
+type SkipReasonCount struct {
+	Reason string `bson:"reason" json:"reason"`
+	Count  int    `bson:"count" json:"count"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ManifestEntry is an export file saved by a run, files with the same size and mtime or content hash are not parsed again
+type ManifestEntry struct {
+	// Path is the path on disk, entries of archives are named "var/data/group.zip/path/in/archive.html"
+	Path    string    `bson:"path" json:"path"`
+	Size    int64     `bson:"size" json:"size"`
+	ModTime time.Time `bson:"mod_time" json:"modTime"`
+	// Hash is SHA-256 of the content in hex
+	Hash string `bson:"hash" json:"hash"`
+	// Messages is the number of messages parsed from the file
+	Messages int `bson:"messages" json:"messages"`
+	// RunID is the run that parsed the file last
+	RunID string `bson:"run_id" json:"runID"`
+	// UUIDs are messages of the file, runs that find the file unchanged are set to them
+	UUIDs []string `bson:"uuids,omitempty" json:"uuids,omitempty"`
+}
@@ -6,12 +6,57 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Message represents Telegram exported-to-HTML message
+// Message represents Telegram exported-to-HTML message.
+// Datetime and Edited are in UTC, TZOffset is the offset of the exporting client in seconds.
 type Message struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UUID      string             `bson:"uuid" json:"uuid"`
-	MessageID string             `bson:"message_id" json:"messageID"`
-	Group     string             `bson:"group" json:"group"`
-	Datetime  time.Time          `bson:"datetime" json:"datetime"`
-	Tags      []string           `bson:"tags" json:"tags"`
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UUID       string             `bson:"uuid" json:"uuid"`
+	MessageID  string             `bson:"message_id" json:"messageID"`
+	Group      string             `bson:"group" json:"group"`
+	GroupTitle string             `bson:"group_title" json:"groupTitle"`
+	// ChannelID is the Telegram channel of the group, 0 if it is not known
+	ChannelID   int64          `bson:"channel_id,omitempty" json:"channelID,omitempty"`
+	Datetime    time.Time      `bson:"datetime" json:"datetime"`
+	TZOffset    int            `bson:"tz_offset" json:"tzOffset"`
+	Edited      *time.Time     `bson:"edited,omitempty" json:"edited,omitempty"`
+	From        string         `bson:"from" json:"from"`
+	Text        string         `bson:"text" json:"text"`
+	Tags        []string       `bson:"tags" json:"tags"`
+	RawTags     []string       `bson:"raw_tags,omitempty" json:"rawTags,omitempty"`
+	Mentions    []string       `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	Cashtags    []string       `bson:"cashtags,omitempty" json:"cashtags,omitempty"`
+	URLs        []string       `bson:"urls,omitempty" json:"urls,omitempty"`
+	BotCommands []string       `bson:"bot_commands,omitempty" json:"botCommands,omitempty"`
+	Media       []Media        `bson:"media,omitempty" json:"media,omitempty"`
+	ReplyTo     string         `bson:"reply_to,omitempty" json:"replyTo,omitempty"`
+	Forward     *Forward       `bson:"forward,omitempty" json:"forward,omitempty"`
+	Reactions   map[string]int `bson:"reactions,omitempty" json:"reactions,omitempty"`
+	// RunID is the ingest run that saved the message last
+	RunID string `bson:"run_id,omitempty" json:"runID,omitempty"`
+	// DeletedAt is set when a full ingest of the group does not find the message, it is cleared when the message is seen again
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deletedAt,omitempty"`
+}
+
+// Forward is the original source of a forwarded message
+type Forward struct {
+	From     string    `bson:"from" json:"from"`
+	Datetime time.Time `bson:"datetime,omitempty" json:"datetime,omitempty"`
+}
+
+// Media kinds, named after media_* classes of HTML exports. Stickers have the photo class, they are a kind of their own.
+const (
+	MediaKindPhoto        = "photo"
+	MediaKindVideo        = "video"
+	MediaKindVoiceMessage = "voice_message"
+	MediaKindAudioFile    = "audio_file"
+	MediaKindFile         = "file"
+	MediaKindSticker      = "sticker"
+)
+
+// Media is an attachment of a message
+type Media struct {
+	Kind     string `bson:"kind" json:"kind"`
+	Title    string `bson:"title" json:"title"`
+	Size     int64  `bson:"size" json:"size"`
+	Included bool   `bson:"included" json:"included"`
 }
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Service event types
+const (
+	// ServiceEventDate is a date separator of HTML exports
+	ServiceEventDate  = "date"
+	ServiceEventPin   = "pin"
+	ServiceEventTitle = "title"
+	// ServiceEventOther is any other service message: channel creation, photo change, etc.
+	ServiceEventOther = "other"
+)
+
+// ServiceEvent represents Telegram service message: date separator, pinned message, title change
+type ServiceEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UUID       string             `bson:"uuid" json:"uuid"`
+	MessageID  string             `bson:"message_id" json:"messageID"`
+	Group      string             `bson:"group" json:"group"`
+	GroupTitle string             `bson:"group_title" json:"groupTitle"`
+	// ChannelID is the Telegram channel of the group, 0 if it is not known
+	ChannelID int64     `bson:"channel_id,omitempty" json:"channelID,omitempty"`
+	Type      string    `bson:"type" json:"type"`
+	Datetime  time.Time `bson:"datetime" json:"datetime"`
+	Actor     string    `bson:"actor,omitempty" json:"actor,omitempty"`
+	Text      string    `bson:"text,omitempty" json:"text,omitempty"`
+	// Action is the raw action of JSON exports: "pin_message", "edit_group_title", etc.
+	Action string `bson:"action,omitempty" json:"action,omitempty"`
+	// Title is the new title of ServiceEventTitle
+	Title string `bson:"title,omitempty" json:"title,omitempty"`
+	// PinnedMessageID is the message of ServiceEventPin
+	PinnedMessageID string `bson:"pinned_message_id,omitempty" json:"pinnedMessageID,omitempty"`
+}
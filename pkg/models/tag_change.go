@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// TagChange is a change of message tags seen by an ingest run, e.g. an editor retagged an old post
+type TagChange struct {
+	UUID      string   `bson:"uuid" json:"uuid"`
+	MessageID string   `bson:"message_id" json:"messageID"`
+	Group     string   `bson:"group" json:"group"`
+	PrevTags  []string `bson:"prev_tags" json:"prevTags"`
+	Tags      []string `bson:"tags" json:"tags"`
+	// Added and Removed are the difference of PrevTags and Tags
+	Added   []string `bson:"added,omitempty" json:"added,omitempty"`
+	Removed []string `bson:"removed,omitempty" json:"removed,omitempty"`
+	// SeenAt is the time the change was saved, not the time of the edit in Telegram
+	SeenAt time.Time `bson:"seen_at" json:"seenAt"`
+	RunID  string    `bson:"run_id,omitempty" json:"runID,omitempty"`
+}
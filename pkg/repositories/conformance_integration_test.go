@@ -0,0 +1,17 @@
+package repositories_test
+
+import (
+	"testing"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+	"github.com/meesooqa/tgtag/pkg/repositories/repotest"
+)
+
+func TestMessageRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, messages ...models.Message) repositories.Repository {
+		repo := repositories.NewIntegrationRepository(t)
+		repositories.SaveIntegrationMessages(t, repo, messages...)
+		return repo
+	})
+}
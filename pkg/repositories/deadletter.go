@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// deadLetter is a document that could not be saved, one line of the JSONL dead-letter file
+type deadLetter struct {
+	Error    string    `bson:"error"`
+	FailedAt time.Time `bson:"failed_at"`
+	Document bson.M    `bson:"document"`
+}
+
+// deadLetterFile appends documents that could not be saved to the JSONL file.
+// Lines are canonical extended JSON, so dates and numbers are read back with their types.
+type deadLetterFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newDeadLetterFile(path string) *deadLetterFile {
+	return &deadLetterFile{path: path}
+}
+
+func (d *deadLetterFile) write(docs []bson.M, reason error) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	now := time.Now().UTC()
+	for _, doc := range docs {
+		line, err := bson.MarshalExtJSON(deadLetter{Error: reason.Error(), FailedAt: now, Document: doc}, true, false)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("document %v: %w", doc["uuid"], err)
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	return errors.Join(w.Flush(), file.Close())
+}
+
+// readDeadLetters reads documents of the dead-letter file
+func readDeadLetters(path string) ([]bson.M, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var docs []bson.M
+	scanner := bufio.NewScanner(file)
+	// a message with a long text is one long line
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var letter deadLetter
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &letter); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		docs = append(docs, letter.Document)
+	}
+	return docs, scanner.Err()
+}
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// maxFixtureLine is the longest line of a fixture, a message with a long text is one line
+const maxFixtureLine = 16 * 1024 * 1024
+
+// LoadFixtures saves messages of JSONL as they are, one message per line in the JSON of the API.
+// Unlike UpsertMany, deleted_at and run_id are kept, a message with the same UUID replaces the saved one.
+func (r *Repository) LoadFixtures(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxFixtureLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var msg models.Message
+		if err := json.Unmarshal([]byte(text), &msg); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if msg.UUID == "" {
+			return fmt.Errorf("line %d: message without uuid", line)
+		}
+		if err := r.Save(msg); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadFixturesFile loads messages of the JSONL file, see LoadFixtures
+func (r *Repository) LoadFixturesFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := r.LoadFixtures(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Save saves the message as it is, as InsertOne does, the message with the same UUID is replaced
+func (r *Repository) Save(msg models.Message) error {
+	saved, err := clone(msg)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if saved.ID.IsZero() {
+		saved.ID = primitive.NewObjectID()
+		if prev, ok := r.messages[saved.UUID]; ok {
+			saved.ID = prev.ID
+		}
+	}
+	r.messages[saved.UUID] = saved
+	return nil
+}
@@ -0,0 +1,520 @@
+// Package memory is the repository keeping messages in memory, for tests and demos without MongoDB
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// Repository is repositories.Repository with the semantics of repositories.MessageRepository, it is safe for concurrent use.
+// Documents are kept as MongoDB returns them: times in UTC with milliseconds, empty omitempty fields are nil.
+type Repository struct {
+	mu       sync.RWMutex
+	messages map[string]models.Message
+	events   map[string]models.ServiceEvent
+	manifest map[string]models.ManifestEntry
+	runs     map[string]models.IngestRun
+	history  []models.TagChange
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		messages: make(map[string]models.Message),
+		events:   make(map[string]models.ServiceEvent),
+		manifest: make(map[string]models.ManifestEntry),
+		runs:     make(map[string]models.IngestRun),
+	}
+}
+
+// FindMessages returns messages of the query one by one, the messages are copied before the iteration
+func (r *Repository) FindMessages(ctx context.Context, q repositories.MessageQuery) iter.Seq2[*models.Message, error] {
+	return func(yield func(*models.Message, error) bool) {
+		if _, err := q.AfterCursor(&models.Message{}); err != nil {
+			yield(nil, err)
+			return
+		}
+		var items []*models.Message
+		r.mu.RLock()
+		for _, msg := range r.messages {
+			if !q.Match(&msg) {
+				continue
+			}
+			if after, _ := q.AfterCursor(&msg); after {
+				items = append(items, copyMessage(msg))
+			}
+		}
+		r.mu.RUnlock()
+
+		slices.SortFunc(items, q.Compare)
+		if q.Limit > 0 && len(items) > q.Limit {
+			items = items[:q.Limit]
+		}
+		for _, msg := range items {
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+// CountMessages counts messages of the query, Sort, Limit and Cursor are ignored
+func (r *Repository) CountMessages(ctx context.Context, q repositories.MessageQuery) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	count := 0
+	for _, msg := range r.messages {
+		if q.Match(&msg) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UpsertMany saves messages by UUID as the MongoDB saver does: all fields but _id and uuid are set, deleted_at is unset
+func (r *Repository) UpsertMany(messagesChan <-chan models.Message) (repositories.IngestResult, error) {
+	var result repositories.IngestResult
+	for msg := range messagesChan {
+		saved, err := clone(msg)
+		if err != nil {
+			result.Failed++
+			continue
+		}
+		saved.DeletedAt = nil
+		r.upsert(saved, &result)
+	}
+	return result, nil
+}
+
+func (r *Repository) upsert(msg models.Message, result *repositories.IngestResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, ok := r.messages[msg.UUID]
+	if !ok {
+		msg.ID = primitive.NewObjectID()
+		r.messages[msg.UUID] = msg
+		result.Inserted++
+		return
+	}
+	msg.ID = prev.ID
+	// как в MongoDB, новый run_id не изменение сообщения, а пустой не записывается
+	msg.RunID = cmp.Or(msg.RunID, prev.RunID)
+	// ID канала из JSON-экспорта не стирается HTML-экспортом той же группы
+	msg.ChannelID = cmp.Or(msg.ChannelID, prev.ChannelID)
+	sameRun := prev
+	sameRun.RunID = msg.RunID
+	if reflect.DeepEqual(sameRun, msg) {
+		r.messages[msg.UUID] = msg
+		result.Unchanged++
+		return
+	}
+	r.messages[msg.UUID] = msg
+	result.Modified++
+
+	added, removed := repositories.DiffTags(prev.Tags, msg.Tags)
+	if len(added) > 0 || len(removed) > 0 {
+		r.history = append(r.history, models.TagChange{
+			UUID:      msg.UUID,
+			MessageID: msg.MessageID,
+			Group:     msg.Group,
+			PrevTags:  prev.Tags,
+			Tags:      msg.Tags,
+			Added:     added,
+			Removed:   removed,
+			SeenAt:    now(),
+			RunID:     msg.RunID,
+		})
+	}
+}
+
+func (r *Repository) GetGroups(ctx context.Context) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var groups []string
+	for _, msg := range r.messages {
+		if !slices.Contains(groups, msg.Group) {
+			groups = append(groups, msg.Group)
+		}
+	}
+	slices.Sort(groups)
+	return groups, nil
+}
+
+// GetTagCountsByMediaKind counts messages per tag and media kind, every tag and kind is counted once per message
+func (r *Repository) GetTagCountsByMediaKind(ctx context.Context, group string) ([]repositories.TagMediaKindCount, error) {
+	type key struct{ tag, kind string }
+	counts := make(map[key]int)
+	for _, msg := range r.groupMessages(group) {
+		seen := make(map[key]bool)
+		for _, media := range msg.Media {
+			for _, tag := range msg.Tags {
+				k := key{tag, media.Kind}
+				if !seen[k] {
+					seen[k] = true
+					counts[k]++
+				}
+			}
+		}
+	}
+	var items []repositories.TagMediaKindCount
+	for k, count := range counts {
+		items = append(items, repositories.TagMediaKindCount{Tag: k.tag, Kind: k.kind, Count: count})
+	}
+	slices.SortFunc(items, func(a, b repositories.TagMediaKindCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Tag, b.Tag), strings.Compare(a.Kind, b.Kind))
+	})
+	return items, nil
+}
+
+// GetMostReactedTags sums reactions of all kinds on messages per tag, limit <= 0 means no limit
+func (r *Repository) GetMostReactedTags(ctx context.Context, group string, limit int) ([]repositories.TagReactions, error) {
+	totals := make(map[string]*repositories.TagReactions)
+	for _, msg := range r.groupMessages(group) {
+		if msg.Reactions == nil {
+			continue
+		}
+		total := 0
+		for _, count := range msg.Reactions {
+			total += count
+		}
+		for _, tag := range msg.Tags {
+			item, ok := totals[tag]
+			if !ok {
+				item = &repositories.TagReactions{Tag: tag}
+				totals[tag] = item
+			}
+			item.Reactions += total
+			item.Messages++
+		}
+	}
+	var items []repositories.TagReactions
+	for _, item := range totals {
+		items = append(items, *item)
+	}
+	slices.SortFunc(items, func(a, b repositories.TagReactions) int {
+		return cmp.Or(cmp.Compare(b.Reactions, a.Reactions), strings.Compare(a.Tag, b.Tag))
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// GetReplyChains returns threads of tagged messages replying to tagged messages of the same group
+func (r *Repository) GetReplyChains(ctx context.Context, group string) ([]repositories.ReplyChain, error) {
+	messages := r.groupMessages("")
+	byID := make(map[[2]string]*models.Message, len(messages))
+	for _, msg := range messages {
+		if len(msg.Tags) > 0 {
+			byID[[2]string{msg.Group, msg.MessageID}] = msg
+		}
+	}
+	var edges []repositories.ReplyEdge
+	for _, msg := range messages {
+		if (group != "" && msg.Group != group) || msg.ReplyTo == "" || len(msg.Tags) == 0 {
+			continue
+		}
+		parent, ok := byID[[2]string{msg.Group, msg.ReplyTo}]
+		if !ok {
+			continue
+		}
+		edges = append(edges, repositories.ReplyEdge{
+			Group:   msg.Group,
+			Message: repositories.ReplyChainItem{MessageID: msg.MessageID, Datetime: msg.Datetime, Tags: msg.Tags},
+			Parent:  repositories.ReplyChainItem{MessageID: parent.MessageID, Datetime: parent.Datetime, Tags: parent.Tags},
+		})
+	}
+	return repositories.BuildReplyChains(edges), nil
+}
+
+// GetEditedMessages returns edited messages one by one, the last edited first, the messages are copied before the iteration
+func (r *Repository) GetEditedMessages(ctx context.Context, group string) iter.Seq2[*models.Message, error] {
+	return func(yield func(*models.Message, error) bool) {
+		var items []*models.Message
+		for _, msg := range r.groupMessages(group) {
+			if msg.Edited != nil {
+				items = append(items, msg)
+			}
+		}
+		slices.SortFunc(items, func(a, b *models.Message) int {
+			return cmp.Or(b.Edited.Compare(*a.Edited), strings.Compare(a.UUID, b.UUID))
+		})
+		yieldAll(items, yield)
+	}
+}
+
+// groupMessages returns copies of messages that are not deleted, the empty group means all groups
+func (r *Repository) groupMessages(group string) []*models.Message {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var items []*models.Message
+	for _, msg := range r.messages {
+		if msg.DeletedAt == nil && (group == "" || msg.Group == group) {
+			items = append(items, copyMessage(msg))
+		}
+	}
+	slices.SortFunc(items, func(a, b *models.Message) int {
+		return compareIDs(a.ID, b.ID)
+	})
+	return items
+}
+
+// MarkDeleted sets deleted_at of the group's messages that are saved by runs other than keepRunIDs
+func (r *Repository) MarkDeleted(ctx context.Context, group string, keepRunIDs []string, deletedAt time.Time) (int, error) {
+	deletedAt = toMongoTime(deletedAt)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deleted := 0
+	for uuid, msg := range r.messages {
+		if msg.Group != group || msg.RunID == "" || slices.Contains(keepRunIDs, msg.RunID) || msg.DeletedAt != nil {
+			continue
+		}
+		msg.DeletedAt = &deletedAt
+		r.messages[uuid] = msg
+		deleted++
+	}
+	return deleted, nil
+}
+
+// MarkSeen sets run_id of the saved messages to the run that has found them in unchanged files, deleted_at is removed
+func (r *Repository) MarkSeen(ctx context.Context, uuids []string, runID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, uuid := range uuids {
+		msg, ok := r.messages[uuid]
+		if !ok {
+			continue
+		}
+		msg.RunID, msg.DeletedAt = runID, nil
+		r.messages[uuid] = msg
+	}
+	return nil
+}
+
+// UpdateTags sets raw and normalized tags of the message, the change of tags is added to the history without a run
+func (r *Repository) UpdateTags(ctx context.Context, uuid string, rawTags, tags []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, ok := r.messages[uuid]
+	if !ok {
+		return nil
+	}
+	if change, ok := repositories.NewTagChange(msg, slices.Clone(tags), "", now()); ok {
+		r.history = append(r.history, change)
+	}
+	msg.RawTags, msg.Tags = slices.Clone(rawTags), slices.Clone(tags)
+	r.messages[uuid] = msg
+	return nil
+}
+
+// UpsertServiceEvents saves service events by UUID
+func (r *Repository) UpsertServiceEvents(ctx context.Context, events []models.ServiceEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range events {
+		saved, err := clone(e)
+		if err != nil {
+			return fmt.Errorf("bulk write failed: %w", err)
+		}
+		saved.ID = primitive.NewObjectID()
+		if prev, ok := r.events[e.UUID]; ok {
+			saved.ID = prev.ID
+		}
+		r.events[e.UUID] = saved
+	}
+	return nil
+}
+
+// GetServiceEvents returns service events one by one in the order of time, no types means all types.
+// The events are copied before the iteration.
+func (r *Repository) GetServiceEvents(ctx context.Context, group string, types ...string) iter.Seq2[*models.ServiceEvent, error] {
+	return func(yield func(*models.ServiceEvent, error) bool) {
+		var items []*models.ServiceEvent
+		r.mu.RLock()
+		for _, e := range r.events {
+			if (group == "" || e.Group == group) && (len(types) == 0 || slices.Contains(types, e.Type)) {
+				items = append(items, &e)
+			}
+		}
+		r.mu.RUnlock()
+		slices.SortFunc(items, func(a, b *models.ServiceEvent) int {
+			return cmp.Or(a.Datetime.Compare(b.Datetime), strings.Compare(a.MessageID, b.MessageID))
+		})
+		yieldAll(items, yield)
+	}
+}
+
+// yieldAll yields the items until yield returns false
+func yieldAll[T any](items []*T, yield func(*T, error) bool) {
+	for _, item := range items {
+		if !yield(item, nil) {
+			return
+		}
+	}
+}
+
+// GetManifestEntry returns the saved export file, nil if the file has not been saved
+func (r *Repository) GetManifestEntry(ctx context.Context, path string) (*models.ManifestEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.manifest[path]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// UpsertManifestEntries saves export files by path
+func (r *Repository) UpsertManifestEntries(ctx context.Context, entries []models.ManifestEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range entries {
+		saved, err := clone(e)
+		if err != nil {
+			return fmt.Errorf("bulk write failed: %w", err)
+		}
+		r.manifest[e.Path] = saved
+	}
+	return nil
+}
+
+// SaveIngestRun saves the run by its ID
+func (r *Repository) SaveIngestRun(ctx context.Context, run models.IngestRun) error {
+	saved, err := clone(run)
+	if err != nil {
+		return fmt.Errorf("replace failed: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[run.RunID] = saved
+	return nil
+}
+
+// GetIngestRuns returns runs without their files, the last started first, limit <= 0 means no limit
+func (r *Repository) GetIngestRuns(ctx context.Context, limit int) ([]*models.IngestRun, error) {
+	r.mu.RLock()
+	var items []*models.IngestRun
+	for _, run := range r.runs {
+		run.Files = nil
+		run.SkipReasons = slices.Clone(run.SkipReasons)
+		items = append(items, &run)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(items, func(a, b *models.IngestRun) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// GetIngestRun returns the run with its files, nil if there is no such run
+func (r *Repository) GetIngestRun(ctx context.Context, runID string) (*models.IngestRun, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	run, ok := r.runs[runID]
+	if !ok {
+		return nil, nil
+	}
+	return clonePtr(run)
+}
+
+// GetTagChanges returns tag changes of the group where the tag is added or removed, the last seen first
+func (r *Repository) GetTagChanges(ctx context.Context, group, tag string, limit int) ([]*models.TagChange, error) {
+	r.mu.RLock()
+	var items []*models.TagChange
+	for _, change := range r.history {
+		if group != "" && change.Group != group {
+			continue
+		}
+		if tag != "" && !slices.Contains(change.Added, tag) && !slices.Contains(change.Removed, tag) {
+			continue
+		}
+		items = append(items, &change)
+	}
+	r.mu.RUnlock()
+	// the history is in the order of saving, the last saved change of the same time goes first
+	slices.Reverse(items)
+	slices.SortStableFunc(items, func(a, b *models.TagChange) int {
+		return b.SeenAt.Compare(a.SeenAt)
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// clone copies the value through BSON, so that it is the same as the value read from MongoDB
+func clone[T any](v T) (T, error) {
+	var result T
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return result, err
+	}
+	err = bson.Unmarshal(data, &result)
+	return result, err
+}
+
+func clonePtr[T any](v T) (*T, error) {
+	result, err := clone(v)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// copyMessage returns the deep copy of the saved message, so that callers don't share its slices
+func copyMessage(msg models.Message) *models.Message {
+	msg.Tags = slices.Clone(msg.Tags)
+	msg.RawTags = slices.Clone(msg.RawTags)
+	msg.Mentions = slices.Clone(msg.Mentions)
+	msg.Cashtags = slices.Clone(msg.Cashtags)
+	msg.URLs = slices.Clone(msg.URLs)
+	msg.BotCommands = slices.Clone(msg.BotCommands)
+	msg.Media = slices.Clone(msg.Media)
+	msg.Reactions = maps.Clone(msg.Reactions)
+	msg.Edited = clonePtrTime(msg.Edited)
+	msg.DeletedAt = clonePtrTime(msg.DeletedAt)
+	if msg.Forward != nil {
+		forward := *msg.Forward
+		msg.Forward = &forward
+	}
+	return &msg
+}
+
+func clonePtrTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	result := *t
+	return &result
+}
+
+// compareIDs is the order of insertion, ObjectIDs grow with it
+func compareIDs(a, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// toMongoTime is the time as MongoDB keeps it
+func toMongoTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+func now() time.Time {
+	return toMongoTime(time.Now())
+}
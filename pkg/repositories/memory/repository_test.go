@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+	"github.com/meesooqa/tgtag/pkg/repositories/repotest"
+)
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, messages ...models.Message) repositories.Repository {
+		repo := NewRepository()
+		for _, msg := range messages {
+			require.NoError(t, repo.Save(msg))
+		}
+		return repo
+	})
+}
+
+func TestRepository_LoadFixturesFile(t *testing.T) {
+	repo := NewRepository()
+	require.NoError(t, repo.LoadFixturesFile("testdata/messages.jsonl"))
+	ctx := context.Background()
+
+	groups, err := repo.GetGroups(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"g1", "g2"}, groups)
+
+	var found []*models.Message
+	for msg, err := range repo.FindMessages(ctx, repositories.MessageQuery{Groups: []string{"g1"}, IncludeDeleted: true}) {
+		require.NoError(t, err)
+		found = append(found, msg)
+	}
+	require.Len(t, found, 2)
+	assert.Equal(t, "message1", found[0].MessageID)
+	assert.Equal(t, time.Date(2024, time.November, 21, 16, 20, 37, 0, time.UTC), found[0].Datetime, "Время в UTC, как из MongoDB")
+	assert.Equal(t, []string{"booba", "shy"}, found[0].Tags)
+	assert.Equal(t, "run1", found[1].RunID)
+	require.NotNil(t, found[1].DeletedAt, "Фикстуры сохраняются как есть")
+
+	err = repo.LoadFixtures(strings.NewReader(`{"messageID":"message1"}`))
+	assert.ErrorContains(t, err, "line 1: message without uuid")
+	err = repo.LoadFixturesFile("testdata/missing.jsonl")
+	assert.Error(t, err)
+}
+
+// TestRepository_Concurrent проверяет работу с репозиторием из нескольких горутин, запускается с -race
+func TestRepository_Concurrent(t *testing.T) {
+	repo := NewRepository()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			messagesChan := make(chan models.Message)
+			go func() {
+				defer close(messagesChan)
+				for j := 0; j < 50; j++ {
+					messagesChan <- models.Message{UUID: string(rune('a' + j)), Group: "g1", Tags: []string{"booba"}}
+				}
+			}()
+			_, err := repo.UpsertMany(messagesChan)
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			for msg, err := range repo.FindMessages(ctx, repositories.MessageQuery{Groups: []string{"g1"}}) {
+				assert.NoError(t, err)
+				msg.Tags[0] = "changed"
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := repo.CountMessages(ctx, repositories.MessageQuery{Tags: []string{"booba"}})
+	require.NoError(t, err)
+	assert.Equal(t, 50, count, "Сообщения сохраняются по UUID, копии не меняют сохранённые")
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// GetTagCounts counts messages per group and tag: groups in order, the most used tags of a group first
+func (r *Repository) GetTagCounts(ctx context.Context, group string) ([]repositories.TagCount, error) {
+	type key struct{ group, tag string }
+	counts := make(map[key]int)
+	for _, msg := range r.groupMessages(group) {
+		for _, tag := range msg.Tags {
+			counts[key{msg.Group, tag}]++
+		}
+	}
+	var items []repositories.TagCount
+	for k, count := range counts {
+		items = append(items, repositories.TagCount{Group: k.group, Tag: k.tag, Count: count})
+	}
+	slices.SortFunc(items, func(a, b repositories.TagCount) int {
+		return cmp.Or(strings.Compare(a.Group, b.Group), cmp.Compare(b.Count, a.Count), strings.Compare(a.Tag, b.Tag))
+	})
+	return items, nil
+}
+
+// GetTagCountsByPeriod counts messages per tag and period in loc, UTC if loc is nil, the oldest period first
+func (r *Repository) GetTagCountsByPeriod(ctx context.Context, group string, period repositories.Period, loc *time.Location) ([]repositories.TagPeriodCount, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	if err := period.Validate(loc); err != nil {
+		return nil, err
+	}
+	type key struct {
+		tag   string
+		start time.Time
+	}
+	counts := make(map[key]int)
+	for _, msg := range r.groupMessages(group) {
+		start := period.Truncate(msg.Datetime, loc)
+		for _, tag := range msg.Tags {
+			counts[key{tag, start}]++
+		}
+	}
+	var items []repositories.TagPeriodCount
+	for k, count := range counts {
+		items = append(items, repositories.TagPeriodCount{Tag: k.tag, Start: k.start, Count: count})
+	}
+	slices.SortFunc(items, func(a, b repositories.TagPeriodCount) int {
+		return cmp.Or(a.Start.Compare(b.Start), strings.Compare(a.Tag, b.Tag))
+	})
+	return items, nil
+}
+
+// GetTagsHistogram counts messages per number of their tags, messages without tags are counted too
+func (r *Repository) GetTagsHistogram(ctx context.Context, group string) ([]repositories.TagsHistogramBucket, error) {
+	counts := make(map[int]int)
+	for _, msg := range r.groupMessages(group) {
+		counts[len(msg.Tags)]++
+	}
+	var items []repositories.TagsHistogramBucket
+	for tags, messages := range counts {
+		items = append(items, repositories.TagsHistogramBucket{Tags: tags, Messages: messages})
+	}
+	slices.SortFunc(items, func(a, b repositories.TagsHistogramBucket) int {
+		return cmp.Compare(a.Tags, b.Tags)
+	})
+	return items, nil
+}
+
+// GetTagSpans returns the first and the last message datetime per tag, in the order of tags
+func (r *Repository) GetTagSpans(ctx context.Context, group string) ([]repositories.TagSpan, error) {
+	spans := make(map[string]*repositories.TagSpan)
+	for _, msg := range r.groupMessages(group) {
+		for _, tag := range msg.Tags {
+			span, ok := spans[tag]
+			if !ok {
+				span = &repositories.TagSpan{Tag: tag, FirstSeen: msg.Datetime, LastSeen: msg.Datetime}
+				spans[tag] = span
+			}
+			if msg.Datetime.Before(span.FirstSeen) {
+				span.FirstSeen = msg.Datetime
+			}
+			if msg.Datetime.After(span.LastSeen) {
+				span.LastSeen = msg.Datetime
+			}
+			span.Messages++
+		}
+	}
+	var items []repositories.TagSpan
+	for _, span := range spans {
+		items = append(items, *span)
+	}
+	slices.SortFunc(items, func(a, b repositories.TagSpan) int {
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	return items, nil
+}
@@ -1,9 +1,15 @@
 package repositories
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
+	"os"
+	"slices"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,41 +23,175 @@ import (
 type MessageRepository struct {
 	log        *slog.Logger
 	collection *mongo.Collection
+	events     *mongo.Collection
+	manifest   *mongo.Collection
+	runs       *mongo.Collection
+	history    *mongo.Collection
+	// batchSize, bufferSize and flushPeriod of the saver, defaults if not set
+	batchSize   int
+	bufferSize  int
+	flushPeriod time.Duration
+	// maxRetries, retryBackoff and deadLetterPath handle write errors, see config.MongoConfig
+	maxRetries     *int
+	retryBackoff   time.Duration
+	deadLetterPath string
 }
 
 func NewMessageRepository(log *slog.Logger, db *db.MongoDB) *MessageRepository {
 	return &MessageRepository{
-		log:        log,
-		collection: db.GetCollectionMessages(),
+		log:            log,
+		collection:     db.GetCollectionMessages(),
+		events:         db.GetCollectionServiceEvents(),
+		manifest:       db.GetCollectionManifest(),
+		runs:           db.GetCollectionIngestRuns(),
+		history:        db.GetCollectionTagHistory(),
+		batchSize:      db.Conf.BatchSize,
+		bufferSize:     db.Conf.BufferSize,
+		flushPeriod:    db.Conf.FlushPeriod,
+		maxRetries:     db.Conf.MaxRetries,
+		retryBackoff:   db.Conf.RetryBackoff,
+		deadLetterPath: db.Conf.DeadLetterPath,
 	}
 }
 
-func (r *MessageRepository) UpsertMany(messagesChan <-chan models.Message) {
-	batchSize := 10
-	flushPeriod := 2 // Seconds
+func (r *MessageRepository) newSaver() *saver {
+	maxRetries := 3
+	if r.maxRetries != nil {
+		maxRetries = *r.maxRetries
+	}
+	opts := []saverOption{withRetries(maxRetries, cmp.Or(r.retryBackoff, 500*time.Millisecond))}
+	if r.deadLetterPath != "" {
+		opts = append(opts, withDeadLetter(newDeadLetterFile(r.deadLetterPath)))
+	}
+	if r.history != nil {
+		opts = append(opts, withTagHistory(r))
+	}
+	return newSaver(r.log, r.collection, cmp.Or(r.batchSize, 10), cmp.Or(r.flushPeriod, 2*time.Second), cmp.Or(r.bufferSize, 50), opts...)
+}
+
+// UpsertMany saves messages by UUID until messagesChan is closed, then writes the rest and returns the result
+func (r *MessageRepository) UpsertMany(messagesChan <-chan models.Message) (IngestResult, error) {
+	s := r.newSaver()
+	for msg := range messagesChan {
+		doc := bson.M{
+			"message_id":   msg.MessageID,
+			"datetime":     msg.Datetime,
+			"tz_offset":    msg.TZOffset,
+			"edited":       msg.Edited,
+			"group":        msg.Group,
+			"group_title":  msg.GroupTitle,
+			"uuid":         msg.UUID,
+			"from":         msg.From,
+			"text":         msg.Text,
+			"tags":         msg.Tags,
+			"raw_tags":     msg.RawTags,
+			"mentions":     msg.Mentions,
+			"cashtags":     msg.Cashtags,
+			"urls":         msg.URLs,
+			"bot_commands": msg.BotCommands,
+			"media":        msg.Media,
+			"reply_to":     msg.ReplyTo,
+			"forward":      msg.Forward,
+			"reactions":    msg.Reactions,
+			"run_id":       msg.RunID,
+		}
+		// HTML exports have no channel ID, the ID saved from a JSON export of the group is kept
+		if msg.ChannelID != 0 {
+			doc["channel_id"] = msg.ChannelID
+		}
+		if err := s.Save(doc); err != nil {
+			r.log.Error("Saver error", "err", err)
+		}
+	}
+	result, err := s.Close()
+	r.log.Debug("messages saved to MongoDB", "inserted", result.Inserted, "modified", result.Modified,
+		"unchanged", result.Unchanged, "failed", result.Failed)
+	return result, err
+}
+
+// ReplayDeadLetters saves messages of the dead-letter file again.
+// The file is renamed while it is replayed, messages failing again are written to the dead-letter file of the config.
+// Without the dead-letter file in the config the file is kept if some messages fail again, saved messages are replayed as unchanged.
+func (r *MessageRepository) ReplayDeadLetters(path string) (IngestResult, error) {
+	replaying := path + ".replaying"
+	if _, err := os.Stat(replaying); err == nil {
+		return IngestResult{}, fmt.Errorf("previous replay was interrupted, check %s and rename it to %s", replaying, path)
+	}
+	if err := os.Rename(path, replaying); err != nil {
+		return IngestResult{}, err
+	}
+	docs, err := readDeadLetters(replaying)
+	if err != nil {
+		return IngestResult{}, errors.Join(fmt.Errorf("read failed: %w", err), os.Rename(replaying, path))
+	}
+
+	s := r.newSaver()
+	for _, doc := range docs {
+		if err := s.Save(doc); err != nil {
+			r.log.Error("Saver error", "err", err)
+		}
+	}
+	result, err := s.Close()
+	if result.Failed > 0 && r.deadLetterPath == "" {
+		return result, errors.Join(err, os.Rename(replaying, path))
+	}
+	return result, errors.Join(err, os.Remove(replaying))
+}
 
-	s := newSaver(r.log, r.collection, batchSize, time.Duration(flushPeriod)*time.Second, 50)
-	go func() {
-		for msg := range messagesChan {
-			doc := bson.M{
-				"message_id": msg.MessageID,
-				"datetime":   msg.Datetime,
-				"group":      msg.Group,
-				"uuid":       msg.UUID,
-				"tags":       msg.Tags,
+// FindMessages returns messages of the query one by one, the iteration stops at the first error
+func (r *MessageRepository) FindMessages(ctx context.Context, q MessageQuery) iter.Seq2[*models.Message, error] {
+	filter, err := messageFilter(q)
+	if err != nil {
+		return func(yield func(*models.Message, error) bool) {
+			yield(nil, err)
+		}
+	}
+	return find[models.Message](ctx, r.collection, filter, messageFindOptions(q))
+}
+
+// find returns documents of the filter one by one, the iteration stops at the first error
+func find[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			yield(nil, fmt.Errorf("find failed: %w", err))
+			return
+		}
+		defer cursor.Close(ctx)
+		for cursor.Next(ctx) {
+			var item T
+			if err := cursor.Decode(&item); err != nil {
+				yield(nil, err)
+				return
 			}
-			if err := s.Save(doc); err != nil {
-				r.log.Error("Saver error", "err", err)
+			if !yield(&item, nil) {
+				return
 			}
 		}
-	}()
-	time.Sleep(time.Duration(flushPeriod+1) * time.Second) // wait flushPeriod
-	s.Close()
-	r.log.Debug("all data has been successfully saved to MongoDB")
+		if err := cursor.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
 }
 
+// CountMessages counts messages of the query, Sort, Limit and Cursor are ignored
+func (r *MessageRepository) CountMessages(ctx context.Context, q MessageQuery) (int, error) {
+	q.Cursor = ""
+	filter, err := messageFilter(q)
+	if err != nil {
+		return 0, err
+	}
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("count failed: %w", err)
+	}
+	return int(count), nil
+}
+
+// Find skips deleted messages unless the filter has a deleted_at condition, see WithDeleted.
+// It is kept for extensions written against MongoDB, new code uses FindMessages.
 func (r *MessageRepository) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]*models.Message, error) {
-	cursor, err := r.collection.Find(ctx, filter, opts...)
+	cursor, err := r.collection.Find(ctx, MatchDeleted(filter), opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +214,224 @@ func (r *MessageRepository) GetGroups(ctx context.Context) ([]string, error) {
 	return r.getUniqueValues(ctx, "group")
 }
 
+// GetTagCountsByMediaKind counts messages per tag and media kind, every tag and kind is counted once per message
+func (r *MessageRepository) GetTagCountsByMediaKind(ctx context.Context, group string) ([]TagMediaKindCount, error) {
+	cursor, err := r.collection.Aggregate(ctx, tagCountsByMediaKindPipeline(group))
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var items []TagMediaKindCount
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetMostReactedTags sums reactions of all kinds on messages per tag, limit <= 0 means no limit
+func (r *MessageRepository) GetMostReactedTags(ctx context.Context, group string, limit int) ([]TagReactions, error) {
+	cursor, err := r.collection.Aggregate(ctx, mostReactedTagsPipeline(group, limit))
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var items []TagReactions
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetReplyChains returns threads of tagged messages replying to tagged messages of the same group
+func (r *MessageRepository) GetReplyChains(ctx context.Context, group string) ([]ReplyChain, error) {
+	cursor, err := r.collection.Aggregate(ctx, replyEdgesPipeline(r.collection.Name(), group))
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var edges []ReplyEdge
+	if err := cursor.All(ctx, &edges); err != nil {
+		return nil, err
+	}
+	return BuildReplyChains(edges), nil
+}
+
+// GetEditedMessages returns edited messages one by one, the last edited first, the iteration stops at the first error
+func (r *MessageRepository) GetEditedMessages(ctx context.Context, group string) iter.Seq2[*models.Message, error] {
+	filter := MatchDeleted(matchGroup(group, bson.M{"edited": bson.M{"$ne": nil}}))
+	opts := options.Find().SetSort(bson.D{{Key: "edited", Value: -1}, {Key: "uuid", Value: 1}})
+	return find[models.Message](ctx, r.collection, filter, opts)
+}
+
+// MarkDeleted sets deleted_at of the group's messages that are saved by runs other than keepRunIDs.
+// Messages without run_id are saved before runs were recorded, they are not marked.
+func (r *MessageRepository) MarkDeleted(ctx context.Context, group string, keepRunIDs []string, deletedAt time.Time) (int, error) {
+	filter := bson.M{
+		"group":      group,
+		"run_id":     bson.M{"$exists": true, "$nin": keepRunIDs},
+		"deleted_at": bson.M{"$exists": false},
+	}
+	result, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"deleted_at": deletedAt}})
+	if err != nil {
+		return 0, fmt.Errorf("update failed: %w", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// markSeenBatchSize is the number of UUIDs updated by one MarkSeen query
+const markSeenBatchSize = 1000
+
+// MarkSeen sets run_id of the saved messages to the run that has found them in unchanged files, deleted_at is removed.
+// UUIDs are updated in batches, a file may have a lot of messages.
+func (r *MessageRepository) MarkSeen(ctx context.Context, uuids []string, runID string) error {
+	for batch := range slices.Chunk(uuids, markSeenBatchSize) {
+		update := bson.M{"$set": bson.M{"run_id": runID}, "$unset": bson.M{"deleted_at": ""}}
+		if _, err := r.collection.UpdateMany(ctx, bson.M{"uuid": bson.M{"$in": batch}}, update); err != nil {
+			return fmt.Errorf("update failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// UpdateTags sets raw and normalized tags of the message, the change of tags is added to the history without a run
+func (r *MessageRepository) UpdateTags(ctx context.Context, uuid string, rawTags, tags []string) error {
+	opts := options.FindOneAndUpdate().
+		SetProjection(bson.M{"uuid": 1, "message_id": 1, "group": 1, "tags": 1}).
+		SetReturnDocument(options.Before)
+	var prev models.Message
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"uuid": uuid}, bson.M{"$set": bson.M{"raw_tags": rawTags, "tags": tags}}, opts).Decode(&prev)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	change, ok := NewTagChange(prev, tags, "", time.Now().UTC())
+	if !ok {
+		return nil
+	}
+	if err := r.saveTagChanges(ctx, []models.TagChange{change}); err != nil {
+		return fmt.Errorf("tag history is not saved: %w", err)
+	}
+	return nil
+}
+
+// UpsertServiceEvents saves service events by UUID
+func (r *MessageRepository) UpsertServiceEvents(ctx context.Context, events []models.ServiceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	writeModels := make([]mongo.WriteModel, 0, len(events))
+	for _, e := range events {
+		writeModels = append(writeModels, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"uuid": e.UUID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"message_id":        e.MessageID,
+				"group":             e.Group,
+				"group_title":       e.GroupTitle,
+				"channel_id":        e.ChannelID,
+				"type":              e.Type,
+				"datetime":          e.Datetime,
+				"actor":             e.Actor,
+				"text":              e.Text,
+				"action":            e.Action,
+				"title":             e.Title,
+				"pinned_message_id": e.PinnedMessageID,
+			}, "$setOnInsert": bson.M{"uuid": e.UUID}}).
+			SetUpsert(true))
+	}
+	_, err := r.events.BulkWrite(ctx, writeModels, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("bulk write failed: %w", err)
+	}
+	return nil
+}
+
+// GetServiceEvents returns service events one by one in the order of time, no types means all types.
+// The iteration stops at the first error.
+func (r *MessageRepository) GetServiceEvents(ctx context.Context, group string, types ...string) iter.Seq2[*models.ServiceEvent, error] {
+	filter := matchGroup(group, bson.M{})
+	if len(types) > 0 {
+		filter["type"] = bson.M{"$in": types}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "datetime", Value: 1}, {Key: "message_id", Value: 1}})
+	return find[models.ServiceEvent](ctx, r.events, filter, opts)
+}
+
+// GetManifestEntry returns the saved export file, nil if the file has not been saved
+func (r *MessageRepository) GetManifestEntry(ctx context.Context, path string) (*models.ManifestEntry, error) {
+	var entry models.ManifestEntry
+	err := r.manifest.FindOne(ctx, bson.M{"path": path}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// UpsertManifestEntries saves export files by path
+func (r *MessageRepository) UpsertManifestEntries(ctx context.Context, entries []models.ManifestEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	writeModels := make([]mongo.WriteModel, 0, len(entries))
+	for _, e := range entries {
+		writeModels = append(writeModels, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"path": e.Path}).
+			SetReplacement(e).
+			SetUpsert(true))
+	}
+	_, err := r.manifest.BulkWrite(ctx, writeModels, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("bulk write failed: %w", err)
+	}
+	return nil
+}
+
+// SaveIngestRun saves the run by its ID, it is saved at the start and at the end of the run
+func (r *MessageRepository) SaveIngestRun(ctx context.Context, run models.IngestRun) error {
+	_, err := r.runs.ReplaceOne(ctx, bson.M{"run_id": run.RunID}, run, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("replace failed: %w", err)
+	}
+	return nil
+}
+
+// GetIngestRuns returns runs without their files, the last started first, limit <= 0 means no limit
+func (r *MessageRepository) GetIngestRuns(ctx context.Context, limit int) ([]*models.IngestRun, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "started_at", Value: -1}}).
+		SetProjection(bson.M{"files": 0})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.runs.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var items []*models.IngestRun
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetIngestRun returns the run with its files, nil if there is no such run
+func (r *MessageRepository) GetIngestRun(ctx context.Context, runID string) (*models.IngestRun, error) {
+	var run models.IngestRun
+	err := r.runs.FindOne(ctx, bson.M{"run_id": runID}).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
 func (r *MessageRepository) getUniqueValues(ctx context.Context, fieldName string) ([]string, error) {
 	values, err := r.collection.Distinct(ctx, fieldName, bson.D{})
 	if err != nil {
@@ -82,6 +440,187 @@ func (r *MessageRepository) getUniqueValues(ctx context.Context, fieldName strin
 	return convertToStrings(values), nil
 }
 
+func tagCountsByMediaKindPipeline(group string) mongo.Pipeline {
+	return mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{}))}},
+		bson.D{{Key: "$project", Value: bson.M{
+			// повторённый тег и вид считаются один раз на сообщение
+			"tags":  bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}, bson.A{}}},
+			"kinds": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$media.kind", bson.A{}}}, bson.A{}}},
+		}}},
+		bson.D{{Key: "$unwind", Value: "$kinds"}},
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"tag": "$tags", "kind": "$kinds"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":   0,
+			"tag":   "$_id.tag",
+			"kind":  "$_id.kind",
+			"count": 1,
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "tag", Value: 1}, {Key: "kind", Value: 1}}}},
+	}
+}
+
+func mostReactedTagsPipeline(group string, limit int) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{"reactions": bson.M{"$type": "object"}}))}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"tags": 1,
+			"total": bson.M{"$sum": bson.M{"$map": bson.M{
+				"input": bson.M{"$objectToArray": "$reactions"},
+				"in":    "$$this.v",
+			}}},
+		}}},
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":       "$tags",
+			"reactions": bson.M{"$sum": "$total"},
+			"messages":  bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "tag": "$_id", "reactions": 1, "messages": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "reactions", Value: -1}, {Key: "tag", Value: 1}}}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	return pipeline
+}
+
+// ReplyEdge is a tagged message with the tagged message it replies to
+type ReplyEdge struct {
+	Group   string         `bson:"group"`
+	Message ReplyChainItem `bson:"message"`
+	Parent  ReplyChainItem `bson:"parent"`
+}
+
+func replyEdgesPipeline(collectionName, group string) mongo.Pipeline {
+	tagged := bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}}, 0}}
+	return mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{
+			"reply_to": bson.M{"$exists": true, "$ne": ""},
+			"tags.0":   bson.M{"$exists": true},
+		}))}},
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from": collectionName,
+			"let":  bson.M{"group": "$group", "reply_to": "$reply_to"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$group", "$$group"}},
+					bson.M{"$eq": bson.A{"$message_id", "$$reply_to"}},
+					tagged,
+				}}}},
+				bson.M{"$match": bson.M{"deleted_at": bson.M{"$exists": false}}},
+				bson.M{"$project": bson.M{"_id": 0, "message_id": 1, "datetime": 1, "tags": 1}},
+			},
+			"as": "parent",
+		}}},
+		bson.D{{Key: "$unwind", Value: "$parent"}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":   0,
+			"group": 1,
+			"message": bson.M{
+				"message_id": "$message_id",
+				"datetime":   "$datetime",
+				"tags":       "$tags",
+			},
+			"parent": 1,
+		}}},
+	}
+}
+
+// BuildReplyChains joins reply edges into chains from a root message to every last reply of its thread
+func BuildReplyChains(edges []ReplyEdge) []ReplyChain {
+	type key struct{ group, id string }
+	nodes := make(map[key]ReplyChainItem)
+	children := make(map[key][]key)
+	isReply := make(map[key]bool)
+	for _, e := range edges {
+		child := key{e.Group, e.Message.MessageID}
+		parent := key{e.Group, e.Parent.MessageID}
+		nodes[child] = e.Message
+		nodes[parent] = e.Parent
+		children[parent] = append(children[parent], child)
+		isReply[child] = true
+	}
+
+	var chains []ReplyChain
+	var walk func(k key, path []ReplyChainItem, visited map[key]bool)
+	walk = func(k key, path []ReplyChainItem, visited map[key]bool) {
+		path = append(path, nodes[k])
+		visited[k] = true
+		defer delete(visited, k)
+
+		next := children[k]
+		sort.Slice(next, func(i, j int) bool { return nodes[next[i]].Datetime.Before(nodes[next[j]].Datetime) })
+		leaf := true
+		for _, c := range next {
+			if visited[c] {
+				continue
+			}
+			leaf = false
+			walk(c, path, visited)
+		}
+		if leaf && len(path) > 1 {
+			chains = append(chains, ReplyChain{Group: k.group, Messages: append([]ReplyChainItem(nil), path...)})
+		}
+	}
+
+	var roots []key
+	for k := range children {
+		if !isReply[k] {
+			roots = append(roots, k)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if roots[i].group != roots[j].group {
+			return roots[i].group < roots[j].group
+		}
+		return nodes[roots[i]].Datetime.Before(nodes[roots[j]].Datetime)
+	})
+	for _, root := range roots {
+		walk(root, nil, make(map[key]bool))
+	}
+	return chains
+}
+
+// matchGroup adds the group condition to the filter, the empty group means all groups
+func matchGroup(group string, filter bson.M) bson.M {
+	if group != "" {
+		filter["group"] = group
+	}
+	return filter
+}
+
+// includeDeleted is the deleted_at value of WithDeleted, it is removed from the filter
+type includeDeleted struct{}
+
+// WithDeleted makes Find return deleted messages too
+func WithDeleted(filter bson.M) bson.M {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	filter["deleted_at"] = includeDeleted{}
+	return filter
+}
+
+// MatchDeleted returns the copy of the filter skipping deleted messages, if the filter has no deleted_at condition
+func MatchDeleted(filter bson.M) bson.M {
+	result := make(bson.M, len(filter)+1)
+	for k, v := range filter {
+		result[k] = v
+	}
+	switch result["deleted_at"].(type) {
+	case nil:
+		result["deleted_at"] = bson.M{"$exists": false}
+	case includeDeleted:
+		delete(result, "deleted_at")
+	}
+	return result
+}
+
 func convertToStrings(values []interface{}) []string {
 	result := make([]string, 0, len(values))
 	for _, v := range values {
@@ -0,0 +1,476 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// NewIntegrationRepository открывает newIntegrationRepository тестам пакета repositories_test
+var NewIntegrationRepository = newIntegrationRepository
+
+// newIntegrationRepository подключается к MongoDB из TestMain и возвращает репозиторий на пустой коллекции.
+func newIntegrationRepository(t *testing.T, docs ...any) *MessageRepository {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Disconnect(ctx) })
+
+	name := strings.ReplaceAll(t.Name(), "/", "_")
+	collection := client.Database("testdb").Collection("messages_" + name)
+	require.NoError(t, collection.Drop(ctx))
+	events := client.Database("testdb").Collection("service_events_" + name)
+	require.NoError(t, events.Drop(ctx))
+	manifest := client.Database("testdb").Collection("manifest_" + name)
+	require.NoError(t, manifest.Drop(ctx))
+	runs := client.Database("testdb").Collection("ingest_runs_" + name)
+	require.NoError(t, runs.Drop(ctx))
+	history := client.Database("testdb").Collection("tag_history_" + name)
+	require.NoError(t, history.Drop(ctx))
+	if len(docs) > 0 {
+		_, err = collection.InsertMany(ctx, docs)
+		require.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	return &MessageRepository{log: logger, collection: collection, events: events, manifest: manifest, runs: runs, history: history}
+}
+
+// SaveIntegrationMessages сохраняет сообщения через UpsertMany, как cmd/save, и помечает удалёнными сообщения с DeletedAt
+func SaveIntegrationMessages(t *testing.T, repo *MessageRepository, messages ...models.Message) {
+	messagesChan := make(chan models.Message, len(messages))
+	for _, msg := range messages {
+		messagesChan <- msg
+	}
+	close(messagesChan)
+	_, err := repo.UpsertMany(messagesChan)
+	require.NoError(t, err)
+	for _, msg := range messages {
+		if msg.DeletedAt != nil {
+			_, err := repo.collection.UpdateOne(context.Background(), bson.M{"uuid": msg.UUID}, bson.M{"$set": bson.M{"deleted_at": *msg.DeletedAt}})
+			require.NoError(t, err)
+		}
+	}
+}
+
+// collect returns all items of the iterator, the test fails at the first error
+func collect[T any](t *testing.T, items iter.Seq2[T, error]) []T {
+	var result []T
+	for item, err := range items {
+		require.NoError(t, err)
+		result = append(result, item)
+	}
+	return result
+}
+
+func TestMessageRepository_GetTagCountsByMediaKind(t *testing.T) {
+	repo := newIntegrationRepository(t,
+		bson.M{"uuid": "1", "group": "g1", "tags": bson.A{"booba", "shy"}, "media": bson.A{
+			bson.M{"kind": "video"}, bson.M{"kind": "video"},
+		}},
+		bson.M{"uuid": "2", "group": "g1", "tags": bson.A{"booba"}, "media": bson.A{bson.M{"kind": "photo"}}},
+		bson.M{"uuid": "3", "group": "g1", "tags": bson.A{"booba"}},
+		bson.M{"uuid": "4", "group": "g2", "tags": bson.A{"booba"}, "media": bson.A{bson.M{"kind": "video"}}},
+	)
+
+	result, err := repo.GetTagCountsByMediaKind(context.Background(), "g1")
+	require.NoError(t, err)
+	assert.Equal(t, []TagMediaKindCount{
+		{Tag: "booba", Kind: "photo", Count: 1},
+		{Tag: "booba", Kind: "video", Count: 1},
+		{Tag: "shy", Kind: "video", Count: 1},
+	}, result)
+
+	result, err = repo.GetTagCountsByMediaKind(context.Background(), "")
+	require.NoError(t, err)
+	assert.Contains(t, result, TagMediaKindCount{Tag: "booba", Kind: "video", Count: 2})
+}
+
+func TestMessageRepository_GetMostReactedTags(t *testing.T) {
+	repo := newIntegrationRepository(t,
+		bson.M{"uuid": "1", "group": "g1", "tags": bson.A{"booba", "shy"}, "reactions": bson.M{"👍": 10, "❤": 2}},
+		bson.M{"uuid": "2", "group": "g1", "tags": bson.A{"booba"}, "reactions": bson.M{"👍": 3}},
+		bson.M{"uuid": "3", "group": "g1", "tags": bson.A{"stare"}},
+		bson.M{"uuid": "4", "group": "g2", "tags": bson.A{"shy"}, "reactions": bson.M{"👍": 100}},
+	)
+
+	result, err := repo.GetMostReactedTags(context.Background(), "g1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []TagReactions{
+		{Tag: "booba", Reactions: 15, Messages: 2},
+		{Tag: "shy", Reactions: 12, Messages: 1},
+	}, result)
+
+	result, err = repo.GetMostReactedTags(context.Background(), "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []TagReactions{{Tag: "shy", Reactions: 112, Messages: 2}}, result)
+}
+
+func TestMessageRepository_GetReplyChains(t *testing.T) {
+	now := time.Date(2024, time.November, 21, 19, 20, 37, 0, time.UTC)
+	repo := newIntegrationRepository(t,
+		bson.M{"uuid": "1", "group": "g1", "message_id": "message1", "datetime": now, "tags": bson.A{"booba"}},
+		bson.M{"uuid": "2", "group": "g1", "message_id": "message2", "datetime": now.Add(time.Minute), "tags": bson.A{"shy"}, "reply_to": "message1"},
+		bson.M{"uuid": "3", "group": "g1", "message_id": "message3", "datetime": now.Add(2 * time.Minute), "tags": bson.A{"stare"}, "reply_to": "message2"},
+		// ответ без тегов и ответ на сообщение без тегов не образуют цепочек
+		bson.M{"uuid": "4", "group": "g1", "message_id": "message4", "datetime": now.Add(3 * time.Minute), "reply_to": "message1"},
+		bson.M{"uuid": "5", "group": "g1", "message_id": "message5", "datetime": now.Add(4 * time.Minute), "tags": bson.A{"todo"}, "reply_to": "message4"},
+		// сообщение с тем же ID в другой группе
+		bson.M{"uuid": "6", "group": "g2", "message_id": "message7", "datetime": now, "tags": bson.A{"where"}, "reply_to": "message1"},
+	)
+
+	chains, err := repo.GetReplyChains(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, chains, 1)
+	assert.Equal(t, "g1", chains[0].Group)
+	var ids []string
+	for _, m := range chains[0].Messages {
+		ids = append(ids, m.MessageID)
+	}
+	assert.Equal(t, []string{"message1", "message2", "message3"}, ids)
+}
+
+func TestMessageRepository_GetEditedMessages(t *testing.T) {
+	now := time.Date(2024, time.November, 21, 19, 20, 37, 0, time.UTC)
+	repo := newIntegrationRepository(t,
+		bson.M{"uuid": "1", "group": "g1", "message_id": "message1", "datetime": now, "edited": now.Add(time.Hour)},
+		bson.M{"uuid": "2", "group": "g1", "message_id": "message2", "datetime": now, "edited": nil},
+		bson.M{"uuid": "3", "group": "g1", "message_id": "message3", "datetime": now, "edited": now.Add(2 * time.Hour)},
+		bson.M{"uuid": "4", "group": "g2", "message_id": "message4", "datetime": now, "edited": now},
+	)
+
+	messages := collect(t, repo.GetEditedMessages(context.Background(), "g1"))
+	require.Len(t, messages, 2)
+	assert.Equal(t, "message3", messages[0].MessageID, "Последнее отредактированное идёт первым")
+	assert.Equal(t, "message1", messages[1].MessageID)
+	require.NotNil(t, messages[1].Edited)
+	assert.True(t, now.Add(time.Hour).Equal(*messages[1].Edited))
+}
+
+func TestMessageRepository_ServiceEvents(t *testing.T) {
+	day := time.Date(2024, time.November, 21, 0, 0, 0, 0, time.UTC)
+	repo := newIntegrationRepository(t)
+	ctx := context.Background()
+
+	events := []models.ServiceEvent{
+		{UUID: "1", MessageID: "message-1", Group: "g1", Type: models.ServiceEventDate, Datetime: day},
+		{UUID: "2", MessageID: "message2", Group: "g1", Type: models.ServiceEventTitle, Datetime: day.Add(time.Hour), Title: "New"},
+		{UUID: "3", MessageID: "message3", Group: "g2", Type: models.ServiceEventPin, Datetime: day, PinnedMessageID: "message1"},
+	}
+	require.NoError(t, repo.UpsertServiceEvents(ctx, events))
+	// повторное сохранение не создаёт дубликатов
+	require.NoError(t, repo.UpsertServiceEvents(ctx, events))
+
+	result := collect(t, repo.GetServiceEvents(ctx, "g1"))
+	require.Len(t, result, 2)
+	assert.Equal(t, "message-1", result[0].MessageID)
+	assert.Equal(t, "New", result[1].Title)
+
+	result = collect(t, repo.GetServiceEvents(ctx, "", models.ServiceEventPin, models.ServiceEventTitle))
+	require.Len(t, result, 2)
+	assert.Equal(t, "message3", result[0].MessageID)
+}
+
+func TestMessageRepository_ManifestEntries(t *testing.T) {
+	repo := newIntegrationRepository(t)
+	ctx := context.Background()
+
+	entry, err := repo.GetManifestEntry(ctx, "var/data/g1/messages.html")
+	require.NoError(t, err)
+	assert.Nil(t, entry, "Несохранённого файла нет в манифесте")
+
+	saved := models.ManifestEntry{
+		Path:     "var/data/g1/messages.html",
+		Size:     100,
+		ModTime:  time.Date(2024, 11, 21, 16, 20, 37, 0, time.UTC),
+		Hash:     "hash1",
+		Messages: 5,
+		RunID:    "run1",
+	}
+	require.NoError(t, repo.UpsertManifestEntries(ctx, []models.ManifestEntry{saved}))
+	saved.Hash, saved.RunID = "hash2", "run2"
+	require.NoError(t, repo.UpsertManifestEntries(ctx, []models.ManifestEntry{saved}))
+
+	entry, err = repo.GetManifestEntry(ctx, "var/data/g1/messages.html")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, saved, *entry)
+	count, err := repo.manifest.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "Файл обновляется по пути")
+}
+
+func TestMessageRepository_UpsertMany_Result(t *testing.T) {
+	repo := newIntegrationRepository(t)
+	repo.batchSize, repo.flushPeriod = 2, time.Hour
+	messages := []models.Message{
+		{UUID: "1", Group: "g1", Tags: []string{"booba"}},
+		{UUID: "2", Group: "g1", Tags: []string{"shy"}},
+		{UUID: "3", Group: "g1"},
+	}
+	upsert := func(messages []models.Message) (IngestResult, error) {
+		messagesChan := make(chan models.Message, len(messages))
+		for _, msg := range messages {
+			messagesChan <- msg
+		}
+		close(messagesChan)
+		return repo.UpsertMany(messagesChan)
+	}
+
+	result, err := upsert(messages)
+	require.NoError(t, err)
+	assert.Equal(t, IngestResult{Inserted: 3}, result, "Все сообщения сохранены без ожидания flushPeriod")
+
+	messages[0].Tags = []string{"booba", "stare"}
+	result, err = upsert(messages)
+	require.NoError(t, err)
+	assert.Equal(t, IngestResult{Modified: 1, Unchanged: 2}, result)
+}
+
+func TestMessageRepository_UpsertMany_NewRun(t *testing.T) {
+	repo := newIntegrationRepository(t)
+	ctx := context.Background()
+	upsert := func(runID string) IngestResult {
+		messagesChan := make(chan models.Message, 2)
+		messagesChan <- models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"booba"}, RunID: runID}
+		messagesChan <- models.Message{UUID: "2", MessageID: "message2", Group: "g1", RunID: runID}
+		close(messagesChan)
+		result, err := repo.UpsertMany(messagesChan)
+		require.NoError(t, err)
+		return result
+	}
+
+	assert.Equal(t, IngestResult{Inserted: 2}, upsert("run1"))
+	assert.Equal(t, IngestResult{Unchanged: 2}, upsert("run2"), "Повторный разбор не изменяет сообщения")
+
+	result, err := repo.Find(ctx, bson.M{"run_id": "run2"})
+	require.NoError(t, err)
+	assert.Len(t, result, 2, "run_id обновлён новым запуском")
+	changes, err := repo.GetTagChanges(ctx, "", "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestMessageRepository_ReplayDeadLetters(t *testing.T) {
+	repo := newIntegrationRepository(t, bson.M{"uuid": "1", "message_id": "message1", "group": "g1"})
+	ctx := context.Background()
+	_, err := repo.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"message_id": 1}, Options: options.Index().SetUnique(true),
+	})
+	require.NoError(t, err)
+	noRetries := 0
+	repo.maxRetries, repo.flushPeriod = &noRetries, time.Hour
+	path := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	require.NoError(t, newDeadLetterFile(path).write([]bson.M{
+		{"uuid": "2", "message_id": "message2", "group": "g1"},
+		{"uuid": "3", "message_id": "message1", "group": "g1"},
+	}, errors.New("network")))
+
+	result, err := repo.ReplayDeadLetters(path)
+	assert.Error(t, err)
+	assert.Equal(t, IngestResult{Inserted: 1, Failed: 1}, result, "max_retries 0 не повторяет запись")
+	docs, err := readDeadLetters(path)
+	require.NoError(t, err)
+	assert.Len(t, docs, 2, "Без dead-letter файла в конфиге файл не удаляется")
+}
+
+func TestMessageRepository_IngestRuns(t *testing.T) {
+	repo := newIntegrationRepository(t)
+	ctx := context.Background()
+
+	run, err := repo.GetIngestRun(ctx, "run1")
+	require.NoError(t, err)
+	assert.Nil(t, run, "Несохранённого запуска нет")
+
+	started := time.Date(2024, 11, 21, 16, 0, 0, 0, time.UTC)
+	for i, id := range []string{"run1", "run2", "run3"} {
+		require.NoError(t, repo.SaveIngestRun(ctx, models.IngestRun{
+			RunID:     id,
+			StartedAt: started.Add(time.Duration(i) * time.Hour),
+			Files:     []models.IngestRunFile{{Path: "var/data/g1/messages.html", Status: models.IngestFileParsed}},
+		}))
+	}
+	saved := models.IngestRun{
+		RunID:            "run1",
+		StartedAt:        started,
+		FinishedAt:       started.Add(time.Minute),
+		ConfigHash:       "hash1",
+		FilesSeen:        2,
+		FilesSkipped:     1,
+		MessagesParsed:   5,
+		MessagesUpserted: 5,
+		SkipReasons:      []models.SkipReasonCount{{Reason: "no date", Count: 1}},
+		Files:            []models.IngestRunFile{{Path: "var/data/g1/messages.html", Status: models.IngestFileParsed, Messages: 5}},
+	}
+	require.NoError(t, repo.SaveIngestRun(ctx, saved))
+
+	run, err = repo.GetIngestRun(ctx, "run1")
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	assert.Equal(t, saved, *run, "Запуск обновляется по run_id")
+
+	runs, err := repo.GetIngestRuns(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "run3", runs[0].RunID, "Последние запуски первыми")
+	assert.Equal(t, "run2", runs[1].RunID)
+	assert.Nil(t, runs[0].Files, "Список не содержит файлов")
+}
+
+func TestMessageRepository_MarkDeleted(t *testing.T) {
+	repo := newIntegrationRepository(t,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", RunID: "run1"},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g1", RunID: "run2"},
+		models.Message{UUID: "3", MessageID: "message3", Group: "g1", RunID: "run3"},
+		models.Message{UUID: "4", MessageID: "message4", Group: "g1"},
+		models.Message{UUID: "5", MessageID: "message5", Group: "g2", RunID: "run1"},
+	)
+	ctx := context.Background()
+	deletedAt := time.Date(2024, 11, 21, 16, 20, 37, 0, time.UTC)
+
+	deleted, err := repo.MarkDeleted(ctx, "g1", []string{"run3", "run2"}, deletedAt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted, "Сообщения без run_id и других групп не помечаются")
+	deleted, err = repo.MarkDeleted(ctx, "g1", []string{"run3", "run2"}, deletedAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted, "Время удаления не меняется")
+
+	result, err := repo.Find(ctx, bson.M{"group": "g1"})
+	require.NoError(t, err)
+	assert.Len(t, result, 3, "Find пропускает удалённые сообщения")
+	result, err = repo.Find(ctx, bson.M{"deleted_at": bson.M{"$exists": true}})
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, "message1", result[0].MessageID)
+	require.NotNil(t, result[0].DeletedAt)
+	assert.Equal(t, deletedAt, *result[0].DeletedAt)
+	result, err = repo.Find(ctx, WithDeleted(bson.M{"group": "g1"}))
+	require.NoError(t, err)
+	assert.Len(t, result, 4)
+
+	messagesChan := make(chan models.Message, 1)
+	messagesChan <- models.Message{UUID: "1", MessageID: "message1", Group: "g1", RunID: "run4"}
+	close(messagesChan)
+	_, err = repo.UpsertMany(messagesChan)
+	require.NoError(t, err)
+	result, err = repo.Find(ctx, bson.M{"group": "g1"})
+	require.NoError(t, err)
+	assert.Len(t, result, 4, "Снова найденное сообщение не удалено")
+}
+
+func TestMessageRepository_TagChanges(t *testing.T) {
+	repo := newIntegrationRepository(t)
+	ctx := context.Background()
+	upsert := func(messages ...models.Message) {
+		messagesChan := make(chan models.Message, len(messages))
+		for _, msg := range messages {
+			messagesChan <- msg
+		}
+		close(messagesChan)
+		_, err := repo.UpsertMany(messagesChan)
+		require.NoError(t, err)
+	}
+
+	upsert(
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"booba"}, RunID: "run1"},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g2", Tags: []string{"booba"}, RunID: "run1"},
+	)
+	upsert(
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"booba"}, RunID: "run2"},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g2", Tags: []string{"shy"}, RunID: "run2"},
+	)
+	upsert(models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"booba", "stare"}, RunID: "run3"})
+
+	changes, err := repo.GetTagChanges(ctx, "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 2, "Новые сообщения и те же теги не попадают в историю")
+	assert.Equal(t, "run3", changes[0].RunID, "Последние изменения первыми")
+	assert.Equal(t, []string{"booba"}, changes[0].PrevTags)
+	assert.Equal(t, []string{"booba", "stare"}, changes[0].Tags)
+	assert.Equal(t, []string{"stare"}, changes[0].Added)
+	assert.Equal(t, []string{"booba"}, changes[1].Removed)
+
+	changes, err = repo.GetTagChanges(ctx, "g2", "", 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "message2", changes[0].MessageID)
+
+	changes, err = repo.GetTagChanges(ctx, "", "booba", 1)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "message2", changes[0].MessageID, "Тег удалён из сообщения")
+
+	changes, err = repo.GetTagChanges(ctx, "g1", "shy", 0)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestMessageRepository_FindMessages(t *testing.T) {
+	now := time.Date(2024, 11, 21, 16, 20, 37, 0, time.UTC)
+	deletedAt := now
+	repo := newIntegrationRepository(t,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Datetime: now, Tags: []string{"booba"}},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g1", Datetime: now, Tags: []string{"booba", "shy"}},
+		models.Message{UUID: "3", MessageID: "message3", Group: "g2", Datetime: now.Add(time.Hour), Tags: []string{"booba"}},
+		models.Message{UUID: "4", MessageID: "message4", Group: "g1", Datetime: now.Add(-time.Hour), Tags: []string{"stare"}},
+		models.Message{UUID: "5", MessageID: "message5", Group: "g1", Datetime: now, DeletedAt: &deletedAt},
+	)
+	ctx := context.Background()
+	find := func(q MessageQuery) []string {
+		var ids []string
+		for msg, err := range repo.FindMessages(ctx, q) {
+			require.NoError(t, err)
+			ids = append(ids, msg.MessageID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"message4", "message1", "message2", "message3"}, find(MessageQuery{}))
+	assert.Equal(t, []string{"message3", "message2", "message1", "message4"}, find(MessageQuery{Sort: SortNewest}))
+	assert.Equal(t, []string{"message1", "message2"}, find(MessageQuery{Groups: []string{"g1"}, Tags: []string{"booba"}}))
+	assert.Equal(t, []string{"message1", "message3"}, find(MessageQuery{Tags: []string{"booba"}, ExcludeTags: []string{"shy"}}))
+	assert.Equal(t, []string{"message1", "message2"}, find(MessageQuery{From: now, To: now.Add(time.Hour)}))
+	assert.Contains(t, find(MessageQuery{IncludeDeleted: true}), "message5")
+
+	// страницы по два сообщения
+	var pages [][]string
+	q := MessageQuery{Limit: 2, Sort: SortNewest}
+	for {
+		var page []string
+		var last *models.Message
+		for msg, err := range repo.FindMessages(ctx, q) {
+			require.NoError(t, err)
+			page = append(page, msg.MessageID)
+			last = msg
+		}
+		if last == nil {
+			break
+		}
+		pages = append(pages, page)
+		q.Cursor = NextCursor(last)
+	}
+	assert.Equal(t, [][]string{{"message3", "message2"}, {"message1", "message4"}}, pages)
+
+	count, err := repo.CountMessages(ctx, MessageQuery{Groups: []string{"g1", "g2"}, Limit: 1, Cursor: q.Cursor})
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	for _, err := range repo.FindMessages(ctx, MessageQuery{Cursor: "booba"}) {
+		assert.Error(t, err)
+	}
+}
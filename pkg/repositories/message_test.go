@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+func TestBuildReplyChains(t *testing.T) {
+	now := time.Date(2024, time.November, 21, 19, 20, 37, 0, time.UTC)
+	item := func(id string, minutes int, tags ...string) ReplyChainItem {
+		return ReplyChainItem{MessageID: id, Datetime: now.Add(time.Duration(minutes) * time.Minute), Tags: tags}
+	}
+	m1, m2, m3, m4 := item("m1", 0, "a"), item("m2", 1, "b"), item("m3", 2, "c"), item("m4", 3, "d")
+	other1, other2 := item("m1", 0, "x"), item("m2", 1, "y")
+
+	edges := []ReplyEdge{
+		// m3 и m4 отвечают на m2, m2 отвечает на m1: две ветки
+		{Group: "g1", Message: m4, Parent: m2},
+		{Group: "g1", Message: m2, Parent: m1},
+		{Group: "g1", Message: m3, Parent: m2},
+		// те же ID в другой группе — другая цепочка
+		{Group: "g2", Message: other2, Parent: other1},
+	}
+
+	chains := BuildReplyChains(edges)
+	assert.Equal(t, []ReplyChain{
+		{Group: "g1", Messages: []ReplyChainItem{m1, m2, m3}},
+		{Group: "g1", Messages: []ReplyChainItem{m1, m2, m4}},
+		{Group: "g2", Messages: []ReplyChainItem{other1, other2}},
+	}, chains)
+}
+
+func TestBuildReplyChains_Empty(t *testing.T) {
+	assert.Empty(t, BuildReplyChains(nil))
+}
+
+func TestMatchDeleted(t *testing.T) {
+	filter := bson.M{"group": "g1"}
+	assert.Equal(t, bson.M{"group": "g1", "deleted_at": bson.M{"$exists": false}}, MatchDeleted(filter), "Удалённые сообщения пропускаются")
+	assert.Equal(t, bson.M{"group": "g1"}, filter, "Фильтр вызывающего не меняется")
+
+	deleted := bson.M{"deleted_at": bson.M{"$exists": true}}
+	assert.Equal(t, deleted, MatchDeleted(deleted), "Условие на deleted_at не заменяется")
+	assert.Equal(t, bson.M{"group": "g1"}, MatchDeleted(WithDeleted(bson.M{"group": "g1"})), "WithDeleted ищет все сообщения")
+	assert.Equal(t, bson.M{}, MatchDeleted(WithDeleted(nil)))
+}
+
+func TestDiffTags(t *testing.T) {
+	seenAt := time.Date(2024, time.November, 21, 19, 20, 37, 0, time.UTC)
+	docs := []bson.M{
+		{"uuid": "1", "message_id": "message1", "group": "g1", "run_id": "run1", "tags": []string{"booba", "stare"}},
+		// порядок тегов не изменение
+		{"uuid": "2", "message_id": "message2", "group": "g1", "run_id": "run1", "tags": []string{"shy", "booba"}},
+		// нового сообщения нет в истории
+		{"uuid": "3", "message_id": "message3", "group": "g1", "run_id": "run1", "tags": []string{"booba"}},
+		// теги из dead-letter файла
+		{"uuid": "4", "message_id": "message4", "group": "g2", "tags": primitive.A{"shy"}},
+	}
+	prevTags := map[string][]string{
+		"1": {"booba", "shy"},
+		"2": {"booba", "shy"},
+		"4": nil,
+	}
+
+	assert.Equal(t, []models.TagChange{
+		{
+			UUID: "1", MessageID: "message1", Group: "g1", RunID: "run1", SeenAt: seenAt,
+			PrevTags: []string{"booba", "shy"}, Tags: []string{"booba", "stare"},
+			Added: []string{"stare"}, Removed: []string{"shy"},
+		},
+		{
+			UUID: "4", MessageID: "message4", Group: "g2", SeenAt: seenAt,
+			Tags: []string{"shy"}, Added: []string{"shy"},
+		},
+	}, diffTags(docs, prevTags, seenAt))
+}
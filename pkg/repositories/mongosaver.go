@@ -3,13 +3,17 @@ package repositories
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/meesooqa/tgtag/pkg/models"
 )
 
 // Inserter представляет сущность, поддерживающую пакетную вставку документов.
@@ -27,10 +31,45 @@ type saver struct {
 	wg          sync.WaitGroup
 	mu          sync.Mutex
 	closed      bool
+	// maxRetries и retryBackoff — повторы временных ошибок записи, по умолчанию без повторов
+	maxRetries   int
+	retryBackoff time.Duration
+	// deadLetter получает документы, которые не удалось сохранить, nil — только лог
+	deadLetter *deadLetterFile
+	// tags записывает историю изменений тегов, nil — без истории
+	tags tagRecorder
+	// result and err are written by run only, they are read after it is done
+	result IngestResult
+	err    error
+}
+
+// saverOption настраивает необязательные параметры Saver
+type saverOption func(s *saver)
+
+// withRetries повторяет временные ошибки до maxRetries раз, задержка удваивается начиная с backoff
+func withRetries(maxRetries int, backoff time.Duration) saverOption {
+	return func(s *saver) {
+		s.maxRetries = maxRetries
+		s.retryBackoff = backoff
+	}
+}
+
+// withDeadLetter пишет несохранённые документы в файл
+func withDeadLetter(deadLetter *deadLetterFile) saverOption {
+	return func(s *saver) {
+		s.deadLetter = deadLetter
+	}
+}
+
+// withTagHistory записывает изменения тегов сохранённых сообщений
+func withTagHistory(tags tagRecorder) saverOption {
+	return func(s *saver) {
+		s.tags = tags
+	}
 }
 
 // NewSaver создаёт новый Saver с указанными параметрами.
-func newSaver(log *slog.Logger, collection inserter, batchSize int, flushPeriod time.Duration, bufferSize int) *saver {
+func newSaver(log *slog.Logger, collection inserter, batchSize int, flushPeriod time.Duration, bufferSize int, opts ...saverOption) *saver {
 	s := &saver{
 		log:         log,
 		collection:  collection,
@@ -38,6 +77,9 @@ func newSaver(log *slog.Logger, collection inserter, batchSize int, flushPeriod
 		batchSize:   batchSize,
 		flushPeriod: flushPeriod,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 	s.wg.Add(1)
 	go s.run()
 	return s
@@ -75,50 +117,240 @@ func (s *saver) run() {
 	}
 }
 
-// saveBatch сохраняет батч документов в MongoDB.
-// 1) Если документа с UUID нет – вставляем новый.
-// 2) Если документ с UUID уже есть:
-//   - Если tags отличаются – обновляем поле tags (и, например, datetime).
-//   - Если tags совпадают – обновление производится, но фактически документ не меняется.
+// saveBatch сохраняет батч документов в MongoDB и историю изменения тегов сохранённых документов.
+// Прежние теги читаются до записи, изменения сохраняются после неё.
 func (s *saver) saveBatch(batch []bson.M) {
-	var models []mongo.WriteModel
-
-	for _, doc := range batch {
-		// Фильтр всегда ищет документ по UUID
-		filter := bson.M{"uuid": doc["uuid"]}
-
-		// Операция обновления:
-		// - $set устанавливает поля (при обновлении, если tags изменились)
-		// - $setOnInsert гарантирует, что при вставке будет заполнен UUID
-		update := bson.M{
-			"$set": bson.M{
-				"message_id": doc["message_id"],
-				"group":      doc["group"],
-				"datetime":   doc["datetime"],
-				"tags":       doc["tags"],
-			},
-			"$setOnInsert": bson.M{
-				"uuid": doc["uuid"],
-			},
+	if s.tags == nil {
+		s.writeBatch(batch)
+		return
+	}
+	changes, err := s.tags.findTagChanges(context.TODO(), batch)
+	if err != nil {
+		s.log.Error("can't find tag changes", "err", err)
+	}
+	failed := s.writeBatch(batch)
+	if len(failed) > 0 {
+		failedUUIDs := make(map[any]bool, len(failed))
+		for _, doc := range failed {
+			failedUUIDs[doc["uuid"]] = true
+		}
+		changes = slices.DeleteFunc(changes, func(change models.TagChange) bool {
+			return failedUUIDs[change.UUID]
+		})
+	}
+	if err := s.tags.saveTagChanges(context.TODO(), changes); err != nil {
+		s.log.Error("can't save tag changes", "changes", len(changes), "err", err)
+	}
+}
+
+// writeBatch пишет батч документов и run_id сохранённых документов, возвращает несохранённые документы
+func (s *saver) writeBatch(batch []bson.M) []bson.M {
+	failedDocs := s.writeDocs(batch)
+	written := batch
+	if len(failedDocs) > 0 {
+		written = slices.DeleteFunc(slices.Clone(batch), func(doc bson.M) bool {
+			return slices.ContainsFunc(failedDocs, func(failed bson.M) bool { return failed["uuid"] == doc["uuid"] })
+		})
+	}
+	s.writeRunIDs(written)
+	return failedDocs
+}
+
+// writeDocs пишет документы и добавляет итог записи к результату, возвращает несохранённые документы.
+// Временные ошибки повторяются с экспоненциальной задержкой: при сетевой ошибке повторяется весь батч,
+// при BulkWriteException — только документы с временными ошибками. Остальные документы пишутся в dead-letter файл.
+func (s *saver) writeDocs(batch []bson.M) (failedDocs []bson.M) {
+	pending := batch
+	for attempt := 0; ; attempt++ {
+		models := make([]mongo.WriteModel, 0, len(pending))
+		for _, doc := range pending {
+			models = append(models, upsertModel(doc))
 		}
 
-		// Используем UpdateOne с upsert:true.
-		model := mongo.NewUpdateOneModel().
-			SetFilter(filter).
-			SetUpdate(update).
-			SetUpsert(true)
+		opts := options.BulkWrite().SetOrdered(false)
+		result, err := s.collection.BulkWrite(context.TODO(), models, opts)
+		s.log.Debug("BulkWrite result", "result", result, "attempt", attempt)
+		if result != nil {
+			s.result.Inserted += int(result.UpsertedCount)
+			s.result.Modified += int(result.ModifiedCount)
+			s.result.Unchanged += int(result.MatchedCount - result.ModifiedCount)
+		}
+		if err == nil {
+			return failedDocs
+		}
 
-		models = append(models, model)
+		retry, failed := splitFailed(pending, err)
+		if attempt >= s.maxRetries {
+			failed, retry = append(failed, retry...), nil
+		}
+		if len(failed) > 0 {
+			s.log.Error("BulkWrite failed", "err", err, "failed", len(failed), "attempt", attempt)
+			s.fail(failed, err)
+			failedDocs = append(failedDocs, failed...)
+		}
+		if len(retry) == 0 {
+			return failedDocs
+		}
+		backoff := s.retryBackoff << attempt
+		s.log.Warn("BulkWrite failed, retrying", "err", err, "documents", len(retry), "backoff", backoff)
+		time.Sleep(backoff)
+		pending = retry
 	}
+}
 
-	opts := options.BulkWrite().SetOrdered(false)
-	result, err := s.collection.BulkWrite(context.TODO(), models, opts)
-	s.log.Debug("BulkWrite result", "result", result)
-	if err != nil {
-		s.log.Error("BulkWrite failed", "err", err)
+// writeRunIDs отмечает run_id уже существовавшие сохранённые документы.
+// run_id пишется отдельно от upsert: новый запуск не изменение сообщения и не попадает в Modified.
+// Вставленные документы получили run_id при вставке и не совпадают с фильтром.
+func (s *saver) writeRunIDs(docs []bson.M) {
+	var runIDs []any
+	uuids := make(map[any][]any)
+	for _, doc := range docs {
+		runID := doc["run_id"]
+		if runID == nil || runID == "" {
+			continue
+		}
+		if _, ok := uuids[runID]; !ok {
+			runIDs = append(runIDs, runID)
+		}
+		uuids[runID] = append(uuids[runID], doc["uuid"])
+	}
+	if len(runIDs) == 0 {
+		return
+	}
+	models := make([]mongo.WriteModel, 0, len(runIDs))
+	for _, runID := range runIDs {
+		models = append(models, mongo.NewUpdateManyModel().
+			SetFilter(bson.M{"uuid": bson.M{"$in": uuids[runID]}, "run_id": bson.M{"$ne": runID}}).
+			SetUpdate(bson.M{"$set": bson.M{"run_id": runID}}))
+	}
+	for attempt := 0; ; attempt++ {
+		_, err := s.collection.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+		if err == nil {
+			return
+		}
+		if attempt >= s.maxRetries || !isTransientError(err) {
+			s.log.Error("can't write run IDs", "err", err, "documents", len(docs), "attempt", attempt)
+			if s.err == nil {
+				s.err = fmt.Errorf("run IDs are not saved: %w", err)
+			}
+			return
+		}
+		backoff := s.retryBackoff << attempt
+		s.log.Warn("can't write run IDs, retrying", "err", err, "backoff", backoff)
+		time.Sleep(backoff)
 	}
 }
 
+// splitFailed делит документы неудачной записи на те, что стоит повторить, и те, что не сохранятся при повторе.
+// Документы без ошибок в BulkWriteException сохранены и не попадают ни в один список.
+func splitFailed(docs []bson.M, err error) (retry, failed []bson.M) {
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && len(bwe.WriteErrors) > 0 {
+		for _, we := range bwe.WriteErrors {
+			if we.Index < 0 || we.Index >= len(docs) {
+				continue
+			}
+			if isTransientWriteError(we.Code) {
+				retry = append(retry, docs[we.Index])
+			} else {
+				failed = append(failed, docs[we.Index])
+			}
+		}
+		return retry, failed
+	}
+	if isTransientError(err) {
+		return docs, nil
+	}
+	return nil, docs
+}
+
+// isTransientError проверяет, что ошибка всей записи может пройти при повторе: сеть, таймаут, повторяемая запись
+func isTransientError(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError != nil {
+		return true
+	}
+	var se mongo.ServerError
+	return errors.As(err, &se) && (se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError"))
+}
+
+// isTransientWriteError проверяет код ошибки документа: гонка двух upsert одного UUID (11000) и конфликт записи (112)
+func isTransientWriteError(code int) bool {
+	return code == 11000 || code == 112
+}
+
+// fail учитывает несохранённые документы и пишет их в dead-letter файл
+func (s *saver) fail(docs []bson.M, err error) {
+	s.result.Failed += len(docs)
+	if s.err == nil {
+		s.err = err
+	}
+	if s.deadLetter == nil {
+		return
+	}
+	if dlErr := s.deadLetter.write(docs, err); dlErr != nil {
+		s.log.Error("can't write dead letters", "path", s.deadLetter.path, "documents", len(docs), "err", dlErr)
+	}
+}
+
+// upsertModel строит upsert документа по UUID.
+// 1) Если документа с UUID нет – вставляем новый.
+// 2) Если документ с UUID уже есть:
+//   - Если tags отличаются – обновляем поле tags (и, например, datetime).
+//   - Если tags совпадают – обновление производится, но фактически документ не меняется.
+func upsertModel(doc bson.M) mongo.WriteModel {
+	// Фильтр всегда ищет документ по UUID
+	filter := bson.M{"uuid": doc["uuid"]}
+
+	// Операция обновления:
+	// - $set устанавливает поля (при обновлении, если tags изменились)
+	// - $setOnInsert гарантирует, что при вставке будет заполнен UUID
+	set := bson.M{
+		"message_id":   doc["message_id"],
+		"group":        doc["group"],
+		"group_title":  doc["group_title"],
+		"datetime":     doc["datetime"],
+		"tz_offset":    doc["tz_offset"],
+		"edited":       doc["edited"],
+		"from":         doc["from"],
+		"text":         doc["text"],
+		"tags":         doc["tags"],
+		"raw_tags":     doc["raw_tags"],
+		"mentions":     doc["mentions"],
+		"cashtags":     doc["cashtags"],
+		"urls":         doc["urls"],
+		"bot_commands": doc["bot_commands"],
+		"media":        doc["media"],
+		"reply_to":     doc["reply_to"],
+		"forward":      doc["forward"],
+		"reactions":    doc["reactions"],
+	}
+	// сообщение снова есть в экспорте
+	unset := bson.M{"deleted_at": ""}
+	// пустые поля (omitempty) удаляются, а не пишутся как null: {"$exists": true} не находит сообщения без них
+	for field, value := range set {
+		if value == nil {
+			delete(set, field)
+			unset[field] = ""
+		}
+	}
+	// run_id существующих документов пишет writeRunIDs
+	setOnInsert := bson.M{"uuid": doc["uuid"]}
+	if doc["run_id"] != nil {
+		setOnInsert["run_id"] = doc["run_id"]
+	}
+	update := bson.M{"$set": set, "$setOnInsert": setOnInsert, "$unset": unset}
+
+	// Используем UpdateOne с upsert:true.
+	return mongo.NewUpdateOneModel().
+		SetFilter(filter).
+		SetUpdate(update).
+		SetUpsert(true)
+}
+
 // Save добавляет документ в очередь сохранения.
 func (s *saver) Save(doc bson.M) error {
 	s.mu.Lock()
@@ -130,8 +362,9 @@ func (s *saver) Save(doc bson.M) error {
 	return nil
 }
 
-// Close завершает работу и сохраняет остатки.
-func (s *saver) Close() {
+// Close завершает работу, сохраняет остатки и возвращает итог записи.
+// Ошибка — первая ошибка BulkWrite, если хотя бы один документ или run_id не сохранён.
+func (s *saver) Close() (IngestResult, error) {
 	s.mu.Lock()
 	if !s.closed {
 		s.closed = true
@@ -139,4 +372,12 @@ func (s *saver) Close() {
 	}
 	s.mu.Unlock()
 	s.wg.Wait()
+
+	if s.err != nil && s.result.Failed == 0 {
+		return s.result, s.err
+	}
+	if s.err != nil {
+		return s.result, fmt.Errorf("%d of %d documents are not saved: %w", s.result.Failed, s.result.Total(), s.err)
+	}
+	return s.result, nil
 }
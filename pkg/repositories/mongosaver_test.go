@@ -3,15 +3,22 @@ package repositories
 import (
 	"bytes"
 	"context"
+	"errors"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/meesooqa/tgtag/pkg/models"
 )
 
 // BulkWriteCall хранит параметры вызова BulkWrite.
@@ -27,6 +34,15 @@ type fakeInserter struct {
 	Calls []bulkWriteCall
 	// При необходимости можно симулировать ошибку.
 	Err error
+	// Result, если задан, возвращается вместо фиктивного результата.
+	Result *mongo.BulkWriteResult
+	// Responses возвращаются по порядку вызовов, после них — Result и Err.
+	Responses []fakeResponse
+}
+
+type fakeResponse struct {
+	Result *mongo.BulkWriteResult
+	Err    error
 }
 
 func (f *fakeInserter) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
@@ -42,6 +58,14 @@ func (f *fakeInserter) BulkWrite(ctx context.Context, models []mongo.WriteModel,
 		Options: bulkOpts,
 	}
 	f.Calls = append(f.Calls, call)
+	if len(f.Responses) > 0 {
+		resp := f.Responses[0]
+		f.Responses = f.Responses[1:]
+		return resp.Result, resp.Err
+	}
+	if f.Result != nil {
+		return f.Result, f.Err
+	}
 	// Возвращаем фиктивный результат: считаем, что все операции upsert прошли успешно.
 	result := &mongo.BulkWriteResult{
 		MatchedCount:  0,
@@ -239,3 +263,241 @@ func TestSaver_SaveAfterClose(t *testing.T) {
 		t.Errorf("Expected error when saving after Close, got nil")
 	}
 }
+
+// TestSaver_Result проверяет, что Close сохраняет остатки без ожидания flushPeriod и возвращает итог записи.
+func TestSaver_Result(t *testing.T) {
+	fakeInserter := &fakeInserter{}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	svr := newSaver(logger, fakeInserter, 2, time.Hour, 10)
+
+	for _, uuid := range []string{"msg1", "msg2", "msg3"} {
+		assert.NoError(t, svr.Save(bson.M{"uuid": uuid}))
+	}
+	result, err := svr.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, IngestResult{Inserted: 3}, result)
+	assert.Equal(t, 2, len(fakeInserter.GetCalls()), "Полный батч и остаток")
+}
+
+// TestSaver_RunIDs проверяет, что run_id существующих документов пишется отдельно от upsert и не считается изменением.
+func TestSaver_RunIDs(t *testing.T) {
+	fakeInserter := &fakeInserter{}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10)
+
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg1", "run_id": "run1"}))
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg2", "run_id": "run1"}))
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg3"}))
+	_, err := svr.Close()
+	assert.NoError(t, err)
+
+	calls := fakeInserter.GetCalls()
+	require.Len(t, calls, 2, "Upsert и запись run_id")
+	update := calls[0].Models[0].(*mongo.UpdateOneModel).Update.(bson.M)
+	assert.NotContains(t, update["$set"], "run_id", "run_id не меняет сохранённое сообщение")
+	assert.Equal(t, "run1", update["$setOnInsert"].(bson.M)["run_id"])
+
+	require.Len(t, calls[1].Models, 1, "Сообщения без run_id не отмечаются")
+	runIDs := calls[1].Models[0].(*mongo.UpdateManyModel)
+	assert.Equal(t, bson.M{"uuid": bson.M{"$in": []any{"msg1", "msg2"}}, "run_id": bson.M{"$ne": "run1"}}, runIDs.Filter)
+	assert.Equal(t, bson.M{"$set": bson.M{"run_id": "run1"}}, runIDs.Update)
+}
+
+func TestUpsertModel_EmptyFields(t *testing.T) {
+	update := upsertModel(bson.M{"uuid": "msg1", "tags": bson.A{"booba"}, "reactions": nil}).(*mongo.UpdateOneModel).Update.(bson.M)
+	assert.NotContains(t, update["$set"], "reactions", "Пустые поля не пишутся как null")
+	assert.Contains(t, update["$set"], "tags")
+	assert.Equal(t, "", update["$unset"].(bson.M)["reactions"], "Пустые поля удаляются из сохранённого сообщения")
+	assert.Contains(t, update["$unset"], "deleted_at")
+	assert.NotContains(t, update["$setOnInsert"], "run_id", "Сообщение без run_id сохраняется без него")
+}
+
+// TestSaver_BulkWriteError проверяет, что ошибки документов учитываются как failed и возвращаются из Close.
+func TestSaver_BulkWriteError(t *testing.T) {
+	fakeInserter := &fakeInserter{
+		Err: mongo.BulkWriteException{
+			WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}}},
+		},
+		Result: &mongo.BulkWriteResult{UpsertedCount: 1, MatchedCount: 1, ModifiedCount: 0},
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10)
+
+	for _, uuid := range []string{"msg1", "msg2", "msg3"} {
+		assert.NoError(t, svr.Save(bson.M{"uuid": uuid}))
+	}
+	result, err := svr.Close()
+
+	assert.ErrorContains(t, err, "1 of 3 documents are not saved")
+	assert.Equal(t, IngestResult{Inserted: 1, Unchanged: 1, Failed: 1}, result)
+}
+
+// TestSaver_ConnectionError проверяет, что при ошибке без списка документов весь батч считается несохранённым.
+func TestSaver_ConnectionError(t *testing.T) {
+	fakeInserter := &fakeInserter{Err: errors.New("connection refused"), Result: &mongo.BulkWriteResult{}}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10)
+
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg1"}))
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg2"}))
+	result, err := svr.Close()
+
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Equal(t, IngestResult{Failed: 2}, result)
+}
+
+func modelUUIDs(models []mongo.WriteModel) []any {
+	var uuids []any
+	for _, model := range models {
+		uuids = append(uuids, model.(*mongo.UpdateOneModel).Filter.(bson.M)["uuid"])
+	}
+	return uuids
+}
+
+func deadLetterUUIDs(t *testing.T, path string) []any {
+	t.Helper()
+	docs, err := readDeadLetters(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	require.NoError(t, err)
+	var uuids []any
+	for _, doc := range docs {
+		uuids = append(uuids, doc["uuid"])
+	}
+	return uuids
+}
+
+// TestSaver_RetryTransientError проверяет, что при сетевой ошибке батч повторяется целиком.
+func TestSaver_RetryTransientError(t *testing.T) {
+	fakeInserter := &fakeInserter{Responses: []fakeResponse{
+		{Err: mongo.CommandError{Code: 6, Message: "host unreachable", Labels: []string{"NetworkError"}}},
+		{Err: mongo.CommandError{Code: 91, Message: "shutdown", Labels: []string{"RetryableWriteError"}}},
+	}}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	deadLetterPath := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10,
+		withRetries(3, time.Millisecond), withDeadLetter(newDeadLetterFile(deadLetterPath)))
+
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg1"}))
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg2"}))
+	result, err := svr.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, IngestResult{Inserted: 2}, result)
+	calls := fakeInserter.GetCalls()
+	assert.Equal(t, 3, len(calls), "Две повторные попытки после временных ошибок")
+	assert.Equal(t, []any{"msg1", "msg2"}, modelUUIDs(calls[2].Models))
+	assert.Empty(t, deadLetterUUIDs(t, deadLetterPath))
+}
+
+// TestSaver_RetryWriteErrors проверяет, что повторяются только документы с временными ошибками,
+// а документы с постоянными ошибками пишутся в dead-letter файл.
+func TestSaver_RetryWriteErrors(t *testing.T) {
+	fakeInserter := &fakeInserter{Responses: []fakeResponse{{
+		Result: &mongo.BulkWriteResult{UpsertedCount: 1},
+		Err: mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+			{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}},
+			{WriteError: mongo.WriteError{Index: 2, Code: 121, Message: "document failed validation"}},
+		}},
+	}}}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	deadLetterPath := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10,
+		withRetries(3, time.Millisecond), withDeadLetter(newDeadLetterFile(deadLetterPath)))
+
+	for _, uuid := range []string{"msg1", "msg2", "msg3"} {
+		assert.NoError(t, svr.Save(bson.M{"uuid": uuid}))
+	}
+	result, err := svr.Close()
+
+	assert.ErrorContains(t, err, "document failed validation")
+	assert.Equal(t, IngestResult{Inserted: 2, Failed: 1}, result)
+	calls := fakeInserter.GetCalls()
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, []any{"msg2"}, modelUUIDs(calls[1].Models), "Повторяется только документ с временной ошибкой")
+	assert.Equal(t, []any{"msg3"}, deadLetterUUIDs(t, deadLetterPath))
+}
+
+// TestSaver_RetriesExhausted проверяет, что после последней попытки документы пишутся в dead-letter файл.
+func TestSaver_RetriesExhausted(t *testing.T) {
+	fakeInserter := &fakeInserter{Err: mongo.CommandError{Code: 6, Labels: []string{"NetworkError"}}, Result: &mongo.BulkWriteResult{}}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	deadLetterPath := filepath.Join(t.TempDir(), "dead_letter.jsonl")
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10,
+		withRetries(2, time.Millisecond), withDeadLetter(newDeadLetterFile(deadLetterPath)))
+
+	assert.NoError(t, svr.Save(bson.M{"uuid": "msg1"}))
+	result, err := svr.Close()
+
+	assert.Error(t, err)
+	assert.Equal(t, IngestResult{Failed: 1}, result)
+	assert.Equal(t, 3, len(fakeInserter.GetCalls()), "Первая попытка и два повтора")
+	assert.Equal(t, []any{"msg1"}, deadLetterUUIDs(t, deadLetterPath))
+}
+
+// TestDeadLetterFile проверяет, что документы читаются из dead-letter файла с теми же типами.
+func TestDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "var", "dead_letter.jsonl")
+	deadLetter := newDeadLetterFile(path)
+	datetime := time.Date(2024, 11, 21, 16, 20, 37, 0, time.UTC)
+
+	require.NoError(t, deadLetter.write([]bson.M{{"uuid": "msg1", "datetime": datetime, "tz_offset": 10800}}, errors.New("first")))
+	require.NoError(t, deadLetter.write([]bson.M{{"uuid": "msg2", "tags": []string{"booba"}}}, errors.New("second")))
+
+	docs, err := readDeadLetters(path)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "msg1", docs[0]["uuid"])
+	assert.Equal(t, primitive.NewDateTimeFromTime(datetime), docs[0]["datetime"])
+	assert.Equal(t, int32(10800), docs[0]["tz_offset"])
+	assert.Equal(t, bson.A{"booba"}, docs[1]["tags"])
+}
+
+// fakeTagRecorder находит изменение тегов у каждого документа
+type fakeTagRecorder struct {
+	Saved []models.TagChange
+}
+
+func (f *fakeTagRecorder) findTagChanges(ctx context.Context, docs []bson.M) ([]models.TagChange, error) {
+	var changes []models.TagChange
+	for _, doc := range docs {
+		changes = append(changes, models.TagChange{UUID: doc["uuid"].(string)})
+	}
+	return changes, nil
+}
+
+func (f *fakeTagRecorder) saveTagChanges(ctx context.Context, changes []models.TagChange) error {
+	f.Saved = append(f.Saved, changes...)
+	return nil
+}
+
+// TestSaver_TagHistory проверяет, что изменения тегов несохранённых документов не попадают в историю.
+func TestSaver_TagHistory(t *testing.T) {
+	fakeInserter := &fakeInserter{
+		Err: mongo.BulkWriteException{
+			WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 2, Message: "bad value"}}},
+		},
+		Result: &mongo.BulkWriteResult{MatchedCount: 2, ModifiedCount: 2},
+	}
+	recorder := &fakeTagRecorder{}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	svr := newSaver(logger, fakeInserter, 10, time.Hour, 10, withTagHistory(recorder))
+
+	for _, uuid := range []string{"msg1", "msg2", "msg3"} {
+		assert.NoError(t, svr.Save(bson.M{"uuid": uuid}))
+	}
+	_, err := svr.Close()
+
+	assert.Error(t, err)
+	assert.Equal(t, []models.TagChange{{UUID: "msg1"}, {UUID: "msg3"}}, recorder.Saved)
+}
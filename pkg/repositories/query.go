@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// MessageQuery selects messages without details of the storage, zero fields are no conditions
+type MessageQuery struct {
+	// Groups are groups of messages, any of them
+	Groups []string
+	// Tags are tags every message has, ExcludeTags are tags no message has
+	Tags        []string
+	ExcludeTags []string
+	// From and To limit the message datetime: From <= datetime < To
+	From time.Time
+	To   time.Time
+	Sort MessageSort
+	// Limit <= 0 means no limit
+	Limit int
+	// Cursor continues the query after the message of NextCursor, the query must have the same Sort
+	Cursor string
+	// IncludeDeleted selects messages marked with deleted_at too
+	IncludeDeleted bool
+}
+
+// MessageSort is the order of messages, messages of the same datetime are ordered by UUID
+type MessageSort int
+
+const (
+	// SortOldest is the default order: the oldest message first
+	SortOldest MessageSort = iota
+	SortNewest
+)
+
+// messageCursor is the position of the last returned message
+type messageCursor struct {
+	Datetime time.Time `json:"d"`
+	UUID     string    `json:"u"`
+}
+
+// NextCursor returns MessageQuery.Cursor for messages after msg
+func NextCursor(msg *models.Message) string {
+	data, _ := json.Marshal(messageCursor{Datetime: msg.Datetime.UTC(), UUID: msg.UUID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseCursor(cursor string) (messageCursor, error) {
+	var c messageCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor %q: %w", cursor, err)
+	}
+	return c, nil
+}
+
+// Match checks the conditions of the query without Cursor, it is the query for repositories without MongoDB
+func (q MessageQuery) Match(msg *models.Message) bool {
+	if !q.IncludeDeleted && msg.DeletedAt != nil {
+		return false
+	}
+	if len(q.Groups) > 0 && !slices.Contains(q.Groups, msg.Group) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(msg.Tags, tag) {
+			return false
+		}
+	}
+	for _, tag := range q.ExcludeTags {
+		if slices.Contains(msg.Tags, tag) {
+			return false
+		}
+	}
+	if !q.From.IsZero() && msg.Datetime.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !msg.Datetime.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// Compare orders messages by Sort: a negative number when a goes before b
+func (q MessageQuery) Compare(a, b *models.Message) int {
+	c := cmp.Or(a.Datetime.Compare(b.Datetime), strings.Compare(a.UUID, b.UUID))
+	if q.Sort == SortNewest {
+		return -c
+	}
+	return c
+}
+
+// AfterCursor checks that the message goes after the message of Cursor, any message does without Cursor
+func (q MessageQuery) AfterCursor(msg *models.Message) (bool, error) {
+	last, err := q.CursorMessage()
+	if last == nil {
+		return err == nil, err
+	}
+	return q.Compare(msg, last) > 0, nil
+}
+
+// CursorMessage returns the position of Cursor as a message with Datetime and UUID, nil without Cursor
+func (q MessageQuery) CursorMessage() (*models.Message, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	c, err := parseCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Message{Datetime: c.Datetime, UUID: c.UUID}, nil
+}
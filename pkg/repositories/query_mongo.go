@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// messageFilter is the only place turning MessageQuery into the MongoDB filter
+func messageFilter(q MessageQuery) (bson.M, error) {
+	filter := bson.M{}
+	if !q.IncludeDeleted {
+		filter["deleted_at"] = bson.M{"$exists": false}
+	}
+	switch len(q.Groups) {
+	case 0:
+	case 1:
+		filter["group"] = q.Groups[0]
+	default:
+		filter["group"] = bson.M{"$in": q.Groups}
+	}
+
+	tags := bson.M{}
+	if len(q.Tags) > 0 {
+		tags["$all"] = q.Tags
+	}
+	if len(q.ExcludeTags) > 0 {
+		tags["$nin"] = q.ExcludeTags
+	}
+	if len(tags) > 0 {
+		filter["tags"] = tags
+	}
+
+	datetime := bson.M{}
+	if !q.From.IsZero() {
+		datetime["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		datetime["$lt"] = q.To
+	}
+	if len(datetime) > 0 {
+		filter["datetime"] = datetime
+	}
+
+	if q.Cursor != "" {
+		c, err := parseCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after := "$gt"
+		if q.Sort == SortNewest {
+			after = "$lt"
+		}
+		filter["$or"] = bson.A{
+			bson.M{"datetime": bson.M{after: c.Datetime}},
+			bson.M{"datetime": c.Datetime, "uuid": bson.M{after: c.UUID}},
+		}
+	}
+	return filter, nil
+}
+
+// messageFindOptions sorts by datetime and UUID, so that the cursor position is unique
+func messageFindOptions(q MessageQuery) *options.FindOptions {
+	order := 1
+	if q.Sort == SortNewest {
+		order = -1
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "datetime", Value: order}, {Key: "uuid", Value: order}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	return opts
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+func TestNextCursor(t *testing.T) {
+	msg := &models.Message{UUID: "uuid1", Datetime: time.Date(2024, time.November, 21, 19, 20, 37, 5, time.FixedZone("", 3*3600))}
+	c, err := parseCursor(NextCursor(msg))
+	require.NoError(t, err)
+	assert.True(t, msg.Datetime.Equal(c.Datetime))
+	assert.Equal(t, "uuid1", c.UUID)
+
+	_, err = parseCursor("booba")
+	assert.ErrorContains(t, err, "invalid cursor")
+}
+
+func TestMessageFilter(t *testing.T) {
+	filter, err := messageFilter(MessageQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"deleted_at": bson.M{"$exists": false}}, filter, "Пустой запрос — все неудалённые сообщения")
+
+	from := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC)
+	filter, err = messageFilter(MessageQuery{
+		Groups:         []string{"g1"},
+		Tags:           []string{"booba", "shy"},
+		ExcludeTags:    []string{"stare"},
+		From:           from,
+		To:             to,
+		IncludeDeleted: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{
+		"group":    "g1",
+		"tags":     bson.M{"$all": []string{"booba", "shy"}, "$nin": []string{"stare"}},
+		"datetime": bson.M{"$gte": from, "$lt": to},
+	}, filter)
+
+	filter, err = messageFilter(MessageQuery{Groups: []string{"g1", "g2"}, IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"group": bson.M{"$in": []string{"g1", "g2"}}}, filter)
+}
+
+func TestMessageFilter_Cursor(t *testing.T) {
+	msg := &models.Message{UUID: "uuid1", Datetime: time.Date(2024, time.November, 21, 19, 20, 37, 0, time.UTC)}
+	filter, err := messageFilter(MessageQuery{Cursor: NextCursor(msg), Sort: SortNewest, IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"datetime": bson.M{"$lt": msg.Datetime}},
+		bson.M{"datetime": msg.Datetime, "uuid": bson.M{"$lt": "uuid1"}},
+	}}, filter, "Следующая страница — сообщения раньше последнего")
+
+	_, err = messageFilter(MessageQuery{Cursor: "booba"})
+	assert.Error(t, err)
+}
@@ -2,6 +2,8 @@ package repositories
 
 import (
 	"context"
+	"iter"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -10,7 +12,57 @@ import (
 )
 
 type Repository interface {
-	Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]*models.Message, error)
-	UpsertMany(messagesChan <-chan models.Message)
+	// FindMessages returns messages of the query one by one, the iteration stops at the first error
+	FindMessages(ctx context.Context, q MessageQuery) iter.Seq2[*models.Message, error]
+	// CountMessages counts messages of the query, Sort, Limit and Cursor are ignored
+	CountMessages(ctx context.Context, q MessageQuery) (int, error)
+	// UpsertMany saves messages by UUID until messagesChan is closed
+	UpsertMany(messagesChan <-chan models.Message) (IngestResult, error)
 	GetGroups(ctx context.Context) ([]string, error)
+	GetTagCountsByMediaKind(ctx context.Context, group string) ([]TagMediaKindCount, error)
+	GetMostReactedTags(ctx context.Context, group string, limit int) ([]TagReactions, error)
+	GetReplyChains(ctx context.Context, group string) ([]ReplyChain, error)
+	// GetTagCounts counts messages per group and tag: groups in order, the most used tags of a group first
+	GetTagCounts(ctx context.Context, group string) ([]TagCount, error)
+	// GetTagCountsByPeriod counts messages per tag and period in loc, UTC if loc is nil, the oldest period first.
+	// Weeks start on Monday, starts of periods are in loc.
+	GetTagCountsByPeriod(ctx context.Context, group string, period Period, loc *time.Location) ([]TagPeriodCount, error)
+	// GetTagsHistogram counts messages per number of their tags, messages without tags are counted too
+	GetTagsHistogram(ctx context.Context, group string) ([]TagsHistogramBucket, error)
+	// GetTagSpans returns the first and the last message datetime per tag, in the order of tags
+	GetTagSpans(ctx context.Context, group string) ([]TagSpan, error)
+	// GetEditedMessages returns edited messages one by one, the last edited first, the iteration stops at the first error
+	GetEditedMessages(ctx context.Context, group string) iter.Seq2[*models.Message, error]
+	// MarkDeleted sets deleted_at of the group's messages that are saved by runs other than keepRunIDs.
+	// Messages without run_id are saved before runs were recorded, they are not marked.
+	MarkDeleted(ctx context.Context, group string, keepRunIDs []string, deletedAt time.Time) (int, error)
+	// MarkSeen sets run_id of the saved messages to the run that has found them in unchanged files, so they are not marked deleted.
+	// A deleted message found again is not deleted any more.
+	MarkSeen(ctx context.Context, uuids []string, runID string) error
+	// UpdateTags sets raw and normalized tags of the message
+	UpdateTags(ctx context.Context, uuid string, rawTags, tags []string) error
+	UpsertServiceEvents(ctx context.Context, events []models.ServiceEvent) error
+	// GetManifestEntry returns the saved export file, nil if the file has not been saved
+	GetManifestEntry(ctx context.Context, path string) (*models.ManifestEntry, error)
+	UpsertManifestEntries(ctx context.Context, entries []models.ManifestEntry) error
+	// SaveIngestRun saves the run by its ID, it is saved at the start and at the end of the run
+	SaveIngestRun(ctx context.Context, run models.IngestRun) error
+	// GetIngestRuns returns runs without their files, the last started first, limit <= 0 means no limit
+	GetIngestRuns(ctx context.Context, limit int) ([]*models.IngestRun, error)
+	// GetIngestRun returns the run with its files, nil if there is no such run
+	GetIngestRun(ctx context.Context, runID string) (*models.IngestRun, error)
+	// GetTagChanges returns tag changes of the group where the tag is added or removed, the last seen first.
+	// The empty group or tag means any, limit <= 0 means no limit.
+	GetTagChanges(ctx context.Context, group, tag string, limit int) ([]*models.TagChange, error)
+	// GetServiceEvents returns service events one by one in the order of time, no types means all types.
+	// The iteration stops at the first error.
+	GetServiceEvents(ctx context.Context, group string, types ...string) iter.Seq2[*models.ServiceEvent, error]
+}
+
+// MongoRepository is the Repository on MongoDB, extensions written against MongoDB filters type-assert to it
+type MongoRepository interface {
+	Repository
+	// Find skips deleted messages unless the filter has a deleted_at condition, see WithDeleted.
+	// It is kept for extensions written against MongoDB, new code uses FindMessages.
+	Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]*models.Message, error)
 }
@@ -0,0 +1,616 @@
+// Package repotest is the behavior every repositories.Repository must have, implementations run it in their tests
+package repotest
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// NewRepository returns an empty repository with the messages saved as they are, every test gets its own
+type NewRepository func(t *testing.T, messages ...models.Message) repositories.Repository
+
+// Run runs the conformance tests on the repositories of newRepo
+func Run(t *testing.T, newRepo NewRepository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, newRepo NewRepository)
+	}{
+		{"UpsertMany", testUpsertMany},
+		{"UpsertManyChannelID", testUpsertManyChannelID},
+		{"TagChanges", testTagChanges},
+		{"GetGroups", testGetGroups},
+		{"FindMessages", testFindMessages},
+		{"FindMessagesPages", testFindMessagesPages},
+		{"MarkDeleted", testMarkDeleted},
+		{"MarkSeen", testMarkSeen},
+		{"UpdateTags", testUpdateTags},
+		{"ServiceEvents", testServiceEvents},
+		{"ManifestEntries", testManifestEntries},
+		{"IngestRuns", testIngestRuns},
+		{"TagCountsByMediaKind", testTagCountsByMediaKind},
+		{"MostReactedTags", testMostReactedTags},
+		{"EditedMessages", testEditedMessages},
+		{"ReplyChains", testReplyChains},
+		{"TagCounts", testTagCounts},
+		{"TagCountsByPeriod", testTagCountsByPeriod},
+		{"TagsHistogram", testTagsHistogram},
+		{"TagSpans", testTagSpans},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepo)
+		})
+	}
+}
+
+// now is the time of fixtures, MongoDB keeps milliseconds in UTC
+var now = time.Date(2024, time.November, 21, 16, 20, 37, 0, time.UTC)
+
+func upsert(t *testing.T, repo repositories.Repository, messages ...models.Message) repositories.IngestResult {
+	messagesChan := make(chan models.Message, len(messages))
+	for _, msg := range messages {
+		messagesChan <- msg
+	}
+	close(messagesChan)
+	result, err := repo.UpsertMany(messagesChan)
+	require.NoError(t, err)
+	return result
+}
+
+func messageIDs(messages []*models.Message) []string {
+	var ids []string
+	for _, msg := range messages {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+func findIDs(t *testing.T, repo repositories.Repository, q repositories.MessageQuery) []string {
+	return messageIDs(findMessages(t, repo, q))
+}
+
+func findMessages(t *testing.T, repo repositories.Repository, q repositories.MessageQuery) []*models.Message {
+	return collect(t, repo.FindMessages(context.Background(), q))
+}
+
+// collect returns all items of the iterator, the test fails at the first error
+func collect[T any](t *testing.T, items iter.Seq2[T, error]) []T {
+	var result []T
+	for item, err := range items {
+		require.NoError(t, err)
+		result = append(result, item)
+	}
+	return result
+}
+
+func testUpsertMany(t *testing.T, newRepo NewRepository) {
+	deletedAt := now
+	repo := newRepo(t, models.Message{UUID: "3", MessageID: "message3", Group: "g1", RunID: "run0", DeletedAt: &deletedAt})
+	ctx := context.Background()
+	messages := []models.Message{
+		{UUID: "1", MessageID: "message1", Group: "g1", Datetime: now, Tags: []string{"booba"}, RunID: "run1"},
+		{UUID: "2", MessageID: "message2", Group: "g1", Datetime: now, Tags: []string{"shy"}, RunID: "run1"},
+	}
+
+	assert.Equal(t, repositories.IngestResult{Inserted: 2}, upsert(t, repo, messages...))
+	assert.Equal(t, repositories.IngestResult{Unchanged: 2}, upsert(t, repo, messages...), "Те же сообщения не изменяются")
+
+	rerun := slices.Clone(messages)
+	for i := range rerun {
+		rerun[i].RunID = "run2"
+	}
+	assert.Equal(t, repositories.IngestResult{Unchanged: 2}, upsert(t, repo, rerun...), "Новый запуск не изменение сообщения")
+	deleted, err := repo.MarkDeleted(ctx, "g1", []string{"run2"}, now)
+	require.NoError(t, err)
+	assert.Zero(t, deleted, "Сообщения отмечены новым запуском")
+
+	messages[0].Text = "booba"
+	messages = append(messages, models.Message{UUID: "3", MessageID: "message3", Group: "g1", RunID: "run1"})
+	assert.Equal(t, repositories.IngestResult{Modified: 2, Unchanged: 1}, upsert(t, repo, messages...))
+
+	found := findMessages(t, repo, repositories.MessageQuery{Groups: []string{"g1"}})
+	assert.ElementsMatch(t, []string{"message1", "message2", "message3"}, messageIDs(found), "Снова найденное сообщение не удалено")
+	for _, msg := range found {
+		assert.False(t, msg.ID.IsZero())
+		assert.Nil(t, msg.DeletedAt)
+		if msg.UUID == "1" {
+			assert.Equal(t, "booba", msg.Text)
+			assert.Equal(t, now, msg.Datetime)
+		}
+	}
+}
+
+func testUpsertManyChannelID(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t)
+	fromJSON := models.Message{UUID: "1", MessageID: "message1", Group: "g1", Datetime: now, ChannelID: 777, RunID: "run1"}
+	fromHTML := models.Message{UUID: "1", MessageID: "message1", Group: "g1", Datetime: now, RunID: "run2"}
+
+	assert.Equal(t, repositories.IngestResult{Inserted: 1}, upsert(t, repo, fromJSON))
+	assert.Equal(t, repositories.IngestResult{Unchanged: 1}, upsert(t, repo, fromHTML), "Экспорт без ID канала не изменение сообщения")
+	found := findMessages(t, repo, repositories.MessageQuery{})
+	require.Len(t, found, 1)
+	assert.Equal(t, int64(777), found[0].ChannelID, "ID канала из JSON-экспорта сохраняется")
+}
+
+func testTagChanges(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t)
+	ctx := context.Background()
+	upsert(t, repo,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"booba", "shy"}, RunID: "run1"},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g2", Tags: []string{"booba"}, RunID: "run1"},
+	)
+	upsert(t, repo,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"shy", "booba"}, RunID: "run2"},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g2", Tags: []string{"stare"}, RunID: "run2"},
+	)
+
+	changes, err := repo.GetTagChanges(ctx, "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1, "Порядок тегов и новые сообщения не изменения")
+	change := changes[0]
+	assert.Equal(t, "2", change.UUID)
+	assert.Equal(t, "message2", change.MessageID)
+	assert.Equal(t, "g2", change.Group)
+	assert.Equal(t, []string{"booba"}, change.PrevTags)
+	assert.Equal(t, []string{"stare"}, change.Tags)
+	assert.Equal(t, []string{"stare"}, change.Added)
+	assert.Equal(t, []string{"booba"}, change.Removed)
+	assert.Equal(t, "run2", change.RunID)
+	assert.WithinDuration(t, time.Now(), change.SeenAt, time.Minute)
+
+	changes, err = repo.GetTagChanges(ctx, "g2", "booba", 10)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+	changes, err = repo.GetTagChanges(ctx, "g1", "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func testGetGroups(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t,
+		models.Message{UUID: "1", Group: "g2"},
+		models.Message{UUID: "2", Group: "g1"},
+		models.Message{UUID: "3", Group: "g2"},
+	)
+	groups, err := repo.GetGroups(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"g1", "g2"}, groups)
+}
+
+// queryFixtures are messages of the query tests: 4 is the oldest, 3 is the newest, 1 and 2 have the same time
+func queryFixtures() []models.Message {
+	deletedAt := now
+	return []models.Message{
+		{UUID: "1", MessageID: "message1", Group: "g1", Datetime: now, Tags: []string{"booba"}},
+		{UUID: "2", MessageID: "message2", Group: "g1", Datetime: now, Tags: []string{"booba", "shy"}},
+		{UUID: "3", MessageID: "message3", Group: "g2", Datetime: now.Add(time.Hour), Tags: []string{"booba"}},
+		{UUID: "4", MessageID: "message4", Group: "g1", Datetime: now.Add(-time.Hour), Tags: []string{"stare"}},
+		{UUID: "5", MessageID: "message5", Group: "g1", Datetime: now, DeletedAt: &deletedAt},
+	}
+}
+
+func testFindMessages(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t, queryFixtures()...)
+	ctx := context.Background()
+
+	assert.Equal(t, []string{"message4", "message1", "message2", "message3"}, findIDs(t, repo, repositories.MessageQuery{}))
+	assert.Equal(t, []string{"message3", "message2", "message1", "message4"},
+		findIDs(t, repo, repositories.MessageQuery{Sort: repositories.SortNewest}))
+	assert.Equal(t, []string{"message1", "message2"},
+		findIDs(t, repo, repositories.MessageQuery{Groups: []string{"g1"}, Tags: []string{"booba"}}))
+	assert.Equal(t, []string{"message1", "message3"},
+		findIDs(t, repo, repositories.MessageQuery{Tags: []string{"booba"}, ExcludeTags: []string{"shy"}}))
+	assert.Equal(t, []string{"message4"},
+		findIDs(t, repo, repositories.MessageQuery{Groups: []string{"g1", "g2"}, ExcludeTags: []string{"booba"}}))
+	assert.Equal(t, []string{"message1", "message2"}, findIDs(t, repo, repositories.MessageQuery{From: now, To: now.Add(time.Hour)}))
+	assert.Equal(t, []string{"message4"}, findIDs(t, repo, repositories.MessageQuery{Limit: 1}))
+	assert.Contains(t, findIDs(t, repo, repositories.MessageQuery{IncludeDeleted: true}), "message5")
+
+	count, err := repo.CountMessages(ctx, repositories.MessageQuery{Groups: []string{"g1"}, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	for _, err := range repo.FindMessages(ctx, repositories.MessageQuery{Cursor: "booba"}) {
+		assert.Error(t, err, "Неверный курсор")
+	}
+}
+
+func testFindMessagesPages(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t, queryFixtures()...)
+	for _, sort := range []repositories.MessageSort{repositories.SortOldest, repositories.SortNewest} {
+		want := findIDs(t, repo, repositories.MessageQuery{Sort: sort})
+		var got []string
+		q := repositories.MessageQuery{Sort: sort, Limit: 2}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3, "Страницы заканчиваются")
+			var last *models.Message
+			for msg, err := range repo.FindMessages(context.Background(), q) {
+				require.NoError(t, err)
+				got = append(got, msg.MessageID)
+				last = msg
+			}
+			if last == nil {
+				break
+			}
+			q.Cursor = repositories.NextCursor(last)
+		}
+		assert.Equal(t, want, got, "Страницы по курсору — все сообщения по порядку")
+	}
+}
+
+func testMarkDeleted(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", RunID: "run1"},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g1", RunID: "run2"},
+		models.Message{UUID: "3", MessageID: "message3", Group: "g1", RunID: "run3"},
+		models.Message{UUID: "4", MessageID: "message4", Group: "g1"},
+		models.Message{UUID: "5", MessageID: "message5", Group: "g2", RunID: "run1"},
+	)
+	ctx := context.Background()
+
+	deleted, err := repo.MarkDeleted(ctx, "g1", []string{"run3", "run2"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted, "Сообщения без run_id и других групп не помечаются")
+	deleted, err = repo.MarkDeleted(ctx, "g1", []string{"run3", "run2"}, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted, "Время удаления не меняется")
+
+	found := slices.DeleteFunc(findMessages(t, repo, repositories.MessageQuery{IncludeDeleted: true}), func(msg *models.Message) bool {
+		return msg.DeletedAt == nil
+	})
+	require.Len(t, found, 1)
+	assert.Equal(t, "message1", found[0].MessageID)
+	require.NotNil(t, found[0].DeletedAt)
+	assert.Equal(t, now, *found[0].DeletedAt)
+	count, err := repo.CountMessages(ctx, repositories.MessageQuery{Groups: []string{"g1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func testMarkSeen(t *testing.T, newRepo NewRepository) {
+	deletedAt := now.Add(-time.Hour)
+	repo := newRepo(t,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", RunID: "run1", Tags: []string{"booba"}},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g1", RunID: "run1", DeletedAt: &deletedAt},
+		models.Message{UUID: "3", MessageID: "message3", Group: "g1", RunID: "run1"},
+	)
+	ctx := context.Background()
+
+	require.NoError(t, repo.MarkSeen(ctx, []string{"1", "2", "unknown"}, "run2"))
+	deleted, err := repo.MarkDeleted(ctx, "g1", []string{"run2"}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted, "Найденные сообщения не помечаются удалёнными")
+
+	found := findMessages(t, repo, repositories.MessageQuery{IncludeDeleted: true})
+	require.Len(t, found, 3)
+	byUUID := make(map[string]*models.Message)
+	for _, msg := range found {
+		byUUID[msg.UUID] = msg
+	}
+	assert.Equal(t, "run2", byUUID["1"].RunID)
+	assert.Equal(t, []string{"booba"}, byUUID["1"].Tags, "Остальные поля не меняются")
+	assert.Equal(t, "run2", byUUID["2"].RunID)
+	assert.Nil(t, byUUID["2"].DeletedAt, "Снова найденное сообщение не удалено")
+	assert.Equal(t, "run1", byUUID["3"].RunID)
+	assert.NotNil(t, byUUID["3"].DeletedAt)
+}
+
+func testUpdateTags(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t, models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"Booba"}})
+	ctx := context.Background()
+
+	require.NoError(t, repo.UpdateTags(ctx, "1", []string{"Booba"}, []string{"booba"}))
+	require.NoError(t, repo.UpdateTags(ctx, "unknown", nil, nil), "Несуществующее сообщение не ошибка")
+
+	found := findMessages(t, repo, repositories.MessageQuery{})
+	require.Len(t, found, 1)
+	assert.Equal(t, []string{"Booba"}, found[0].RawTags)
+	assert.Equal(t, []string{"booba"}, found[0].Tags)
+
+	changes, err := repo.GetTagChanges(ctx, "g1", "", 0)
+	require.NoError(t, err)
+	require.Len(t, changes, 1, "Изменение тегов вне запуска попадает в историю")
+	assert.Equal(t, "1", changes[0].UUID)
+	assert.Equal(t, "message1", changes[0].MessageID)
+	assert.Equal(t, []string{"Booba"}, changes[0].PrevTags)
+	assert.Equal(t, []string{"booba"}, changes[0].Added)
+	assert.Equal(t, []string{"Booba"}, changes[0].Removed)
+	assert.Empty(t, changes[0].RunID)
+
+	require.NoError(t, repo.UpdateTags(ctx, "1", []string{"Booba"}, []string{"booba"}))
+	changes, err = repo.GetTagChanges(ctx, "g1", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1, "Те же теги не изменение")
+}
+
+func testServiceEvents(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t)
+	ctx := context.Background()
+	events := []models.ServiceEvent{
+		{UUID: "e1", MessageID: "service2", Group: "g1", Type: models.ServiceEventPin, Datetime: now},
+		{UUID: "e2", MessageID: "service1", Group: "g1", Type: models.ServiceEventDate, Datetime: now},
+		{UUID: "e3", MessageID: "service3", Group: "g2", Type: models.ServiceEventPin, Datetime: now.Add(-time.Hour)},
+	}
+	require.NoError(t, repo.UpsertServiceEvents(ctx, events))
+	events[0].Text = "pinned"
+	require.NoError(t, repo.UpsertServiceEvents(ctx, events[:1]))
+	require.NoError(t, repo.UpsertServiceEvents(ctx, nil))
+
+	found := collect(t, repo.GetServiceEvents(ctx, ""))
+	require.Len(t, found, 3, "События сохраняются по UUID")
+	assert.Equal(t, []string{"service3", "service1", "service2"},
+		[]string{found[0].MessageID, found[1].MessageID, found[2].MessageID})
+	assert.Equal(t, "pinned", found[2].Text)
+
+	found = collect(t, repo.GetServiceEvents(ctx, "g1", models.ServiceEventPin))
+	require.Len(t, found, 1)
+	assert.Equal(t, "e1", found[0].UUID)
+}
+
+func testManifestEntries(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t)
+	ctx := context.Background()
+
+	entry, err := repo.GetManifestEntry(ctx, "var/data/g1/messages.html")
+	require.NoError(t, err)
+	assert.Nil(t, entry, "Несохранённого файла нет в манифесте")
+
+	saved := models.ManifestEntry{Path: "var/data/g1/messages.html", Size: 100, ModTime: now, Hash: "hash1", Messages: 5, RunID: "run1"}
+	require.NoError(t, repo.UpsertManifestEntries(ctx, []models.ManifestEntry{saved}))
+	saved.Hash, saved.RunID = "hash2", "run2"
+	require.NoError(t, repo.UpsertManifestEntries(ctx, []models.ManifestEntry{saved}))
+
+	entry, err = repo.GetManifestEntry(ctx, "var/data/g1/messages.html")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, saved, *entry)
+}
+
+func testIngestRuns(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t)
+	ctx := context.Background()
+
+	run, err := repo.GetIngestRun(ctx, "run1")
+	require.NoError(t, err)
+	assert.Nil(t, run, "Несохранённого запуска нет")
+
+	for i, id := range []string{"run1", "run2", "run3"} {
+		require.NoError(t, repo.SaveIngestRun(ctx, models.IngestRun{
+			RunID:     id,
+			StartedAt: now.Add(time.Duration(i) * time.Hour),
+			Files:     []models.IngestRunFile{{Path: "var/data/g1/messages.html", Status: models.IngestFileParsed}},
+		}))
+	}
+	saved := models.IngestRun{
+		RunID:            "run1",
+		StartedAt:        now,
+		FinishedAt:       now.Add(time.Minute),
+		ConfigHash:       "hash1",
+		FilesSeen:        2,
+		MessagesUpserted: 5,
+		SkipReasons:      []models.SkipReasonCount{{Reason: "no date", Count: 1}},
+		Files:            []models.IngestRunFile{{Path: "var/data/g1/messages.html", Status: models.IngestFileParsed, Messages: 5}},
+	}
+	require.NoError(t, repo.SaveIngestRun(ctx, saved))
+
+	run, err = repo.GetIngestRun(ctx, "run1")
+	require.NoError(t, err)
+	require.NotNil(t, run)
+	assert.Equal(t, saved, *run, "Запуск обновляется по run_id")
+
+	runs, err := repo.GetIngestRuns(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "run3", runs[0].RunID, "Последние запуски первыми")
+	assert.Equal(t, "run2", runs[1].RunID)
+	assert.Nil(t, runs[0].Files, "Список не содержит файлов")
+}
+
+func testTagCountsByMediaKind(t *testing.T, newRepo NewRepository) {
+	photo, video := models.Media{Kind: models.MediaKindPhoto}, models.Media{Kind: models.MediaKindVideo}
+	repo := newRepo(t,
+		models.Message{UUID: "1", Group: "g1", Tags: []string{"booba", "shy", "booba"}, Media: []models.Media{photo, photo, video}},
+		models.Message{UUID: "2", Group: "g1", Tags: []string{"booba"}, Media: []models.Media{photo}},
+		models.Message{UUID: "3", Group: "g1", Tags: []string{"booba"}},
+		models.Message{UUID: "4", Group: "g2", Tags: []string{"booba"}, Media: []models.Media{photo}},
+	)
+	counts, err := repo.GetTagCountsByMediaKind(context.Background(), "g1")
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagMediaKindCount{
+		{Tag: "booba", Kind: models.MediaKindPhoto, Count: 2},
+		{Tag: "booba", Kind: models.MediaKindVideo, Count: 1},
+		{Tag: "shy", Kind: models.MediaKindPhoto, Count: 1},
+		{Tag: "shy", Kind: models.MediaKindVideo, Count: 1},
+	}, counts)
+}
+
+func testMostReactedTags(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t,
+		models.Message{UUID: "1", Group: "g1", Tags: []string{"booba", "shy"}, Reactions: map[string]int{"👍": 3, "🔥": 2}},
+		models.Message{UUID: "2", Group: "g1", Tags: []string{"booba"}, Reactions: map[string]int{"👍": 1}},
+		models.Message{UUID: "3", Group: "g1", Tags: []string{"stare"}},
+		models.Message{UUID: "4", Group: "g2", Tags: []string{"stare"}, Reactions: map[string]int{"👍": 10}},
+	)
+	ctx := context.Background()
+	tags, err := repo.GetMostReactedTags(ctx, "g1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagReactions{
+		{Tag: "booba", Reactions: 6, Messages: 2},
+		{Tag: "shy", Reactions: 5, Messages: 1},
+	}, tags)
+
+	tags, err = repo.GetMostReactedTags(ctx, "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagReactions{{Tag: "stare", Reactions: 10, Messages: 1}}, tags)
+}
+
+func testEditedMessages(t *testing.T, newRepo NewRepository) {
+	edited1, edited2 := now.Add(time.Hour), now.Add(2*time.Hour)
+	repo := newRepo(t,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Edited: &edited1},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g1"},
+		models.Message{UUID: "3", MessageID: "message3", Group: "g1", Edited: &edited2},
+		models.Message{UUID: "4", MessageID: "message4", Group: "g2", Edited: &edited2},
+	)
+	found := collect(t, repo.GetEditedMessages(context.Background(), "g1"))
+	assert.Equal(t, []string{"message3", "message1"}, messageIDs(found), "Последние изменённые первыми")
+}
+
+func testReplyChains(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t,
+		models.Message{UUID: "1", MessageID: "message1", Group: "g1", Datetime: now, Tags: []string{"a"}},
+		models.Message{UUID: "2", MessageID: "message2", Group: "g1", Datetime: now.Add(time.Minute), Tags: []string{"b"}, ReplyTo: "message1"},
+		models.Message{UUID: "3", MessageID: "message3", Group: "g1", Datetime: now.Add(2 * time.Minute), Tags: []string{"c"}, ReplyTo: "message2"},
+		// ответ на сообщение без тегов и ответ без тегов не в цепочках
+		models.Message{UUID: "4", MessageID: "message4", Group: "g1", Datetime: now},
+		models.Message{UUID: "5", MessageID: "message5", Group: "g1", Datetime: now, Tags: []string{"d"}, ReplyTo: "message4"},
+		models.Message{UUID: "6", MessageID: "message6", Group: "g1", Datetime: now, ReplyTo: "message1"},
+		// тот же ID в другой группе
+		models.Message{UUID: "7", MessageID: "message2", Group: "g2", Datetime: now, Tags: []string{"e"}, ReplyTo: "message1"},
+	)
+	chains, err := repo.GetReplyChains(context.Background(), "g1")
+	require.NoError(t, err)
+	require.Len(t, chains, 1)
+	assert.Equal(t, "g1", chains[0].Group)
+	var ids []string
+	for _, item := range chains[0].Messages {
+		ids = append(ids, item.MessageID)
+	}
+	assert.Equal(t, []string{"message1", "message2", "message3"}, ids)
+}
+
+// statsFixtures are messages of the tag statistics tests, the deleted message is not counted
+func statsFixtures() []models.Message {
+	deletedAt := now
+	return []models.Message{
+		{UUID: "1", Group: "g1", Datetime: now, Tags: []string{"booba", "shy"}},
+		{UUID: "2", Group: "g1", Datetime: now.Add(time.Hour), Tags: []string{"booba"}},
+		{UUID: "3", Group: "g1", Datetime: now.Add(-time.Hour)},
+		{UUID: "4", Group: "g2", Datetime: now.Add(48 * time.Hour), Tags: []string{"stare", "booba"}},
+		{UUID: "5", Group: "g1", Datetime: now.Add(-48 * time.Hour), Tags: []string{"booba"}, DeletedAt: &deletedAt},
+	}
+}
+
+func testTagCounts(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t, statsFixtures()...)
+	ctx := context.Background()
+
+	counts, err := repo.GetTagCounts(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagCount{
+		{Group: "g1", Tag: "booba", Count: 2},
+		{Group: "g1", Tag: "shy", Count: 1},
+		{Group: "g2", Tag: "booba", Count: 1},
+		{Group: "g2", Tag: "stare", Count: 1},
+	}, counts)
+
+	counts, err = repo.GetTagCounts(ctx, "g2")
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagCount{{Group: "g2", Tag: "booba", Count: 1}, {Group: "g2", Tag: "stare", Count: 1}}, counts)
+}
+
+func testTagCountsByPeriod(t *testing.T, newRepo NewRepository) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	date := func(year int, month time.Month, day, hour, minute int, loc *time.Location) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	deletedAt := now
+	repo := newRepo(t,
+		// четверг 21.11 в Москве и в UTC
+		models.Message{UUID: "1", Group: "g1", Datetime: date(2024, time.November, 21, 10, 0, time.UTC), Tags: []string{"booba", "shy"}},
+		// четверг 21.11 в UTC, но пятница 22.11 в Москве
+		models.Message{UUID: "2", Group: "g1", Datetime: date(2024, time.November, 21, 22, 30, time.UTC), Tags: []string{"booba"}},
+		// понедельник 25.11
+		models.Message{UUID: "3", Group: "g1", Datetime: date(2024, time.November, 25, 10, 0, time.UTC), Tags: []string{"booba"}},
+		// 30.11 в UTC, но воскресенье 1.12 в Москве
+		models.Message{UUID: "4", Group: "g1", Datetime: date(2024, time.November, 30, 22, 0, time.UTC), Tags: []string{"booba"}},
+		models.Message{UUID: "5", Group: "g2", Datetime: date(2024, time.November, 21, 10, 0, time.UTC), Tags: []string{"booba"}},
+		models.Message{UUID: "6", Group: "g1", Datetime: date(2024, time.November, 21, 10, 0, time.UTC), Tags: []string{"booba"}, DeletedAt: &deletedAt},
+	)
+	ctx := context.Background()
+
+	counts, err := repo.GetTagCountsByPeriod(ctx, "g1", repositories.PeriodDay, moscow)
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagPeriodCount{
+		{Tag: "booba", Start: date(2024, time.November, 21, 0, 0, moscow), Count: 1},
+		{Tag: "shy", Start: date(2024, time.November, 21, 0, 0, moscow), Count: 1},
+		{Tag: "booba", Start: date(2024, time.November, 22, 0, 0, moscow), Count: 1},
+		{Tag: "booba", Start: date(2024, time.November, 25, 0, 0, moscow), Count: 1},
+		{Tag: "booba", Start: date(2024, time.December, 1, 0, 0, moscow), Count: 1},
+	}, counts, "Дни в часовом поясе")
+
+	counts, err = repo.GetTagCountsByPeriod(ctx, "g1", repositories.PeriodWeek, moscow)
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagPeriodCount{
+		{Tag: "booba", Start: date(2024, time.November, 18, 0, 0, moscow), Count: 2},
+		{Tag: "shy", Start: date(2024, time.November, 18, 0, 0, moscow), Count: 1},
+		{Tag: "booba", Start: date(2024, time.November, 25, 0, 0, moscow), Count: 2},
+	}, counts, "Недели с понедельника")
+
+	counts, err = repo.GetTagCountsByPeriod(ctx, "g1", repositories.PeriodMonth, moscow)
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagPeriodCount{
+		{Tag: "booba", Start: date(2024, time.November, 1, 0, 0, moscow), Count: 3},
+		{Tag: "shy", Start: date(2024, time.November, 1, 0, 0, moscow), Count: 1},
+		{Tag: "booba", Start: date(2024, time.December, 1, 0, 0, moscow), Count: 1},
+	}, counts)
+
+	counts, err = repo.GetTagCountsByPeriod(ctx, "", repositories.PeriodMonth, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagPeriodCount{
+		{Tag: "booba", Start: date(2024, time.November, 1, 0, 0, time.UTC), Count: 5},
+		{Tag: "shy", Start: date(2024, time.November, 1, 0, 0, time.UTC), Count: 1},
+	}, counts, "Без часового пояса — UTC")
+
+	_, err = repo.GetTagCountsByPeriod(ctx, "g1", "year", moscow)
+	assert.ErrorContains(t, err, "unknown period")
+	_, err = repo.GetTagCountsByPeriod(ctx, "g1", repositories.PeriodDay, time.Local)
+	assert.Error(t, err, "У time.Local нет имени IANA")
+	_, err = repo.GetTagCountsByPeriod(ctx, "g1", repositories.PeriodDay, time.FixedZone("UTC+3", 3*3600))
+	assert.ErrorContains(t, err, "has no IANA name", "У фиксированного пояса нет имени IANA")
+}
+
+func testTagsHistogram(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t, statsFixtures()...)
+	ctx := context.Background()
+
+	histogram, err := repo.GetTagsHistogram(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagsHistogramBucket{
+		{Tags: 0, Messages: 1},
+		{Tags: 1, Messages: 1},
+		{Tags: 2, Messages: 2},
+	}, histogram)
+
+	histogram, err = repo.GetTagsHistogram(ctx, "g2")
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagsHistogramBucket{{Tags: 2, Messages: 1}}, histogram)
+}
+
+func testTagSpans(t *testing.T, newRepo NewRepository) {
+	repo := newRepo(t, statsFixtures()...)
+	ctx := context.Background()
+
+	spans, err := repo.GetTagSpans(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []repositories.TagSpan{
+		{Tag: "booba", FirstSeen: now, LastSeen: now.Add(48 * time.Hour), Messages: 3},
+		{Tag: "shy", FirstSeen: now, LastSeen: now, Messages: 1},
+		{Tag: "stare", FirstSeen: now.Add(48 * time.Hour), LastSeen: now.Add(48 * time.Hour), Messages: 1},
+	}, spans, "Удалённые сообщения не учитываются")
+
+	spans, err = repo.GetTagSpans(ctx, "g1")
+	require.NoError(t, err)
+	require.Len(t, spans, 2)
+	assert.Equal(t, now.Add(time.Hour), spans[0].LastSeen)
+}
@@ -0,0 +1,73 @@
+package repositories
+
+import "time"
+
+// TagMediaKindCount is a number of messages with the tag and the media kind
+type TagMediaKindCount struct {
+	Tag   string `bson:"tag" json:"tag"`
+	Kind  string `bson:"kind" json:"kind"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// TagReactions is a total of reactions on messages with the tag
+type TagReactions struct {
+	Tag       string `bson:"tag" json:"tag"`
+	Reactions int    `bson:"reactions" json:"reactions"`
+	Messages  int    `bson:"messages" json:"messages"`
+}
+
+// ReplyChain is a thread of tagged messages replying to each other, from the first message to the last reply
+type ReplyChain struct {
+	Group    string           `json:"group"`
+	Messages []ReplyChainItem `json:"messages"`
+}
+
+// ReplyChainItem is a message of ReplyChain
+type ReplyChainItem struct {
+	MessageID string    `bson:"message_id" json:"messageID"`
+	Datetime  time.Time `bson:"datetime" json:"datetime"`
+	Tags      []string  `bson:"tags" json:"tags"`
+}
+
+// IngestResult is the outcome of saving messages
+type IngestResult struct {
+	Inserted int `json:"inserted"`
+	Modified int `json:"modified"`
+	// Unchanged messages are found but have the same fields
+	Unchanged int `json:"unchanged"`
+	// Failed messages are not saved
+	Failed int `json:"failed"`
+}
+
+// Total returns the number of messages written
+func (r IngestResult) Total() int {
+	return r.Inserted + r.Modified + r.Unchanged + r.Failed
+}
+
+// TagCount is a number of messages of the group with the tag
+type TagCount struct {
+	Group string `bson:"group" json:"group"`
+	Tag   string `bson:"tag" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// TagPeriodCount is a number of messages with the tag in the period starting at Start
+type TagPeriodCount struct {
+	Tag   string    `bson:"tag" json:"tag"`
+	Start time.Time `bson:"start" json:"start"`
+	Count int       `bson:"count" json:"count"`
+}
+
+// TagsHistogramBucket is a number of messages with Tags tags
+type TagsHistogramBucket struct {
+	Tags     int `bson:"tags" json:"tags"`
+	Messages int `bson:"messages" json:"messages"`
+}
+
+// TagSpan is the datetime of the first and the last message with the tag
+type TagSpan struct {
+	Tag       string    `bson:"tag" json:"tag"`
+	FirstSeen time.Time `bson:"first_seen" json:"firstSeen"`
+	LastSeen  time.Time `bson:"last_seen" json:"lastSeen"`
+	Messages  int       `bson:"messages" json:"messages"`
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"strings"
+
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// messageWhere returns the condition of the query on the messages table m and its arguments.
+// The cursor is skipped without withCursor, as CountMessages does.
+func messageWhere(q repositories.MessageQuery, withCursor bool) (string, []any, error) {
+	var conds []string
+	var args []any
+	if !q.IncludeDeleted {
+		conds = append(conds, "m.deleted_at IS NULL")
+	}
+	if len(q.Groups) > 0 {
+		conds = append(conds, "m.grp IN ("+placeholders(len(q.Groups))+")")
+		for _, group := range q.Groups {
+			args = append(args, group)
+		}
+	}
+	for _, tag := range q.Tags {
+		conds = append(conds, "EXISTS (SELECT 1 FROM message_tags t WHERE t.uuid = m.uuid AND t.tag = ?)")
+		args = append(args, tag)
+	}
+	if len(q.ExcludeTags) > 0 {
+		conds = append(conds, "NOT EXISTS (SELECT 1 FROM message_tags t WHERE t.uuid = m.uuid AND t.tag IN ("+placeholders(len(q.ExcludeTags))+"))")
+		for _, tag := range q.ExcludeTags {
+			args = append(args, tag)
+		}
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "m.datetime >= ?")
+		args = append(args, q.From.UnixMilli())
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "m.datetime < ?")
+		args = append(args, q.To.UnixMilli())
+	}
+	if withCursor {
+		last, err := q.CursorMessage()
+		if err != nil {
+			return "", nil, err
+		}
+		if last != nil {
+			op := ">"
+			if q.Sort == repositories.SortNewest {
+				op = "<"
+			}
+			d := last.Datetime.UnixMilli()
+			conds = append(conds, "(m.datetime "+op+" ? OR (m.datetime = ? AND m.uuid "+op+" ?))")
+			args = append(args, d, d, last.UUID)
+		}
+	}
+	if len(conds) == 0 {
+		return "1", nil, nil
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// messageOrder is ORDER BY of the query sort
+func messageOrder(q repositories.MessageQuery) string {
+	if q.Sort == repositories.SortNewest {
+		return "m.datetime DESC, m.uuid DESC"
+	}
+	return "m.datetime, m.uuid"
+}
+
+// limit is LIMIT of SQLite, -1 is no limit
+func limit(n int) int {
+	if n <= 0 {
+		return -1
+	}
+	return n
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
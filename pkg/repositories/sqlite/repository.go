@@ -0,0 +1,684 @@
+// Package sqlite is the repository keeping messages in a SQLite file, for installations without a MongoDB server
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	_ "modernc.org/sqlite"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// batchSize is the number of messages UpsertMany writes in one transaction
+const batchSize = 100
+
+// Repository is repositories.Repository with the semantics of repositories.MessageRepository, it is safe for concurrent use
+type Repository struct {
+	log *slog.Logger
+	db  *sql.DB
+}
+
+// Open opens the database file, creating it and its tables if needed
+func Open(log *slog.Logger, path string) (*Repository, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// WAL lets FindMessages read while the caller writes, immediate transactions wait for each other instead of failing
+	dsn := "file:" + path + "?_txlock=immediate&_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		return nil, errors.Join(fmt.Errorf("create schema failed: %w", err), db.Close())
+	}
+	return &Repository{log: log, db: db}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// messageColumns are scanned by scanMessage
+const messageColumns = "m.id, m.deleted_at, m.doc"
+
+// FindMessages returns messages of the query one by one, the iteration stops at the first error
+func (r *Repository) FindMessages(ctx context.Context, q repositories.MessageQuery) iter.Seq2[*models.Message, error] {
+	where, args, err := messageWhere(q, true)
+	if err != nil {
+		return func(yield func(*models.Message, error) bool) {
+			yield(nil, err)
+		}
+	}
+	query := "SELECT " + messageColumns + " FROM messages m WHERE " + where + " ORDER BY " + messageOrder(q) + " LIMIT ?"
+	return queryRows(ctx, r.db, scanMessage, query, append(args, limit(q.Limit))...)
+}
+
+// queryRows returns rows of the query one by one, the iteration stops at the first error
+func queryRows[T any](ctx context.Context, db *sql.DB, scan func(row rowScanner) (T, error), query string, args ...any) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			yield(zero, fmt.Errorf("find failed: %w", err))
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			item, err := scan(rows)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// CountMessages counts messages of the query, Sort, Limit and Cursor are ignored
+func (r *Repository) CountMessages(ctx context.Context, q repositories.MessageQuery) (int, error) {
+	where, args, err := messageWhere(q, false)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM messages m WHERE "+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count failed: %w", err)
+	}
+	return count, nil
+}
+
+// UpsertMany saves messages by UUID as the MongoDB saver does: all fields but _id and uuid are set, deleted_at is unset.
+// Messages are written by transactions of batchSize or of the messages queued in messagesChan.
+func (r *Repository) UpsertMany(messagesChan <-chan models.Message) (repositories.IngestResult, error) {
+	var result repositories.IngestResult
+	var errs []error
+	batch := make([]models.Message, 0, batchSize)
+	for msg := range messagesChan {
+		batch = append(batch, msg)
+		if len(batch) < batchSize && len(messagesChan) > 0 {
+			continue
+		}
+		if err := r.upsertBatch(batch, &result); err != nil {
+			r.log.Error("batch write failed", "messages", len(batch), "err", err)
+			errs = append(errs, err)
+		}
+		batch = batch[:0]
+	}
+	r.log.Debug("messages saved to SQLite", "inserted", result.Inserted, "modified", result.Modified,
+		"unchanged", result.Unchanged, "failed", result.Failed)
+	return result, errors.Join(errs...)
+}
+
+// upsertBatch writes the messages in one transaction, they all fail if it fails
+func (r *Repository) upsertBatch(batch []models.Message, result *repositories.IngestResult) error {
+	var batchResult repositories.IngestResult
+	err := r.inTx(context.Background(), func(tx *sql.Tx) error {
+		for _, msg := range batch {
+			if err := upsertMessage(tx, msg, &batchResult); err != nil {
+				return fmt.Errorf("message %s: %w", msg.UUID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		result.Failed += len(batch)
+		return err
+	}
+	result.Inserted += batchResult.Inserted
+	result.Modified += batchResult.Modified
+	result.Unchanged += batchResult.Unchanged
+	return nil
+}
+
+func upsertMessage(tx *sql.Tx, msg models.Message, result *repositories.IngestResult) error {
+	saved, err := clone(msg)
+	if err != nil {
+		return err
+	}
+	saved.DeletedAt = nil
+	prev, err := getMessage(tx, saved.UUID)
+	if err != nil {
+		return err
+	}
+	if prev == nil {
+		saved.ID = primitive.NewObjectID()
+		result.Inserted++
+		return saveMessage(tx, saved)
+	}
+	saved.ID = prev.ID
+	// как в MongoDB, новый run_id не изменение сообщения, а пустой не записывается
+	saved.RunID = cmp.Or(saved.RunID, prev.RunID)
+	// ID канала из JSON-экспорта не стирается HTML-экспортом той же группы
+	saved.ChannelID = cmp.Or(saved.ChannelID, prev.ChannelID)
+	prevRunID := prev.RunID
+	prev.RunID = saved.RunID
+	if reflect.DeepEqual(*prev, saved) {
+		result.Unchanged++
+		if prevRunID == saved.RunID {
+			return nil
+		}
+		return saveMessage(tx, saved)
+	}
+	if err := saveMessage(tx, saved); err != nil {
+		return err
+	}
+	result.Modified++
+
+	added, removed := repositories.DiffTags(prev.Tags, saved.Tags)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	return saveTagChange(tx, models.TagChange{
+		UUID:      saved.UUID,
+		MessageID: saved.MessageID,
+		Group:     saved.Group,
+		PrevTags:  prev.Tags,
+		Tags:      saved.Tags,
+		Added:     added,
+		Removed:   removed,
+		SeenAt:    now(),
+		RunID:     saved.RunID,
+	})
+}
+
+// Save saves the message as it is, as InsertOne does, the message with the same UUID is replaced
+func (r *Repository) Save(ctx context.Context, msg models.Message) error {
+	saved, err := clone(msg)
+	if err != nil {
+		return err
+	}
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if saved.ID.IsZero() {
+			saved.ID = primitive.NewObjectID()
+			prev, err := getMessage(tx, saved.UUID)
+			if err != nil {
+				return err
+			}
+			if prev != nil {
+				saved.ID = prev.ID
+			}
+		}
+		return saveMessage(tx, saved)
+	})
+}
+
+func (r *Repository) GetGroups(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT grp FROM messages ORDER BY grp")
+	if err != nil {
+		return nil, fmt.Errorf("distinct failed: %w", err)
+	}
+	return scanAll(rows, func(row rowScanner) (string, error) {
+		var group string
+		err := row.Scan(&group)
+		return group, err
+	})
+}
+
+// GetTagCountsByMediaKind counts messages per tag and media kind, every tag and kind is counted once per message
+func (r *Repository) GetTagCountsByMediaKind(ctx context.Context, group string) ([]repositories.TagMediaKindCount, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.tag, k.kind, COUNT(DISTINCT m.uuid) AS count
+		FROM messages m
+		JOIN message_tags t ON t.uuid = m.uuid
+		JOIN message_media_kinds k ON k.uuid = m.uuid
+		WHERE m.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+		GROUP BY t.tag, k.kind
+		ORDER BY count DESC, t.tag, k.kind`, group, group)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	return scanAll(rows, func(row rowScanner) (repositories.TagMediaKindCount, error) {
+		var item repositories.TagMediaKindCount
+		err := row.Scan(&item.Tag, &item.Kind, &item.Count)
+		return item, err
+	})
+}
+
+// GetMostReactedTags sums reactions of all kinds on messages per tag, limit <= 0 means no limit
+func (r *Repository) GetMostReactedTags(ctx context.Context, group string, n int) ([]repositories.TagReactions, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.tag, SUM(m.reactions) AS reactions, COUNT(*)
+		FROM messages m
+		JOIN message_tags t ON t.uuid = m.uuid
+		WHERE m.deleted_at IS NULL AND m.reactions IS NOT NULL AND (? = '' OR m.grp = ?)
+		GROUP BY t.tag
+		ORDER BY reactions DESC, t.tag
+		LIMIT ?`, group, group, limit(n))
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	return scanAll(rows, func(row rowScanner) (repositories.TagReactions, error) {
+		var item repositories.TagReactions
+		err := row.Scan(&item.Tag, &item.Reactions, &item.Messages)
+		return item, err
+	})
+}
+
+// GetReplyChains returns threads of tagged messages replying to tagged messages of the same group
+func (r *Repository) GetReplyChains(ctx context.Context, group string) ([]repositories.ReplyChain, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT m.grp, m.doc, p.doc
+		FROM messages m
+		JOIN messages p ON p.grp = m.grp AND p.message_id = m.reply_to
+		WHERE m.reply_to <> '' AND m.deleted_at IS NULL AND p.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+			AND EXISTS (SELECT 1 FROM message_tags t WHERE t.uuid = m.uuid)
+			AND EXISTS (SELECT 1 FROM message_tags t WHERE t.uuid = p.uuid)
+		ORDER BY m.rowid, p.rowid`, group, group)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	edges, err := scanAll(rows, func(row rowScanner) (repositories.ReplyEdge, error) {
+		var edge repositories.ReplyEdge
+		var msgDoc, parentDoc []byte
+		if err := row.Scan(&edge.Group, &msgDoc, &parentDoc); err != nil {
+			return edge, err
+		}
+		if err := bson.Unmarshal(msgDoc, &edge.Message); err != nil {
+			return edge, err
+		}
+		return edge, bson.Unmarshal(parentDoc, &edge.Parent)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repositories.BuildReplyChains(edges), nil
+}
+
+// GetEditedMessages returns edited messages one by one, the last edited first, the iteration stops at the first error
+func (r *Repository) GetEditedMessages(ctx context.Context, group string) iter.Seq2[*models.Message, error] {
+	return queryRows(ctx, r.db, scanMessage, "SELECT "+messageColumns+` FROM messages m
+		WHERE m.edited IS NOT NULL AND m.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+		ORDER BY m.edited DESC, m.uuid`, group, group)
+}
+
+// MarkDeleted sets deleted_at of the group's messages that are saved by runs other than keepRunIDs.
+// Messages without run_id are saved before runs were recorded, they are not marked.
+func (r *Repository) MarkDeleted(ctx context.Context, group string, keepRunIDs []string, deletedAt time.Time) (int, error) {
+	query := "UPDATE messages SET deleted_at = ? WHERE grp = ? AND run_id <> '' AND deleted_at IS NULL"
+	args := []any{deletedAt.UnixMilli(), group}
+	if len(keepRunIDs) > 0 {
+		query += " AND run_id NOT IN (" + placeholders(len(keepRunIDs)) + ")"
+		for _, id := range keepRunIDs {
+			args = append(args, id)
+		}
+	}
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("update failed: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// MarkSeen sets run_id of the saved messages to the run that has found them in unchanged files, deleted_at is removed
+func (r *Repository) MarkSeen(ctx context.Context, uuids []string, runID string) error {
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		for _, uuid := range uuids {
+			msg, err := getMessage(tx, uuid)
+			if err != nil {
+				return err
+			}
+			if msg == nil {
+				continue
+			}
+			msg.RunID, msg.DeletedAt = runID, nil
+			if err := saveMessage(tx, *msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	return nil
+}
+
+// UpdateTags sets raw and normalized tags of the message, the change of tags is added to the history without a run
+func (r *Repository) UpdateTags(ctx context.Context, uuid string, rawTags, tags []string) error {
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		msg, err := getMessage(tx, uuid)
+		if err != nil || msg == nil {
+			return err
+		}
+		change, changed := repositories.NewTagChange(*msg, tags, "", now())
+		msg.RawTags, msg.Tags = rawTags, tags
+		saved, err := clone(*msg)
+		if err != nil {
+			return err
+		}
+		if err := saveMessage(tx, saved); err != nil || !changed {
+			return err
+		}
+		return saveTagChange(tx, change)
+	})
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	return nil
+}
+
+// UpsertServiceEvents saves service events by UUID
+func (r *Repository) UpsertServiceEvents(ctx context.Context, events []models.ServiceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		for _, e := range events {
+			saved, err := clone(e)
+			if err != nil {
+				return err
+			}
+			saved.ID = primitive.ObjectID{}
+			doc, err := bson.Marshal(saved)
+			if err != nil {
+				return err
+			}
+			id := primitive.NewObjectID()
+			_, err = tx.Exec(`INSERT INTO service_events (uuid, id, grp, type, datetime, message_id, doc) VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (uuid) DO UPDATE SET grp = excluded.grp, type = excluded.type, datetime = excluded.datetime,
+					message_id = excluded.message_id, doc = excluded.doc`,
+				saved.UUID, id[:], saved.Group, saved.Type, saved.Datetime.UnixMilli(), saved.MessageID, doc)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("bulk write failed: %w", err)
+	}
+	return nil
+}
+
+// GetServiceEvents returns service events one by one in the order of time, no types means all types.
+// The iteration stops at the first error.
+func (r *Repository) GetServiceEvents(ctx context.Context, group string, types ...string) iter.Seq2[*models.ServiceEvent, error] {
+	query := "SELECT id, doc FROM service_events WHERE (? = '' OR grp = ?)"
+	args := []any{group, group}
+	if len(types) > 0 {
+		query += " AND type IN (" + placeholders(len(types)) + ")"
+		for _, t := range types {
+			args = append(args, t)
+		}
+	}
+	return queryRows(ctx, r.db, func(row rowScanner) (*models.ServiceEvent, error) {
+		var id, doc []byte
+		if err := row.Scan(&id, &doc); err != nil {
+			return nil, err
+		}
+		var e models.ServiceEvent
+		if err := bson.Unmarshal(doc, &e); err != nil {
+			return nil, err
+		}
+		copy(e.ID[:], id)
+		return &e, nil
+	}, query+" ORDER BY datetime, message_id", args...)
+}
+
+// GetManifestEntry returns the saved export file, nil if the file has not been saved
+func (r *Repository) GetManifestEntry(ctx context.Context, path string) (*models.ManifestEntry, error) {
+	return getDocument[models.ManifestEntry](ctx, r.db, "SELECT doc FROM manifest WHERE path = ?", path)
+}
+
+// UpsertManifestEntries saves export files by path
+func (r *Repository) UpsertManifestEntries(ctx context.Context, entries []models.ManifestEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		for _, e := range entries {
+			doc, err := bson.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT OR REPLACE INTO manifest (path, doc) VALUES (?, ?)", e.Path, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("bulk write failed: %w", err)
+	}
+	return nil
+}
+
+// SaveIngestRun saves the run by its ID, it is saved at the start and at the end of the run
+func (r *Repository) SaveIngestRun(ctx context.Context, run models.IngestRun) error {
+	doc, err := bson.Marshal(run)
+	if err == nil {
+		_, err = r.db.ExecContext(ctx, "INSERT OR REPLACE INTO ingest_runs (run_id, started_at, doc) VALUES (?, ?, ?)",
+			run.RunID, run.StartedAt.UnixMilli(), doc)
+	}
+	if err != nil {
+		return fmt.Errorf("replace failed: %w", err)
+	}
+	return nil
+}
+
+// GetIngestRuns returns runs without their files, the last started first, limit <= 0 means no limit
+func (r *Repository) GetIngestRuns(ctx context.Context, n int) ([]*models.IngestRun, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM ingest_runs ORDER BY started_at DESC, rowid LIMIT ?", limit(n))
+	if err != nil {
+		return nil, err
+	}
+	return scanAll(rows, func(row rowScanner) (*models.IngestRun, error) {
+		run, err := scanDocument[models.IngestRun](rows)
+		if run != nil {
+			run.Files = nil
+		}
+		return run, err
+	})
+}
+
+// GetIngestRun returns the run with its files, nil if there is no such run
+func (r *Repository) GetIngestRun(ctx context.Context, runID string) (*models.IngestRun, error) {
+	return getDocument[models.IngestRun](ctx, r.db, "SELECT doc FROM ingest_runs WHERE run_id = ?", runID)
+}
+
+// GetTagChanges returns tag changes of the group where the tag is added or removed, the last seen first.
+// The empty group or tag means any, limit <= 0 means no limit.
+func (r *Repository) GetTagChanges(ctx context.Context, group, tag string, n int) ([]*models.TagChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT h.doc FROM tag_history h
+		WHERE (? = '' OR h.grp = ?)
+			AND (? = '' OR EXISTS (SELECT 1 FROM tag_history_tags t WHERE t.tag = ? AND t.change_id = h.id))
+		ORDER BY h.seen_at DESC, h.id DESC
+		LIMIT ?`, group, group, tag, tag, limit(n))
+	if err != nil {
+		return nil, fmt.Errorf("find failed: %w", err)
+	}
+	return scanAll(rows, scanDocument[models.TagChange])
+}
+
+func (r *Repository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// getMessage returns the saved message, nil if there is no such message
+func getMessage(tx *sql.Tx, uuid string) (*models.Message, error) {
+	msg, err := scanMessage(tx.QueryRow("SELECT "+messageColumns+" FROM messages m WHERE m.uuid = ?", uuid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return msg, err
+}
+
+// saveMessage inserts or replaces the message with its tags, the message is cloned, its ID is set
+func saveMessage(tx *sql.Tx, msg models.Message) error {
+	id, deletedAt := msg.ID, msg.DeletedAt
+	msg.ID, msg.DeletedAt = primitive.ObjectID{}, nil
+	doc, err := bson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var reactions any
+	if len(msg.Reactions) > 0 {
+		total := 0
+		for _, count := range msg.Reactions {
+			total += count
+		}
+		reactions = total
+	}
+	_, err = tx.Exec(`INSERT INTO messages (uuid, id, message_id, grp, datetime, edited, reply_to, run_id, deleted_at, reactions, doc)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET id = excluded.id, message_id = excluded.message_id, grp = excluded.grp,
+			datetime = excluded.datetime, edited = excluded.edited, reply_to = excluded.reply_to, run_id = excluded.run_id,
+			deleted_at = excluded.deleted_at, reactions = excluded.reactions, doc = excluded.doc`,
+		msg.UUID, id[:], msg.MessageID, msg.Group, msg.Datetime.UnixMilli(), unixMilli(msg.Edited), msg.ReplyTo, msg.RunID,
+		unixMilli(deletedAt), reactions, doc)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM message_tags WHERE uuid = ?", msg.UUID); err != nil {
+		return err
+	}
+	for pos, tag := range msg.Tags {
+		if _, err := tx.Exec("INSERT INTO message_tags (uuid, pos, tag) VALUES (?, ?, ?)", msg.UUID, pos, tag); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM message_media_kinds WHERE uuid = ?", msg.UUID); err != nil {
+		return err
+	}
+	for _, media := range msg.Media {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO message_media_kinds (uuid, kind) VALUES (?, ?)", msg.UUID, media.Kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveTagChange(tx *sql.Tx, change models.TagChange) error {
+	doc, err := bson.Marshal(change)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO tag_history (uuid, grp, seen_at, doc) VALUES (?, ?, ?, ?)",
+		change.UUID, change.Group, change.SeenAt.UnixMilli(), doc)
+	if err != nil {
+		return err
+	}
+	changeID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, tag := range slices.Concat(change.Added, change.Removed) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tag_history_tags (change_id, tag) VALUES (?, ?)", changeID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowScanner is *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMessage reads messageColumns, ID and DeletedAt are kept in columns, not in the document
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var id, doc []byte
+	var deletedAt sql.NullInt64
+	if err := row.Scan(&id, &deletedAt, &doc); err != nil {
+		return nil, err
+	}
+	var msg models.Message
+	if err := bson.Unmarshal(doc, &msg); err != nil {
+		return nil, err
+	}
+	copy(msg.ID[:], id)
+	if deletedAt.Valid {
+		t := time.UnixMilli(deletedAt.Int64).UTC()
+		msg.DeletedAt = &t
+	}
+	return &msg, nil
+}
+
+func scanDocument[T any](row rowScanner) (*T, error) {
+	var doc []byte
+	if err := row.Scan(&doc); err != nil {
+		return nil, err
+	}
+	var v T
+	if err := bson.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// getDocument returns the document of the query, nil if there is no such document
+func getDocument[T any](ctx context.Context, db *sql.DB, query string, args ...any) (*T, error) {
+	v, err := scanDocument[T](db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return v, err
+}
+
+// scanAll reads and closes the rows
+func scanAll[T any](rows *sql.Rows, scan func(row rowScanner) (T, error)) ([]T, error) {
+	defer rows.Close()
+	var items []T
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// clone copies the value through BSON, so that it is the same as the value read from MongoDB
+func clone[T any](v T) (T, error) {
+	var result T
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return result, err
+	}
+	err = bson.Unmarshal(data, &result)
+	return result, err
+}
+
+func unixMilli(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
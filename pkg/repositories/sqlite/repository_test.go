@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+	"github.com/meesooqa/tgtag/pkg/repositories"
+	"github.com/meesooqa/tgtag/pkg/repositories/repotest"
+)
+
+func newTestRepository(t *testing.T, path string) *Repository {
+	repo, err := Open(slog.New(slog.NewTextHandler(io.Discard, nil)), path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, repo.Close())
+	})
+	return repo
+}
+
+func TestRepository_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T, messages ...models.Message) repositories.Repository {
+		repo := newTestRepository(t, filepath.Join(t.TempDir(), "tgtag.db"))
+		for _, msg := range messages {
+			require.NoError(t, repo.Save(context.Background(), msg))
+		}
+		return repo
+	})
+}
+
+func TestRepository_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "var", "tgtag.db")
+	repo := newTestRepository(t, path)
+	require.NoError(t, repo.Save(context.Background(), models.Message{UUID: "1", MessageID: "message1", Group: "g1", Tags: []string{"booba"}}))
+	require.NoError(t, repo.Close())
+
+	repo = newTestRepository(t, path)
+	var found []string
+	for msg, err := range repo.FindMessages(context.Background(), repositories.MessageQuery{Tags: []string{"booba"}}) {
+		require.NoError(t, err)
+		found = append(found, msg.MessageID)
+	}
+	assert.Equal(t, []string{"message1"}, found, "Сообщения остаются в файле")
+}
+
+func TestRepository_UpdateTagsWhileFinding(t *testing.T) {
+	repo := newTestRepository(t, filepath.Join(t.TempDir(), "tgtag.db"))
+	ctx := context.Background()
+	for _, uuid := range []string{"1", "2", "3"} {
+		require.NoError(t, repo.Save(ctx, models.Message{UUID: uuid, Group: "g1", Tags: []string{"Booba"}}))
+	}
+
+	// cmd/retag обновляет теги, пока читает сообщения
+	for msg, err := range repo.FindMessages(ctx, repositories.MessageQuery{}) {
+		require.NoError(t, err)
+		require.NoError(t, repo.UpdateTags(ctx, msg.UUID, msg.Tags, []string{"booba"}))
+	}
+	count, err := repo.CountMessages(ctx, repositories.MessageQuery{Tags: []string{"booba"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
@@ -0,0 +1,80 @@
+package sqlite
+
+// schema is created on Open. Documents are BSON in doc columns, so that values read back are the same as from MongoDB,
+// the other columns are the fields queries filter and sort by. Times are Unix milliseconds in UTC.
+const schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	uuid       TEXT PRIMARY KEY,
+	id         BLOB NOT NULL,
+	message_id TEXT NOT NULL,
+	grp        TEXT NOT NULL,
+	datetime   INTEGER NOT NULL,
+	edited     INTEGER,
+	reply_to   TEXT NOT NULL,
+	run_id     TEXT NOT NULL,
+	deleted_at INTEGER,
+	-- reactions is the total of all kinds, NULL if the message has no reactions
+	reactions  INTEGER,
+	doc        BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_grp_datetime ON messages (grp, datetime, uuid);
+CREATE INDEX IF NOT EXISTS messages_datetime ON messages (datetime, uuid);
+CREATE INDEX IF NOT EXISTS messages_grp_message_id ON messages (grp, message_id);
+CREATE INDEX IF NOT EXISTS messages_grp_run_id ON messages (grp, run_id);
+CREATE INDEX IF NOT EXISTS messages_edited ON messages (edited) WHERE edited IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS message_tags (
+	uuid TEXT NOT NULL REFERENCES messages (uuid) ON DELETE CASCADE,
+	pos  INTEGER NOT NULL,
+	tag  TEXT NOT NULL,
+	PRIMARY KEY (uuid, pos)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS message_tags_tag ON message_tags (tag, uuid);
+
+-- message_media_kinds are distinct media kinds of a message
+CREATE TABLE IF NOT EXISTS message_media_kinds (
+	uuid TEXT NOT NULL REFERENCES messages (uuid) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	PRIMARY KEY (uuid, kind)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS service_events (
+	uuid       TEXT PRIMARY KEY,
+	id         BLOB NOT NULL,
+	grp        TEXT NOT NULL,
+	type       TEXT NOT NULL,
+	datetime   INTEGER NOT NULL,
+	message_id TEXT NOT NULL,
+	doc        BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS service_events_grp_datetime ON service_events (grp, datetime, message_id);
+
+CREATE TABLE IF NOT EXISTS manifest (
+	path TEXT PRIMARY KEY,
+	doc  BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ingest_runs (
+	run_id     TEXT PRIMARY KEY,
+	started_at INTEGER NOT NULL,
+	doc        BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS ingest_runs_started_at ON ingest_runs (started_at);
+
+CREATE TABLE IF NOT EXISTS tag_history (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	uuid    TEXT NOT NULL,
+	grp     TEXT NOT NULL,
+	seen_at INTEGER NOT NULL,
+	doc     BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tag_history_grp_seen_at ON tag_history (grp, seen_at);
+CREATE INDEX IF NOT EXISTS tag_history_seen_at ON tag_history (seen_at);
+
+-- tag_history_tags are added and removed tags of a change
+CREATE TABLE IF NOT EXISTS tag_history_tags (
+	change_id INTEGER NOT NULL REFERENCES tag_history (id) ON DELETE CASCADE,
+	tag       TEXT NOT NULL,
+	PRIMARY KEY (tag, change_id)
+) WITHOUT ROWID;
+`
@@ -0,0 +1,113 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/meesooqa/tgtag/pkg/repositories"
+)
+
+// GetTagCounts counts messages per group and tag: groups in order, the most used tags of a group first
+func (r *Repository) GetTagCounts(ctx context.Context, group string) ([]repositories.TagCount, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT m.grp, t.tag, COUNT(*) AS count
+		FROM messages m
+		JOIN message_tags t ON t.uuid = m.uuid
+		WHERE m.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+		GROUP BY m.grp, t.tag
+		ORDER BY m.grp, count DESC, t.tag`, group, group)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	return scanAll(rows, func(row rowScanner) (repositories.TagCount, error) {
+		var item repositories.TagCount
+		err := row.Scan(&item.Group, &item.Tag, &item.Count)
+		return item, err
+	})
+}
+
+// GetTagCountsByPeriod counts messages per tag and period in loc, UTC if loc is nil, the oldest period first.
+// SQLite has no time zones, so messages are counted per datetime by SQLite and per period in Go.
+func (r *Repository) GetTagCountsByPeriod(ctx context.Context, group string, period repositories.Period, loc *time.Location) ([]repositories.TagPeriodCount, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	if err := period.Validate(loc); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.tag, m.datetime, COUNT(*)
+		FROM messages m
+		JOIN message_tags t ON t.uuid = m.uuid
+		WHERE m.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+		GROUP BY t.tag, m.datetime`, group, group)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	counts, err := scanAll(rows, func(row rowScanner) (repositories.TagPeriodCount, error) {
+		var item repositories.TagPeriodCount
+		var datetime int64
+		err := row.Scan(&item.Tag, &datetime, &item.Count)
+		item.Start = period.Truncate(time.UnixMilli(datetime), loc)
+		return item, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(counts, func(a, b repositories.TagPeriodCount) int {
+		return cmp.Or(a.Start.Compare(b.Start), strings.Compare(a.Tag, b.Tag))
+	})
+	var items []repositories.TagPeriodCount
+	for _, c := range counts {
+		if n := len(items); n > 0 && items[n-1].Tag == c.Tag && items[n-1].Start.Equal(c.Start) {
+			items[n-1].Count += c.Count
+			continue
+		}
+		items = append(items, c)
+	}
+	return items, nil
+}
+
+// GetTagsHistogram counts messages per number of their tags, messages without tags are counted too
+func (r *Repository) GetTagsHistogram(ctx context.Context, group string) ([]repositories.TagsHistogramBucket, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM message_tags t WHERE t.uuid = m.uuid) AS tags, COUNT(*)
+		FROM messages m
+		WHERE m.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+		GROUP BY tags
+		ORDER BY tags`, group, group)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	return scanAll(rows, func(row rowScanner) (repositories.TagsHistogramBucket, error) {
+		var item repositories.TagsHistogramBucket
+		err := row.Scan(&item.Tags, &item.Messages)
+		return item, err
+	})
+}
+
+// GetTagSpans returns the first and the last message datetime per tag, in the order of tags
+func (r *Repository) GetTagSpans(ctx context.Context, group string) ([]repositories.TagSpan, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.tag, MIN(m.datetime), MAX(m.datetime), COUNT(*)
+		FROM messages m
+		JOIN message_tags t ON t.uuid = m.uuid
+		WHERE m.deleted_at IS NULL AND (? = '' OR m.grp = ?)
+		GROUP BY t.tag
+		ORDER BY t.tag`, group, group)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate failed: %w", err)
+	}
+	return scanAll(rows, func(row rowScanner) (repositories.TagSpan, error) {
+		var item repositories.TagSpan
+		var first, last int64
+		err := row.Scan(&item.Tag, &first, &last, &item.Messages)
+		item.FirstSeen, item.LastSeen = time.UnixMilli(first).UTC(), time.UnixMilli(last).UTC()
+		return item, err
+	})
+}
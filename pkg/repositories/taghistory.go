@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/meesooqa/tgtag/pkg/models"
+)
+
+// tagRecorder находит изменения тегов батча до записи и сохраняет их после записи
+type tagRecorder interface {
+	findTagChanges(ctx context.Context, docs []bson.M) ([]models.TagChange, error)
+	saveTagChanges(ctx context.Context, changes []models.TagChange) error
+}
+
+// findTagChanges compares tags of the documents with the saved messages, new messages are not changes
+func (r *MessageRepository) findTagChanges(ctx context.Context, docs []bson.M) ([]models.TagChange, error) {
+	uuids := make([]any, 0, len(docs))
+	for _, doc := range docs {
+		uuids = append(uuids, doc["uuid"])
+	}
+	opts := options.Find().SetProjection(bson.M{"uuid": 1, "tags": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"uuid": bson.M{"$in": uuids}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("find failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var saved []struct {
+		UUID string   `bson:"uuid"`
+		Tags []string `bson:"tags"`
+	}
+	if err := cursor.All(ctx, &saved); err != nil {
+		return nil, err
+	}
+	prevTags := make(map[string][]string, len(saved))
+	for _, msg := range saved {
+		prevTags[msg.UUID] = msg.Tags
+	}
+	return diffTags(docs, prevTags, time.Now().UTC()), nil
+}
+
+// saveTagChanges adds changes to the history
+func (r *MessageRepository) saveTagChanges(ctx context.Context, changes []models.TagChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	docs := make([]any, 0, len(changes))
+	for _, change := range changes {
+		docs = append(docs, change)
+	}
+	if _, err := r.history.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("insert failed: %w", err)
+	}
+	return nil
+}
+
+// GetTagChanges returns tag changes of the group where the tag is added or removed, the last seen first.
+// The empty group or tag means any, limit <= 0 means no limit.
+func (r *MessageRepository) GetTagChanges(ctx context.Context, group, tag string, limit int) ([]*models.TagChange, error) {
+	filter := matchGroup(group, bson.M{})
+	if tag != "" {
+		filter["$or"] = bson.A{bson.M{"added": tag}, bson.M{"removed": tag}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "seen_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.history.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var items []*models.TagChange
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// diffTags returns changes of the documents with saved tags, the order of tags is not a change.
+// The last document of a UUID wins as it does in the bulk write.
+func diffTags(docs []bson.M, prevTags map[string][]string, seenAt time.Time) []models.TagChange {
+	last := make(map[string]bson.M, len(docs))
+	var uuids []string
+	for _, doc := range docs {
+		uuid, _ := doc["uuid"].(string)
+		if _, ok := last[uuid]; !ok {
+			uuids = append(uuids, uuid)
+		}
+		last[uuid] = doc
+	}
+
+	var changes []models.TagChange
+	for _, uuid := range uuids {
+		prev, ok := prevTags[uuid]
+		if !ok {
+			continue
+		}
+		doc := last[uuid]
+		tags := docStrings(doc["tags"])
+		added, removed := DiffTags(prev, tags)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		change := models.TagChange{
+			UUID:     uuid,
+			PrevTags: prev,
+			Tags:     tags,
+			Added:    added,
+			Removed:  removed,
+			SeenAt:   seenAt,
+		}
+		change.MessageID, _ = doc["message_id"].(string)
+		change.Group, _ = doc["group"].(string)
+		change.RunID, _ = doc["run_id"].(string)
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// NewTagChange returns the change of tags of the saved message to tags made by the run, runID is empty for changes
+// made out of runs (cmd/retag). False is returned if tags are the same but for the order.
+func NewTagChange(prev models.Message, tags []string, runID string, seenAt time.Time) (models.TagChange, bool) {
+	added, removed := DiffTags(prev.Tags, tags)
+	if len(added) == 0 && len(removed) == 0 {
+		return models.TagChange{}, false
+	}
+	return models.TagChange{
+		UUID:      prev.UUID,
+		MessageID: prev.MessageID,
+		Group:     prev.Group,
+		PrevTags:  prev.Tags,
+		Tags:      tags,
+		Added:     added,
+		Removed:   removed,
+		SeenAt:    seenAt,
+		RunID:     runID,
+	}, true
+}
+
+// DiffTags returns tags of next missing in prev and tags of prev missing in next
+func DiffTags(prev, next []string) (added, removed []string) {
+	for _, tag := range next {
+		if !slices.Contains(prev, tag) && !slices.Contains(added, tag) {
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range prev {
+		if !slices.Contains(next, tag) && !slices.Contains(removed, tag) {
+			removed = append(removed, tag)
+		}
+	}
+	return added, removed
+}
+
+// docStrings reads a string list of a document: []string of UpsertMany or bson array of dead letters
+func docStrings(v any) []string {
+	switch values := v.(type) {
+	case []string:
+		return values
+	case primitive.A:
+		return convertToStrings(values)
+	}
+	return nil
+}
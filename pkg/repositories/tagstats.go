@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Period is the time bucket of GetTagCountsByPeriod, the values are units of $dateTrunc
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// Validate checks the period and the location, MongoDB needs the IANA name of the location:
+// time.Local and fixed zones (time.FixedZone("UTC+3", ...)) have none
+func (p Period) Validate(loc *time.Location) error {
+	switch p {
+	case PeriodDay, PeriodWeek, PeriodMonth:
+	default:
+		return fmt.Errorf("unknown period %q", p)
+	}
+	if loc == time.Local {
+		return fmt.Errorf("location %s has no IANA name, use time.LoadLocation", loc)
+	}
+	if _, err := time.LoadLocation(loc.String()); err != nil {
+		return fmt.Errorf("location %s has no IANA name, use time.LoadLocation: %w", loc, err)
+	}
+	return nil
+}
+
+// Truncate returns the start of the period of t in loc, weeks start on Monday as in ISO 8601
+func (p Period) Truncate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch p {
+	case PeriodWeek:
+		days := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, loc)
+	case PeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// GetTagCounts counts messages per group and tag: groups in order, the most used tags of a group first
+func (r *MessageRepository) GetTagCounts(ctx context.Context, group string) ([]TagCount, error) {
+	var items []TagCount
+	if err := r.aggregate(ctx, tagCountsPipeline(group), &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetTagCountsByPeriod counts messages per tag and period in loc, UTC if loc is nil.
+// Starts of periods are in loc, the oldest period first.
+func (r *MessageRepository) GetTagCountsByPeriod(ctx context.Context, group string, period Period, loc *time.Location) ([]TagPeriodCount, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	if err := period.Validate(loc); err != nil {
+		return nil, err
+	}
+	var items []TagPeriodCount
+	if err := r.aggregate(ctx, tagCountsByPeriodPipeline(group, period, loc), &items); err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Start = items[i].Start.In(loc)
+	}
+	return items, nil
+}
+
+// GetTagsHistogram counts messages per number of their tags, messages without tags are counted too
+func (r *MessageRepository) GetTagsHistogram(ctx context.Context, group string) ([]TagsHistogramBucket, error) {
+	var items []TagsHistogramBucket
+	if err := r.aggregate(ctx, tagsHistogramPipeline(group), &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetTagSpans returns the first and the last message datetime per tag, in the order of tags
+func (r *MessageRepository) GetTagSpans(ctx context.Context, group string) ([]TagSpan, error) {
+	var items []TagSpan
+	if err := r.aggregate(ctx, tagSpansPipeline(group), &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *MessageRepository) aggregate(ctx context.Context, pipeline mongo.Pipeline, result any) error {
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("aggregate failed: %w", err)
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, result)
+}
+
+func tagCountsPipeline(group string) mongo.Pipeline {
+	return mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{}))}},
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"group": "$group", "tag": "$tags"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "group": "$_id.group", "tag": "$_id.tag", "count": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "group", Value: 1}, {Key: "count", Value: -1}, {Key: "tag", Value: 1}}}},
+	}
+}
+
+func tagCountsByPeriodPipeline(group string, period Period, loc *time.Location) mongo.Pipeline {
+	trunc := bson.M{"date": "$datetime", "unit": string(period), "timezone": loc.String()}
+	if period == PeriodWeek {
+		trunc["startOfWeek"] = "monday"
+	}
+	return mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{}))}},
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"tag": "$tags", "start": bson.M{"$dateTrunc": trunc}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "tag": "$_id.tag", "start": "$_id.start", "count": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "start", Value: 1}, {Key: "tag", Value: 1}}}},
+	}
+}
+
+func tagsHistogramPipeline(group string) mongo.Pipeline {
+	return mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{}))}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"$size": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}},
+			"messages": bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "tags": "$_id", "messages": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "tags", Value: 1}}}},
+	}
+}
+
+func tagSpansPipeline(group string) mongo.Pipeline {
+	return mongo.Pipeline{
+		bson.D{{Key: "$match", Value: MatchDeleted(matchGroup(group, bson.M{}))}},
+		bson.D{{Key: "$unwind", Value: "$tags"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":        "$tags",
+			"first_seen": bson.M{"$min": "$datetime"},
+			"last_seen":  bson.M{"$max": "$datetime"},
+			"messages":   bson.M{"$sum": 1},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "tag": "$_id", "first_seen": 1, "last_seen": 1, "messages": 1}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "tag", Value: 1}}}},
+	}
+}